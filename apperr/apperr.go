@@ -0,0 +1,198 @@
+package apperr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Domain is the google.rpc.ErrorInfo domain of catalog errors
+const Domain = "gogrpcapi"
+
+// appCodeKey is the google.rpc.ErrorInfo metadata key holding the AppCode
+const appCodeKey = "code"
+
+// Error is an entry of the error catalog
+// The AppCode and Reason are stable and identify the error to clients. The Message is safe to return to clients,
+// it must never contain internal details (ex: a database message).
+type Error struct {
+	AppCode    int64
+	Reason     string
+	Code       codes.Code
+	HTTPStatus int
+	Message    string
+	Details    []proto.Message // Added to the status after the google.rpc.ErrorInfo (ex: a google.rpc.BadRequest)
+}
+
+// The error catalog, AppCodes must never be changed or reused
+var (
+	ErrInternal             = &Error{AppCode: 1000, Reason: "INTERNAL", Code: codes.Internal, HTTPStatus: http.StatusInternalServerError, Message: "Internal Error"}
+	ErrInvalidArgument      = &Error{AppCode: 1001, Reason: "INVALID_ARGUMENT", Code: codes.InvalidArgument, HTTPStatus: http.StatusBadRequest, Message: "Invalid Argument"}
+	ErrNotFound             = &Error{AppCode: 1002, Reason: "NOT_FOUND", Code: codes.NotFound, HTTPStatus: http.StatusNotFound, Message: "Not Found"}
+	ErrAlreadyExists        = &Error{AppCode: 1003, Reason: "ALREADY_EXISTS", Code: codes.AlreadyExists, HTTPStatus: http.StatusConflict, Message: "Already Exists"}
+	ErrEtagMismatch         = &Error{AppCode: 1004, Reason: "ETAG_MISMATCH", Code: codes.FailedPrecondition, HTTPStatus: http.StatusPreconditionFailed, Message: "Etag Mismatch"}
+	ErrIdempotencyKeyReused = &Error{AppCode: 1005, Reason: "IDEMPOTENCY_KEY_REUSED", Code: codes.InvalidArgument, HTTPStatus: http.StatusUnprocessableEntity, Message: "Idempotency Key Reused"}
+	ErrUnauthenticated      = &Error{AppCode: 1006, Reason: "UNAUTHENTICATED", Code: codes.Unauthenticated, HTTPStatus: http.StatusUnauthorized, Message: "Unauthenticated"}
+	ErrPermissionDenied     = &Error{AppCode: 1007, Reason: "PERMISSION_DENIED", Code: codes.PermissionDenied, HTTPStatus: http.StatusForbidden, Message: "Permission Denied"}
+	ErrFailedPrecondition   = &Error{AppCode: 1008, Reason: "FAILED_PRECONDITION", Code: codes.FailedPrecondition, HTTPStatus: http.StatusBadRequest, Message: "Failed Precondition"}
+	ErrResourceExhausted    = &Error{AppCode: 1009, Reason: "RESOURCE_EXHAUSTED", Code: codes.ResourceExhausted, HTTPStatus: http.StatusTooManyRequests, Message: "Resource Exhausted"}
+	ErrCanceled             = &Error{AppCode: 1010, Reason: "CANCELED", Code: codes.Canceled, HTTPStatus: 499, Message: "Canceled"}
+	ErrDeadlineExceeded     = &Error{AppCode: 1011, Reason: "DEADLINE_EXCEEDED", Code: codes.DeadlineExceeded, HTTPStatus: http.StatusGatewayTimeout, Message: "Deadline Exceeded"}
+	ErrUnimplemented        = &Error{AppCode: 1012, Reason: "UNIMPLEMENTED", Code: codes.Unimplemented, HTTPStatus: http.StatusNotImplemented, Message: "Not Implemented"}
+	ErrUnavailable          = &Error{AppCode: 1013, Reason: "UNAVAILABLE", Code: codes.Unavailable, HTTPStatus: http.StatusServiceUnavailable, Message: "Unavailable"}
+)
+
+// catalog holds every catalog error by AppCode
+var catalog = make(map[int64]*Error)
+
+// byCode holds the generic catalog error of each gRPC code
+var byCode = make(map[codes.Code]*Error)
+
+func init() {
+	for _, e := range []*Error{ErrInternal, ErrInvalidArgument, ErrNotFound, ErrAlreadyExists, ErrEtagMismatch, ErrIdempotencyKeyReused,
+		ErrUnauthenticated, ErrPermissionDenied, ErrFailedPrecondition, ErrResourceExhausted, ErrCanceled, ErrDeadlineExceeded,
+		ErrUnimplemented, ErrUnavailable} {
+		catalog[e.AppCode] = e
+	}
+	for _, e := range []*Error{ErrInternal, ErrInvalidArgument, ErrNotFound, ErrAlreadyExists, ErrUnauthenticated, ErrPermissionDenied,
+		ErrFailedPrecondition, ErrResourceExhausted, ErrCanceled, ErrDeadlineExceeded, ErrUnimplemented, ErrUnavailable} {
+		byCode[e.Code] = e
+	}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is reports whether target is the same catalog error, so errors.Is matches copies made by Errorf and WithDetails
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.AppCode == e.AppCode
+}
+
+// Errorf returns a copy of the error with a more specific message, it must be safe to return to clients
+func (e *Error) Errorf(format string, args ...interface{}) *Error {
+	c := *e
+	c.Message = fmt.Sprintf(format, args...)
+	return &c
+}
+
+// WithDetails returns a copy of the error with more details added to its status
+func (e *Error) WithDetails(details ...proto.Message) *Error {
+	c := *e
+	c.Details = append(append([]proto.Message(nil), e.Details...), details...)
+	return &c
+}
+
+// GRPCStatus returns the status sent to gRPC clients, the first detail is a google.rpc.ErrorInfo with the AppCode
+func (e *Error) GRPCStatus() *status.Status {
+
+	st := status.New(e.Code, e.Message)
+	details := append([]proto.Message{&errdetails.ErrorInfo{
+		Reason:   e.Reason,
+		Domain:   Domain,
+		Metadata: map[string]string{appCodeKey: strconv.FormatInt(e.AppCode, 10)},
+	}}, e.Details...)
+	if withDetails, err := st.WithDetails(details...); err == nil {
+		return withDetails
+	}
+	return st
+
+}
+
+// FieldError is an error caused by the value of a field (ex: ErrAlreadyExists for a duplicate name)
+// Use errors.Is to check for the catalog error and errors.As to get the field.
+type FieldError struct {
+	Err   error
+	Field string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Err, e.Field)
+}
+
+// Unwrap returns the catalog error
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// fieldDescriptions are the field violation descriptions of catalog errors caused by a field
+var fieldDescriptions = map[int64]string{
+	ErrAlreadyExists.AppCode: "must be unique",
+}
+
+// GRPCStatus returns the status of the catalog error with the field as a google.rpc.BadRequest field violation
+func (e *FieldError) GRPCStatus() *status.Status {
+
+	var ae *Error
+	if !errors.As(e.Err, &ae) {
+		return ErrInternal.GRPCStatus()
+	} else if e.Field == "" {
+		return ae.GRPCStatus()
+	}
+
+	description, ok := fieldDescriptions[ae.AppCode]
+	if !ok {
+		description = ae.Message
+	}
+	return ae.Errorf("%s: %s", ae.Message, e.Field).WithDetails(&errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{{
+			Field:       e.Field,
+			Description: description,
+		}},
+	}).GRPCStatus()
+
+}
+
+// Public returns the error to send to clients in place of err
+// Catalog errors, errors wrapping them and gRPC status errors are safe to return. Any other error may contain internal
+// details so ErrInternal is returned in its place and internal is true so the caller can log err.
+func Public(err error) (public error, internal bool) {
+
+	var se interface{ GRPCStatus() *status.Status }
+	switch {
+	case err == nil:
+		return nil, false
+	case errors.As(err, &se):
+		return se.(error), false
+	case errors.Is(err, context.Canceled):
+		return ErrCanceled, false
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrDeadlineExceeded, false
+	}
+	return ErrInternal, true
+
+}
+
+// FromStatus returns the catalog error of a status
+// It is found by the AppCode in the status details, statuses without one get the generic error of their code.
+func FromStatus(st *status.Status) *Error {
+
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.Domain == Domain {
+			if appCode, err := strconv.ParseInt(info.Metadata[appCodeKey], 10, 64); err == nil {
+				if e, ok := catalog[appCode]; ok {
+					return e
+				}
+			}
+		}
+	}
+	if e, ok := byCode[st.Code()]; ok {
+		return e
+	}
+	return ErrInternal
+
+}
+
+// IsErrorInfo reports whether a status detail is the google.rpc.ErrorInfo added by the catalog
+func IsErrorInfo(detail interface{}) bool {
+	info, ok := detail.(*errdetails.ErrorInfo)
+	return ok && info.Domain == Domain
+}
@@ -0,0 +1,84 @@
+package apperr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestErrorStatus(t *testing.T) {
+
+	err := ErrInvalidArgument.Errorf("Invalid ID")
+	assert.True(t, errors.Is(err, ErrInvalidArgument))
+	assert.False(t, errors.Is(err, ErrNotFound))
+	assert.Equal(t, "Invalid Argument", ErrInvalidArgument.Message)
+
+	st := status.Convert(err)
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+	assert.Equal(t, "Invalid ID", st.Message())
+	if assert.Len(t, st.Details(), 1) {
+		info := st.Details()[0].(*errdetails.ErrorInfo)
+		assert.Equal(t, "INVALID_ARGUMENT", info.Reason)
+		assert.Equal(t, Domain, info.Domain)
+		assert.Equal(t, "1001", info.Metadata["code"])
+	}
+	assert.Equal(t, ErrInvalidArgument, FromStatus(st))
+
+	// Catalog errors with the same gRPC code are told apart by the AppCode
+	assert.Equal(t, ErrIdempotencyKeyReused, FromStatus(status.Convert(ErrIdempotencyKeyReused)))
+	assert.Equal(t, ErrEtagMismatch, FromStatus(status.Convert(ErrEtagMismatch)))
+
+	// Statuses without an AppCode use the generic error of their code
+	assert.Equal(t, ErrNotFound, FromStatus(status.New(codes.NotFound, "Not Found")))
+	assert.Equal(t, ErrInternal, FromStatus(status.New(codes.DataLoss, "lost")))
+
+}
+
+func TestFieldErrorStatus(t *testing.T) {
+
+	err := fmt.Errorf("saving: %w", &FieldError{Err: ErrAlreadyExists, Field: "name"})
+	assert.True(t, errors.Is(err, ErrAlreadyExists))
+
+	public, internal := Public(err)
+	assert.False(t, internal)
+	st := status.Convert(public)
+	assert.Equal(t, codes.AlreadyExists, st.Code())
+	assert.Equal(t, "Already Exists: name", st.Message())
+	if assert.Len(t, st.Details(), 2) {
+		assert.Equal(t, []*errdetails.BadRequest_FieldViolation{{Field: "name", Description: "must be unique"}}, st.Details()[1].(*errdetails.BadRequest).FieldViolations)
+	}
+
+}
+
+func TestPublic(t *testing.T) {
+
+	public, internal := Public(nil)
+	assert.Nil(t, public)
+	assert.False(t, internal)
+
+	// Catalog and status errors are safe
+	public, internal = Public(fmt.Errorf("get: %w", ErrNotFound))
+	assert.Equal(t, ErrNotFound, public)
+	assert.False(t, internal)
+
+	st := status.New(codes.Unavailable, "Unavailable")
+	public, internal = Public(st.Err())
+	assert.Equal(t, st.Err(), public)
+	assert.False(t, internal)
+
+	public, internal = Public(fmt.Errorf("query: %w", context.Canceled))
+	assert.Equal(t, ErrCanceled, public)
+	assert.False(t, internal)
+
+	// Anything else could leak internal details
+	public, internal = Public(errors.New(`pq: relation "thing" does not exist`))
+	assert.Equal(t, ErrInternal, public)
+	assert.True(t, internal)
+
+}
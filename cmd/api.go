@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"expvar"
+
 	cli "github.com/spf13/cobra"
 	config "github.com/spf13/viper"
 	"go.uber.org/zap"
@@ -10,6 +12,10 @@ import (
 	"github.com/snowzach/gogrpcapi/thingrpc"
 	"github.com/snowzach/gogrpcapi/server"
 	"github.com/snowzach/gogrpcapi/store/postgres"
+	"github.com/snowzach/gogrpcapi/store/memory"
+	"github.com/snowzach/gogrpcapi/store/sqlite"
+	"github.com/snowzach/gogrpcapi/store/bolt"
+	"github.com/snowzach/gogrpcapi/store/cache"
 )
 
 func init() {
@@ -28,11 +34,26 @@ var (
 			switch config.GetString("storage.type") {
 			case "postgres":
 				thingStore, err = postgres.New()
+			case "memory":
+				thingStore, err = memory.New()
+			case "sqlite":
+				thingStore, err = sqlite.New()
+			case "bolt":
+				thingStore, err = bolt.New()
+			default:
+				logger.Fatalw("Unknown storage type", "storage.type", config.GetString("storage.type"))
 			}
 			if err != nil {
 				logger.Fatalw("Database Error", "error", err)
 			}
 
+			// Cache things fetched by id, the counters are published with expvar at /debug/vars
+			if config.GetBool("storage.cache.enabled") {
+				thingCache := cache.New(thingStore, config.GetInt("storage.cache.size"), config.GetDuration("storage.cache.ttl"))
+				expvar.Publish("storage.cache", expvar.Func(func() interface{} { return thingCache.Stats() }))
+				thingStore = thingCache
+			}
+
 			// Create the GRPC/HTTP server
 			s, err := server.New()
 			if err != nil {
@@ -52,6 +73,7 @@ var (
 			// Register the Thing RPC server to the GRPC Server
 			thingrpc.RegisterThingRPCServer(s.GRPCServer(), rpcserver)
 			s.GwReg(thingrpc.RegisterThingRPCHandlerFromEndpoint)
+			s.GwReg(thingrpc.RegisterThingRPCBulkHandlerFromEndpoint)
 
 			err = s.ListenAndServe()
 			if err != nil {
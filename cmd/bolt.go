@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+
+	cli "github.com/spf13/cobra"
+	config "github.com/spf13/viper"
+
+	"github.com/snowzach/gogrpcapi/store/bolt"
+)
+
+func init() {
+
+	boltCmd := &cli.Command{
+		Use:   "bolt",
+		Short: "Bolt database maintenance",
+		Long:  `Maintain the bolt database at storage.path, the api must not be running`,
+	}
+
+	boltCmd.AddCommand(&cli.Command{
+		Use:   "backup <file>",
+		Short: "Backup the database",
+		Long:  `Write a copy of the bolt database to file`,
+		Args:  cli.ExactArgs(1),
+		Run: func(cmd *cli.Command, args []string) {
+			if err := bolt.Backup(config.GetString("storage.path"), args[0]); err != nil {
+				logger.Fatalw("Could not backup database", "error", err)
+			}
+			fmt.Printf("Backup written to %s\n", args[0])
+		},
+	})
+
+	boltCmd.AddCommand(&cli.Command{
+		Use:   "compact <file>",
+		Short: "Compact the database",
+		Long:  `Write a compacted copy of the bolt database to file, replace the database with it to reclaim space`,
+		Args:  cli.ExactArgs(1),
+		Run: func(cmd *cli.Command, args []string) {
+			if err := bolt.Compact(config.GetString("storage.path"), args[0]); err != nil {
+				logger.Fatalw("Could not compact database", "error", err)
+			}
+			fmt.Printf("Compacted database written to %s\n", args[0])
+		},
+	})
+
+	rootCmd.AddCommand(boltCmd)
+
+}
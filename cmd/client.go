@@ -57,7 +57,7 @@ func init() {
 			thingClient := thingrpc.NewThingRPCClient(conn)
 
 			// Make RPC call
-			things, err := thingClient.ThingFind(context.Background(), &emptypb.Empty{})
+			things, err := thingClient.ThingFind(context.Background(), &thingrpc.ThingFindRequest{})
 			if err != nil {
 				logger.Fatalw("Could not call ThingFind", "error", err)
 			}
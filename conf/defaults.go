@@ -44,6 +44,12 @@ func init() {
 	config.SetDefault("server.cors.allowed_headers", []string{"*"})
 	config.SetDefault("server.cors.allowed_credentials", false)
 	config.SetDefault("server.cors.max_age", 300)
+	// Authenticate requests with one of the bearer tokens, a list of {token, subject, tenant}
+	// Off by default so a development api starts without tokens, the server logs a warning
+	config.SetDefault("server.auth.enabled", false)
+	config.SetDefault("server.auth.tokens", []map[string]string{})
+	// Without authentication, reject requests without an x-tenant header/metadata instead of using the default tenant
+	config.SetDefault("server.tenant.required", false)
 	// GRPC JSON Marshaler Options
 	config.SetDefault("server.rest.enums_as_ints", false)
 	config.SetDefault("server.rest.emit_defaults", true)
@@ -57,9 +63,20 @@ func init() {
 	config.SetDefault("storage.port", 5432)
 	config.SetDefault("storage.database", "gogrpcapi")
 	config.SetDefault("storage.sslmode", "disable")
+	// Allow a superuser or a role with BYPASSRLS for development, tenants are then only isolated by the queries
+	config.SetDefault("storage.allow_bypass_rls", false)
 	config.SetDefault("storage.retries", 5)
 	config.SetDefault("storage.sleep_between_retries", "7s")
 	config.SetDefault("storage.max_connections", 80)
 	config.SetDefault("storage.wipe_confirm", false)
+	config.SetDefault("storage.purge_after", "720h")
+	config.SetDefault("storage.purge_interval", "1h")
+	config.SetDefault("storage.idempotency_window", "24h")
+	config.SetDefault("storage.unique_names", false)
+	config.SetDefault("storage.memory.snapshot", "")
+	config.SetDefault("storage.path", "gogrpcapi.db")
+	config.SetDefault("storage.cache.enabled", false)
+	config.SetDefault("storage.cache.size", 10000)
+	config.SetDefault("storage.cache.ttl", "1m")
 
 }
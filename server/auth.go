@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
+
+	"github.com/snowzach/gogrpcapi/apperr"
+	"github.com/snowzach/gogrpcapi/store"
+)
+
+const actorMetadataKey = "x-user" // Set from the X-User header by the grpc gateway
+
+// authToken is a bearer token clients authenticate with and who it belongs to
+type authToken struct {
+	Token   string `mapstructure:"token"`
+	Subject string `mapstructure:"subject"` // Who is making requests with the token
+	Tenant  string `mapstructure:"tenant"`  // The only tenant requests with the token can use
+}
+
+// principal is the authenticated client making a request
+type principal struct {
+	Subject string
+	Tenant  string
+}
+
+type principalContextKey struct{}
+
+// requestPrincipal returns the authenticated client of the request, nil if authentication is disabled
+func requestPrincipal(ctx context.Context) *principal {
+	p, _ := ctx.Value(principalContextKey{}).(*principal)
+	return p
+}
+
+// authenticator checks the bearer token of requests
+type authenticator struct {
+	principals map[[sha256.Size]byte]*principal // By the hash of the token so lookups do not depend on its value
+}
+
+// newAuthenticator returns an authenticator accepting the tokens, every token needs a subject and a tenant
+func newAuthenticator(tokens []authToken) (*authenticator, error) {
+
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no tokens configured")
+	}
+
+	a := &authenticator{
+		principals: make(map[[sha256.Size]byte]*principal),
+	}
+	for i, t := range tokens {
+		if t.Token == "" || t.Subject == "" {
+			return nil, fmt.Errorf("token %d needs a token and subject", i)
+		}
+		if !tenantRegexp.MatchString(t.Tenant) {
+			return nil, fmt.Errorf("token %d has an invalid tenant: %q", i, t.Tenant)
+		}
+		sum := sha256.Sum256([]byte(t.Token))
+		if _, ok := a.principals[sum]; ok {
+			return nil, fmt.Errorf("token %d is a duplicate", i)
+		}
+		a.principals[sum] = &principal{Subject: t.Subject, Tenant: t.Tenant}
+	}
+	return a, nil
+
+}
+
+// authenticate adds the principal of the bearer token in the authorization metadata (Authorization header) to the context
+// It is a grpc_auth.AuthFunc, services can override it with an AuthFuncOverride method.
+func (a *authenticator) authenticate(ctx context.Context) (context.Context, error) {
+
+	token, err := grpc_auth.AuthFromMD(ctx, "bearer")
+	if err != nil {
+		return nil, apperr.ErrUnauthenticated.Errorf("A bearer token is required")
+	}
+	p, ok := a.principals[sha256.Sum256([]byte(strings.TrimSpace(token)))]
+	if !ok {
+		return nil, apperr.ErrUnauthenticated.Errorf("Invalid bearer token")
+	}
+	return context.WithValue(ctx, principalContextKey{}, p), nil
+
+}
+
+// authFunc returns the grpc_auth.AuthFunc authenticating requests, recording who makes them and limiting the store to
+// their tenant. Authentication is disabled if the authenticator is nil. Services with an AuthFuncOverride method
+// (ex: version) skip all of it and cannot use the store.
+func authFunc(a *authenticator, tenantRequired bool) grpc_auth.AuthFunc {
+	return func(ctx context.Context) (context.Context, error) {
+		if a != nil {
+			var err error
+			if ctx, err = a.authenticate(ctx); err != nil {
+				return nil, err
+			}
+		}
+		return tenantContext(actorContext(ctx), tenantRequired)
+	}
+}
+
+// actorContext adds who is making the request to the context for the revision history of the store
+// Authenticated requests use the subject of their credentials. Without authentication the actor comes from the request
+// metadata, which any client can set, so it is only a hint and must not be trusted.
+func actorContext(ctx context.Context) context.Context {
+
+	if p := requestPrincipal(ctx); p != nil {
+		return store.WithActor(ctx, p.Subject)
+	}
+	if actor := grpcMetadataGetFirst(ctx, actorMetadataKey); actor != "" {
+		return store.WithActor(ctx, actor)
+	}
+	return ctx
+
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/snowzach/gogrpcapi/store"
+)
+
+func TestAuthenticate(t *testing.T) {
+
+	a, err := newAuthenticator([]authToken{
+		{Token: "secret1", Subject: "user1", Tenant: "tenant1"},
+		{Token: "secret2", Subject: "user2", Tenant: "tenant2"},
+	})
+	assert.Nil(t, err)
+
+	withAuthorization := func(authorization string, pairs ...string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(append([]string{"authorization", authorization}, pairs...)...))
+	}
+
+	ctx, err := a.authenticate(withAuthorization("Bearer secret2"))
+	assert.Nil(t, err)
+	assert.Equal(t, &principal{Subject: "user2", Tenant: "tenant2"}, requestPrincipal(ctx))
+
+	for _, bad := range []string{"", "Bearer", "Bearer nope", "Basic secret1", "secret1"} {
+		_, err = a.authenticate(withAuthorization(bad))
+		assert.Equal(t, codes.Unauthenticated, status.Code(err), bad)
+	}
+	_, err = a.authenticate(context.Background())
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// The tenant and actor come from the token
+	auth := authFunc(a, true)
+	ctx, err = auth(withAuthorization("Bearer secret1", actorMetadataKey, "user2"))
+	assert.Nil(t, err)
+	assert.Equal(t, "tenant1", store.Tenant(ctx))
+	assert.Equal(t, "user1", store.Actor(ctx))
+	_, err = auth(withAuthorization("Bearer secret1", tenantMetadataKey, "tenant2"))
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// Without authentication the tenant and actor come from the metadata
+	ctx, err = authFunc(nil, true)(metadata.NewIncomingContext(context.Background(), metadata.Pairs(tenantMetadataKey, "tenant2", actorMetadataKey, "user2")))
+	assert.Nil(t, err)
+	assert.Equal(t, "tenant2", store.Tenant(ctx))
+	assert.Equal(t, "user2", store.Actor(ctx))
+	_, err = authFunc(nil, true)(context.Background())
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+}
+
+func TestNewAuthenticator(t *testing.T) {
+
+	_, err := newAuthenticator(nil)
+	assert.NotNil(t, err)
+
+	for _, tokens := range [][]authToken{
+		{{Token: "", Subject: "user1", Tenant: "tenant1"}},
+		{{Token: "secret1", Subject: "", Tenant: "tenant1"}},
+		{{Token: "secret1", Subject: "user1", Tenant: ""}},
+		{{Token: "secret1", Subject: "user1", Tenant: "*"}},
+		{{Token: "secret1", Subject: "user1", Tenant: "tenant1"}, {Token: "secret1", Subject: "user2", Tenant: "tenant2"}},
+	} {
+		_, err = newAuthenticator(tokens)
+		assert.NotNil(t, err, tokens)
+	}
+
+}
@@ -1,9 +1,15 @@
 package server
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
 
 	"github.com/go-chi/render"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+
+	"github.com/snowzach/gogrpcapi/apperr"
 )
 
 // ErrResponse is a generic struct for returning a standard error document
@@ -14,6 +20,8 @@ type ErrResponse struct {
 	StatusText string `json:"status"`          // user-level status message
 	AppCode    int64  `json:"code,omitempty"`  // application-specific error code
 	ErrorText  string `json:"error,omitempty"` // application-level error message, for debugging
+
+	Details []json.RawMessage `json:"details,omitempty"` // google.rpc status details (ex: google.rpc.BadRequest)
 }
 
 // ErrNotFound is a pre-built not-found error
@@ -54,3 +62,30 @@ func ErrInternal(err error) render.Renderer {
 		ErrorText:      "Server Error.",
 	}
 }
+
+// publicError returns the error sent to gRPC clients, errors not in the error catalog are logged and replaced
+func publicError(logger *zap.SugaredLogger, method string, err error) error {
+	public, internal := apperr.Public(err)
+	if internal {
+		logger.Errorw("GRPC Error", "path", method, "error", err)
+	}
+	return public
+}
+
+func errorGRPCUnary() grpc.UnaryServerInterceptor {
+	logger := zap.S().With("package", "server.grpc")
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if err != nil {
+			return nil, publicError(logger, info.FullMethod, err)
+		}
+		return resp, nil
+	}
+}
+
+func errorGRPCStream() grpc.StreamServerInterceptor {
+	logger := zap.S().With("package", "server.grpc")
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return publicError(logger, info.FullMethod, handler(srv, ss))
+	}
+}
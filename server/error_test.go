@@ -0,0 +1,35 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/snowzach/gogrpcapi/apperr"
+)
+
+func TestErrorGRPCUnary(t *testing.T) {
+
+	interceptor := errorGRPCUnary()
+	info := &grpc.UnaryServerInfo{FullMethod: "/thingrpc.ThingRPC/ThingGet"}
+	call := func(err error) error {
+		_, err = interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, err
+		})
+		return err
+	}
+
+	assert.Nil(t, call(nil))
+	assert.Equal(t, apperr.ErrNotFound, call(apperr.ErrNotFound))
+
+	// Internal details are not returned
+	st := status.Convert(call(errors.New(`pq: duplicate key value violates unique constraint "thing_pkey"`)))
+	assert.Equal(t, codes.Internal, st.Code())
+	assert.Equal(t, "Internal Error", st.Message())
+
+}
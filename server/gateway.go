@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/render"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/runtime"
+	config "github.com/spf13/viper"
+	"google.golang.org/grpc/status"
+
+	"github.com/snowzach/gogrpcapi/apperr"
+)
+
+// gatewayMarshaler returns the marshaler used for gateway requests and responses
+// jsonpb renders well known types as plain JSON (ex: google.protobuf.Struct as an object)
+func gatewayMarshaler() gwruntime.Marshaler {
+	return &gwruntime.JSONPb{
+		EnumsAsInts:  config.GetBool("server.rest.enums_as_ints"),
+		EmitDefaults: config.GetBool("server.rest.emit_defaults"),
+		OrigName:     config.GetBool("server.rest.orig_names"),
+	}
+}
+
+// gatewayQueryAliases are query parameters accepted in place of request fields (ex: ?fields=name,labels for read_mask)
+var gatewayQueryAliases = map[string]string{
+	"fields": "read_mask",
+}
+
+// gatewayQueryAlias renames aliased query parameters to the request fields they set
+func gatewayQueryAlias(r *http.Request) *http.Request {
+
+	values := r.URL.Query()
+	changed := false
+	for alias, field := range gatewayQueryAliases {
+		if v, ok := values[alias]; ok {
+			if _, ok := values[field]; !ok {
+				values[field] = v
+			}
+			delete(values, alias)
+			changed = true
+		}
+	}
+	if !changed {
+		return r
+	}
+
+	u := *r.URL
+	u.RawQuery = values.Encode()
+	r2 := r.WithContext(r.Context())
+	r2.URL = &u
+	return r2
+
+}
+
+// gatewayForwardResponseEtag sets the ETag header for any response message that has an etag
+func gatewayForwardResponseEtag(ctx context.Context, w http.ResponseWriter, m proto.Message) error {
+	if e, ok := m.(interface{ GetEtag() string }); ok && e.GetEtag() != "" {
+		w.Header().Set("ETag", strconv.Quote(e.GetEtag()))
+	}
+	return nil
+}
+
+// gatewayErrorHandler renders errors as an ErrResponse
+// The HTTP status, code and status text come from the catalog error of the status (see apperr), the error text is the
+// status message and the other status details are rendered with the gateway marshaler.
+func gatewayErrorHandler(ctx context.Context, mux *gwruntime.ServeMux, marshaler gwruntime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+
+	st := status.Convert(err)
+	e := apperr.FromStatus(st)
+	response := &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: e.HTTPStatus,
+		StatusText:     e.Message,
+		AppCode:        e.AppCode,
+		ErrorText:      st.Message(),
+	}
+	for _, detail := range st.Details() {
+		m, ok := detail.(proto.Message)
+		if !ok || apperr.IsErrorInfo(m) {
+			continue
+		}
+		// Details are wrapped in an Any so they are rendered with their @type
+		a, aerr := ptypes.MarshalAny(m)
+		if aerr != nil {
+			continue
+		}
+		if b, merr := marshaler.Marshal(a); merr == nil {
+			response.Details = append(response.Details, b)
+		}
+	}
+
+	render.Render(w, r, response)
+
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/snowzach/gogrpcapi/apperr"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+func TestGatewayMarshalerAttributes(t *testing.T) {
+
+	m := gatewayMarshaler()
+
+	var b thingrpc.Thing
+	assert.Nil(t, m.Unmarshal([]byte(`{"id":"id1","attributes":{"color":"red","size":{"max":5},"tags":["a",true,null]}}`), &b))
+	assert.True(t, proto.Equal(&structpb.Struct{Fields: map[string]*structpb.Value{
+		"color": {Kind: &structpb.Value_StringValue{StringValue: "red"}},
+		"size": {Kind: &structpb.Value_StructValue{StructValue: &structpb.Struct{Fields: map[string]*structpb.Value{
+			"max": {Kind: &structpb.Value_NumberValue{NumberValue: 5}},
+		}}}},
+		"tags": {Kind: &structpb.Value_ListValue{ListValue: &structpb.ListValue{Values: []*structpb.Value{
+			{Kind: &structpb.Value_StringValue{StringValue: "a"}},
+			{Kind: &structpb.Value_BoolValue{BoolValue: true}},
+			{Kind: &structpb.Value_NullValue{}},
+		}}}},
+	}}, b.Attributes))
+
+	out, err := m.Marshal(&thingrpc.Thing{Id: "id1", Attributes: b.Attributes})
+	assert.Nil(t, err)
+	assert.Contains(t, string(out), `"attributes":{"color":"red","size":{"max":5},"tags":["a",true,null]}`)
+
+}
+
+func TestGatewayErrorHandler(t *testing.T) {
+
+	for _, test := range []struct {
+		err      error
+		code     int
+		response ErrResponse
+	}{
+		{apperr.ErrEtagMismatch, http.StatusPreconditionFailed, ErrResponse{StatusText: "Etag Mismatch", AppCode: 1004, ErrorText: "Etag Mismatch"}},
+		{status.Convert(apperr.ErrIdempotencyKeyReused).Err(), http.StatusUnprocessableEntity, ErrResponse{StatusText: "Idempotency Key Reused", AppCode: 1005, ErrorText: "Idempotency Key Reused"}},
+		{apperr.ErrNotFound.Errorf("Not Found: id1"), http.StatusNotFound, ErrResponse{StatusText: "Not Found", AppCode: 1002, ErrorText: "Not Found: id1"}},
+		// Errors from the gateway itself have no AppCode
+		{status.Error(codes.Unimplemented, "Not Implemented"), http.StatusNotImplemented, ErrResponse{StatusText: "Not Implemented", AppCode: 1012, ErrorText: "Not Implemented"}},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/things/id1", nil)
+		gatewayErrorHandler(context.Background(), gwruntime.NewServeMux(), gatewayMarshaler(), w, r, test.err)
+
+		assert.Equal(t, test.code, w.Code, test.err.Error())
+		assert.Contains(t, w.Header().Get("Content-Type"), "application/json")
+		var response ErrResponse
+		assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, test.response, response, test.err.Error())
+	}
+
+}
+
+func TestGatewayQueryAlias(t *testing.T) {
+
+	r := httptest.NewRequest(http.MethodGet, "/things?fields=name,labels&page_size=5", nil)
+	assert.Equal(t, "name,labels", gatewayQueryAlias(r).URL.Query().Get("read_mask"))
+	assert.Equal(t, "5", gatewayQueryAlias(r).URL.Query().Get("page_size"))
+	assert.Equal(t, "name,labels", r.URL.Query().Get("fields"), "the original request is not changed")
+
+	// The field itself wins
+	r = httptest.NewRequest(http.MethodGet, "/things?fields=name&read_mask=labels", nil)
+	assert.Equal(t, []string{"labels"}, gatewayQueryAlias(r).URL.Query()["read_mask"])
+
+	r = httptest.NewRequest(http.MethodGet, "/things?page_size=5", nil)
+	assert.Equal(t, r, gatewayQueryAlias(r))
+
+}
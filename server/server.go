@@ -18,6 +18,7 @@ import (
 	"github.com/go-chi/cors"
 	"github.com/go-chi/render"
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
 	gwruntime "github.com/grpc-ecosystem/grpc-gateway/runtime"
 	"github.com/snowzach/certtools"
 	"github.com/snowzach/certtools/autocert"
@@ -44,6 +45,8 @@ type gwRegFunc func(ctx context.Context, mux *gwruntime.ServeMux, endpoint strin
 // New will setup the server
 func New() (*Server, error) {
 
+	logger := zap.S().With("package", "server")
+
 	// This router is used for http requests only, setup all of our middleware
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
@@ -77,6 +80,31 @@ func New() (*Server, error) {
 		}
 	}
 
+	// Authenticate requests with bearer tokens and limit the store to the tenant of the request
+	var auth *authenticator
+	if config.GetBool("server.auth.enabled") {
+		var tokens []authToken
+		if err := config.UnmarshalKey("server.auth.tokens", &tokens); err != nil {
+			return nil, fmt.Errorf("Could not parse server.auth.tokens: %v", err)
+		}
+		var err error
+		if auth, err = newAuthenticator(tokens); err != nil {
+			return nil, fmt.Errorf("Invalid server.auth.tokens: %v", err)
+		}
+	} else {
+		logger.Warn("WARNING: Authentication is disabled, any client can use any tenant with the X-Tenant header. Enable server.auth.enabled in production!!!")
+	}
+	unaryInterceptors = append(unaryInterceptors, grpc_auth.UnaryServerInterceptor(authFunc(auth, config.GetBool("server.tenant.required"))))
+	streamInterceptors = append(streamInterceptors, grpc_auth.StreamServerInterceptor(authFunc(auth, config.GetBool("server.tenant.required"))))
+
+	// Reject requests that break the validation rules of their messages
+	unaryInterceptors = append(unaryInterceptors, validateGRPCUnary())
+	streamInterceptors = append(streamInterceptors, validateGRPCStream())
+
+	// Return only errors from the error catalog, anything else is logged and replaced by an internal error
+	unaryInterceptors = append(unaryInterceptors, errorGRPCUnary())
+	streamInterceptors = append(streamInterceptors, errorGRPCStream())
+
 	// GRPC Server Options
 	serverOptions := []grpc.ServerOption{
 		grpc_middleware.WithStreamServerChain(streamInterceptors...),
@@ -89,7 +117,7 @@ func New() (*Server, error) {
 	reflection.Register(g)
 
 	s := &Server{
-		logger:     zap.S().With("package", "server"),
+		logger:     logger,
 		router:     r,
 		grpcServer: g,
 		gwRegFuncs: make([]gwRegFunc, 0),
@@ -171,14 +199,29 @@ func (s *Server) ListenAndServe() error {
 
 	// Setup the GRPC gateway
 	grpcGatewayMux := gwruntime.NewServeMux(
-		gwruntime.WithMarshalerOption(gwruntime.MIMEWildcard, &JSONMarshaler{}), // Use encoding/json for all encoding/decoding
+		gwruntime.WithMarshalerOption(gwruntime.MIMEWildcard, gatewayMarshaler()),
 		gwruntime.WithMetadata(func(ctx context.Context, r *http.Request) metadata.MD { // Used to identify requests from the grpc gateway
-			return metadata.New(map[string]string{grpcGatewayIdentifier: grpcGatewayIdentifier})
+			md := metadata.New(map[string]string{grpcGatewayIdentifier: grpcGatewayIdentifier})
+			if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+				md.Set("if-match", ifMatch) // Used for optimistic concurrency
+			}
+			if tenant := r.Header.Get("X-Tenant"); tenant != "" {
+				md.Set(tenantMetadataKey, tenant)
+			}
+			if actor := r.Header.Get("X-User"); actor != "" {
+				md.Set(actorMetadataKey, actor) // Only used without authentication
+			}
+			if key := r.Header.Get("Idempotency-Key"); key != "" {
+				md.Set("idempotency-key", key) // Used to replay retried changes
+			}
+			return md
 		}),
+		gwruntime.WithForwardResponseOption(gatewayForwardResponseEtag),
+		gwruntime.WithProtoErrorHandler(gatewayErrorHandler),
 	)
 	// If the main router did not find and endpoint, pass it to the grpcGateway
 	s.router.NotFound(func(w http.ResponseWriter, r *http.Request) {
-		grpcGatewayMux.ServeHTTP(w, r)
+		grpcGatewayMux.ServeHTTP(w, gatewayQueryAlias(r))
 	})
 
 	// Register all the GRPC gateway functions
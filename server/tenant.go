@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/snowzach/gogrpcapi/apperr"
+	"github.com/snowzach/gogrpcapi/store"
+)
+
+const tenantMetadataKey = "x-tenant" // Set from the X-Tenant header by the grpc gateway
+
+// tenantRegexp is the format of a tenant identifier
+var tenantRegexp = regexp.MustCompile(`^[A-Za-z0-9][-A-Za-z0-9_.]{0,62}$`)
+
+// tenantContext limits the store to the tenant of the request
+// Authenticated requests use the tenant of their credentials, the request metadata can only repeat it. Without
+// authentication the tenant comes from the request metadata, which any client can set, and requests without one use
+// the default tenant unless a tenant is required.
+func tenantContext(ctx context.Context, required bool) (context.Context, error) {
+
+	tenant := grpcMetadataGetFirst(ctx, tenantMetadataKey)
+	if p := requestPrincipal(ctx); p != nil {
+		if tenant != "" && tenant != p.Tenant {
+			return nil, apperr.ErrPermissionDenied.Errorf("%s does not match the credentials", tenantMetadataKey)
+		}
+		return store.WithTenant(ctx, p.Tenant), nil
+	}
+
+	if tenant == "" {
+		if required {
+			return nil, apperr.ErrUnauthenticated.Errorf("%s is required", tenantMetadataKey)
+		}
+		return ctx, nil
+	}
+	if !tenantRegexp.MatchString(tenant) {
+		return nil, apperr.ErrInvalidArgument.Errorf("invalid %s", tenantMetadataKey)
+	}
+	return store.WithTenant(ctx, tenant), nil
+
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/snowzach/gogrpcapi/store"
+)
+
+func TestTenantContext(t *testing.T) {
+
+	withTenant := func(tenant string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(tenantMetadataKey, tenant))
+	}
+
+	ctx, err := tenantContext(withTenant("tenant1"), true)
+	assert.Nil(t, err)
+	assert.Equal(t, "tenant1", store.Tenant(ctx))
+
+	// The default tenant
+	ctx, err = tenantContext(context.Background(), false)
+	assert.Nil(t, err)
+	assert.Equal(t, "", store.Tenant(ctx))
+
+	_, err = tenantContext(context.Background(), true)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	for _, bad := range []string{"*", "-tenant", "a/b", "tenant 1"} {
+		_, err = tenantContext(withTenant(bad), false)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), bad)
+	}
+
+	// Authenticated requests always use the tenant of their credentials
+	authenticated := func(ctx context.Context) context.Context {
+		return context.WithValue(ctx, principalContextKey{}, &principal{Subject: "user1", Tenant: "tenant1"})
+	}
+	ctx, err = tenantContext(authenticated(context.Background()), true)
+	assert.Nil(t, err)
+	assert.Equal(t, "tenant1", store.Tenant(ctx))
+
+	ctx, err = tenantContext(authenticated(withTenant("tenant1")), true)
+	assert.Nil(t, err)
+	assert.Equal(t, "tenant1", store.Tenant(ctx))
+
+	_, err = tenantContext(authenticated(withTenant("tenant2")), true)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+}
@@ -0,0 +1,214 @@
+package validate
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/descriptor"
+	"github.com/golang/protobuf/proto"
+	descriptorpb "github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+)
+
+// Message checks the message, and the messages in its fields, against the rules declared in their proto files
+// It returns a violation for each field that breaks a rule, nil if there are none. Fields are named by their
+// proto name and path (ex: thing.id, ids[2]).
+func Message(m proto.Message) []*errdetails.BadRequest_FieldViolation {
+
+	v := reflect.ValueOf(m)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return nil
+	}
+
+	var violations []*errdetails.BadRequest_FieldViolation
+	rulesFor(v.Type()).validate(v.Elem(), "", &violations)
+	return violations
+
+}
+
+// messageRules are the compiled rules of a message type
+type messageRules struct {
+	fields []*fieldRules
+}
+
+// fieldRules are the compiled rules of a field
+type fieldRules struct {
+	index   int    // Index of the field in the struct
+	name    string // Proto name of the field
+	rules   *FieldRules
+	pattern *regexp.Regexp
+	message *messageRules // Rules of a message field or the items of a repeated message field, nil if there are none
+}
+
+// cache holds the *messageRules for each message type
+var cache sync.Map
+
+// rulesFor returns the rules of a message type (a pointer to a generated struct)
+func rulesFor(t reflect.Type) *messageRules {
+
+	if r, ok := cache.Load(t); ok {
+		return r.(*messageRules)
+	}
+	r := compile(t, make(map[reflect.Type]*messageRules))
+	cache.Store(t, r)
+	return r
+
+}
+
+// compile reads the rules of the fields of a message type from its descriptor
+// Types being compiled are in seen so recursive messages (ex: google.protobuf.Struct) terminate.
+func compile(t reflect.Type, seen map[reflect.Type]*messageRules) *messageRules {
+
+	if r, ok := seen[t]; ok {
+		return r
+	}
+	r := new(messageRules)
+	seen[t] = r
+
+	dm, ok := reflect.Zero(t).Interface().(descriptor.Message)
+	if !ok {
+		return r
+	}
+	_, md := descriptor.ForMessage(dm)
+	fieldsByNumber := make(map[int32]*descriptorpb.FieldDescriptorProto)
+	for _, f := range md.GetField() {
+		fieldsByNumber[f.GetNumber()] = f
+	}
+
+	props := proto.GetProperties(t.Elem())
+	for i, p := range props.Prop {
+		fd, ok := fieldsByNumber[int32(p.Tag)]
+		if !ok {
+			continue // Oneofs and internal fields
+		}
+
+		f := &fieldRules{index: i, name: fd.GetName()}
+		if fd.Options == nil {
+			// No rules
+		} else if ext, err := proto.GetExtension(fd.Options, E_Rules); err == nil {
+			f.rules = ext.(*FieldRules)
+			if f.rules.Pattern != "" {
+				f.pattern = regexp.MustCompile(f.rules.Pattern)
+			}
+		}
+
+		// Messages and repeated messages are checked with their own rules
+		ft := t.Elem().Field(i).Type
+		if ft.Kind() == reflect.Slice && ft.Elem().Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if ft.Kind() == reflect.Ptr && ft.Elem().Kind() == reflect.Struct {
+			if mr := compile(ft, seen); len(mr.fields) > 0 {
+				f.message = mr
+			}
+		}
+
+		if f.rules != nil || f.message != nil {
+			r.fields = append(r.fields, f)
+		}
+	}
+	return r
+
+}
+
+// validate checks the fields of a message struct, prefix is the path of the message
+func (r *messageRules) validate(v reflect.Value, prefix string, violations *[]*errdetails.BadRequest_FieldViolation) {
+
+	for _, f := range r.fields {
+		f.validate(v.Field(f.index), prefix+f.name, violations)
+	}
+
+}
+
+// validate checks the value of a field
+func (f *fieldRules) validate(v reflect.Value, path string, violations *[]*errdetails.BadRequest_FieldViolation) {
+
+	rules := f.rules
+	if rules == nil {
+		rules = new(FieldRules)
+	}
+	violation := func(format string, args ...interface{}) {
+		addViolation(violations, path, format, args...)
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		f.validateString(v.String(), path, violations)
+
+	case reflect.Ptr:
+		if v.IsNil() {
+			if rules.Required {
+				violation("is required")
+			}
+		} else if f.message != nil {
+			f.message.validate(v.Elem(), path+".", violations)
+		}
+
+	case reflect.Slice, reflect.Map:
+		if v.Len() == 0 && rules.Required {
+			violation("is required")
+		} else if rules.MaxItems > 0 && v.Len() > int(rules.MaxItems) {
+			violation("must have at most %d items", rules.MaxItems)
+		}
+		if k := v.Type().Elem().Kind(); v.Kind() == reflect.Map || (k != reflect.String && k != reflect.Ptr) {
+			return // Rules do not apply to the items of maps, bytes and repeated numbers
+		}
+		for i := 0; i < v.Len(); i++ {
+			item := v.Index(i)
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+			switch item.Kind() {
+			case reflect.String:
+				f.validateString(item.String(), itemPath, violations)
+			case reflect.Ptr:
+				if !item.IsNil() && f.message != nil {
+					f.message.validate(item.Elem(), itemPath+".", violations)
+				}
+			}
+		}
+
+	default:
+		if rules.Required && reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface()) {
+			violation("is required")
+		}
+	}
+
+}
+
+// validateString checks a string or an item of a repeated string field
+func (f *fieldRules) validateString(s string, path string, violations *[]*errdetails.BadRequest_FieldViolation) {
+
+	rules := f.rules
+	if rules == nil {
+		return
+	}
+	violation := func(format string, args ...interface{}) {
+		addViolation(violations, path, format, args...)
+	}
+
+	if s == "" {
+		if rules.Required {
+			violation("is required")
+		}
+		return
+	}
+	if length := utf8.RuneCountInString(s); rules.MinLen > 0 && length < int(rules.MinLen) {
+		violation("must be at least %d characters", rules.MinLen)
+	} else if rules.MaxLen > 0 && length > int(rules.MaxLen) {
+		violation("must be at most %d characters", rules.MaxLen)
+	}
+	if f.pattern != nil && !f.pattern.MatchString(s) {
+		violation("must match %s", rules.Pattern)
+	}
+
+}
+
+// addViolation adds a violation of the field at path
+func addViolation(violations *[]*errdetails.BadRequest_FieldViolation, path string, format string, args ...interface{}) {
+	*violations = append(*violations, &errdetails.BadRequest_FieldViolation{
+		Field:       path,
+		Description: fmt.Sprintf(format, args...),
+	})
+}
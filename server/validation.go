@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+
+	"github.com/snowzach/gogrpcapi/apperr"
+	"github.com/snowzach/gogrpcapi/server/validate"
+)
+
+// validateRequest checks a request against the (validate.rules) declared in its proto messages
+// It returns apperr.ErrInvalidArgument with a google.rpc.BadRequest detail listing every field violation.
+func validateRequest(req interface{}) error {
+
+	m, ok := req.(proto.Message)
+	if !ok {
+		return nil
+	}
+	violations := validate.Message(m)
+	if len(violations) == 0 {
+		return nil
+	}
+
+	return apperr.ErrInvalidArgument.Errorf("Invalid %s: %s", violations[0].Field, violations[0].Description).
+		WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+
+}
+
+func validateGRPCUnary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := validateRequest(req); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func validateGRPCStream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &validatingServerStream{WrappedServerStream: grpc_middleware.WrapServerStream(ss)})
+	}
+}
+
+// validatingServerStream validates each message received from the client
+type validatingServerStream struct {
+	*grpc_middleware.WrappedServerStream
+}
+
+func (s *validatingServerStream) RecvMsg(m interface{}) error {
+	if err := s.WrappedServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return validateRequest(m)
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	gwruntime "github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+// fieldViolations returns the field and description of each violation in the error details
+func fieldViolations(t *testing.T, err error) map[string]string {
+
+	st := status.Convert(err)
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+	violations := make(map[string]string)
+	for _, detail := range st.Details() {
+		if br, ok := detail.(*errdetails.BadRequest); ok {
+			for _, v := range br.FieldViolations {
+				violations[v.Field] = v.Description
+			}
+		}
+	}
+	return violations
+
+}
+
+func TestValidateRequest(t *testing.T) {
+
+	assert.Nil(t, validateRequest(&thingrpc.ThingGetRequest{Id: "id1"}))
+	assert.Nil(t, validateRequest(&thingrpc.Thing{Name: "name1"}))
+	assert.Nil(t, validateRequest("not a message"))
+
+	assert.Equal(t, map[string]string{"id": "is required"}, fieldViolations(t, validateRequest(&thingrpc.ThingGetRequest{})))
+
+	// Nested and repeated fields
+	assert.Equal(t, map[string]string{
+		"thing.id":   "must match ^[A-Za-z0-9._~-]+$",
+		"thing.name": "must be at most 256 characters",
+	}, fieldViolations(t, validateRequest(&thingrpc.ThingUpdateRequest{Thing: &thingrpc.Thing{Id: "bad id", Name: strings.Repeat("n", 257)}})))
+	assert.Equal(t, map[string]string{"thing": "is required"}, fieldViolations(t, validateRequest(&thingrpc.ThingUpdateRequest{})))
+
+	assert.Equal(t, map[string]string{
+		"ids[0]": "is required",
+		"ids[2]": "must be at most 128 characters",
+	}, fieldViolations(t, validateRequest(&thingrpc.BatchGetThingsRequest{Ids: []string{"", "id1", strings.Repeat("i", 129)}})))
+	assert.Equal(t, map[string]string{"things[1].etag": "must be at most 64 characters"}, fieldViolations(t, validateRequest(&thingrpc.BatchSaveThingsRequest{
+		Things: []*thingrpc.Thing{{Name: "name1"}, {Etag: strings.Repeat("e", 65)}},
+	})))
+	assert.Equal(t, "must have at most 1000 items", fieldViolations(t, validateRequest(&thingrpc.BatchDeleteThingsRequest{Ids: make([]string, 1001)}))["ids"])
+
+}
+
+func TestValidateGateway(t *testing.T) {
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/things/", nil)
+	gatewayErrorHandler(context.Background(), gwruntime.NewServeMux(), gatewayMarshaler(), w, r, validateRequest(&thingrpc.ThingGetRequest{}))
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":1001`)
+	assert.Contains(t, w.Body.String(), `"field_violations":[{"field":"id","description":"is required"}]`)
+
+}
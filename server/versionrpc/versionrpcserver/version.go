@@ -16,6 +16,11 @@ func New() versionrpc.VersionRPCServer {
 	return versionRPCServer{}
 }
 
+// AuthFuncOverride disables authentication, the version is public and does not use the store
+func (vs versionRPCServer) AuthFuncOverride(ctx context.Context, fullMethodName string) (context.Context, error) {
+	return ctx, nil
+}
+
 // Version returns the version
 func (vs versionRPCServer) Version(ctx context.Context, _ *emptypb.Empty) (*versionrpc.VersionResponse, error) {
 
@@ -0,0 +1,87 @@
+package bolt
+
+import (
+	"context"
+	"errors"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/snowzach/gogrpcapi/store"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+// errBatchFailed is used to roll back a batch when an item fails and partial success is not allowed
+var errBatchFailed = errors.New("batch failed")
+
+// ThingBatchGet returns the things with the given ids that exist, in no particular order
+func (c *Client) ThingBatchGet(ctx context.Context, ids []string, showDeleted bool) ([]*thingrpc.Thing, error) {
+
+	bs := make([]*thingrpc.Thing, 0, len(ids))
+	err := c.db.View(func(tx *bbolt.Tx) error {
+		seen := make(map[string]struct{}, len(ids))
+		for _, id := range ids {
+			if _, ok := seen[id]; ok {
+				continue
+			}
+			seen[id] = struct{}{}
+			t, err := thingGet(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if t != nil && (t.DeleteTime == nil || showDeleted) {
+				bs = append(bs, t)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bs, nil
+
+}
+
+// ThingBatchSave saves all of the things in one transaction
+func (c *Client) ThingBatchSave(ctx context.Context, things []*thingrpc.Thing, allowPartial bool) ([]error, error) {
+
+	return c.batch(len(things), allowPartial, func(tx *bbolt.Tx, i int) error {
+		return c.thingSave(ctx, tx, things[i])
+	})
+
+}
+
+// ThingBatchDelete marks all of the things as deleted in one transaction
+func (c *Client) ThingBatchDelete(ctx context.Context, ids []string, allowPartial bool) ([]error, error) {
+
+	return c.batch(len(ids), allowPartial, func(tx *bbolt.Tx, i int) error {
+		return c.thingDelete(ctx, tx, ids[i], "")
+	})
+
+}
+
+// batch runs f for count items in one transaction returning the error for each item.
+// f returns store errors before changing anything so a failed item does not need to be rolled back.
+// If any item fails and allowPartial is false, the entire transaction is rolled back.
+func (c *Client) batch(count int, allowPartial bool, f func(tx *bbolt.Tx, i int) error) ([]error, error) {
+
+	errs := make([]error, count)
+	err := c.update(func(tx *bbolt.Tx) error {
+		for i := 0; i < count; i++ {
+			switch errs[i] = f(tx, i); errs[i] {
+			case nil:
+			case store.ErrNotFound, store.ErrEtagMismatch:
+				if !allowPartial {
+					return errBatchFailed
+				}
+			default:
+				return errs[i]
+			}
+		}
+		return nil
+	})
+	if err == errBatchFailed {
+		return errs, nil
+	}
+	return errs, err
+
+}
@@ -0,0 +1,196 @@
+package bolt
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+	config "github.com/spf13/viper"
+	"go.etcd.io/bbolt"
+	"go.uber.org/zap"
+
+	"github.com/snowzach/gogrpcapi/conf"
+	"github.com/snowzach/gogrpcapi/store"
+)
+
+// Buckets of the default tenant, other tenants use the same names followed by / and the tenant
+var (
+	thingBucket         = []byte("thing")          // id => Thing
+	thingRevisionBucket = []byte("thing_revision") // id + 0 + version => ThingRevision
+	thingEventBucket    = []byte("thing_event")    // seq => thing revision key, the order of all changes
+)
+
+// tenantBucket returns the name of the bucket holding the data of the tenant of the context
+func tenantBucket(ctx context.Context, name []byte) []byte {
+	tenant := store.Tenant(ctx)
+	if tenant == "" {
+		return name
+	}
+	return []byte(string(name) + "/" + tenant)
+}
+
+// openTimeout is how long to wait for the lock on the database file
+const openTimeout = 5 * time.Second
+
+// Client is the database client
+type Client struct {
+	logger *zap.SugaredLogger
+	db     *bbolt.DB
+	newID  func() string
+
+	// ThingWatch calls waiting for changes
+	watchers     map[chan struct{}]struct{}
+	watchersLock sync.Mutex
+}
+
+// New returns a new database client using the database file at storage.path
+func New() (*Client, error) {
+
+	path := config.GetString("storage.path")
+	if path == "" {
+		return nil, fmt.Errorf("No path specified")
+	}
+
+	c, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+	c.logger.Debugw("Opened database", "storage.path", path)
+
+	// Close the database when the program stops
+	conf.Stop.Add(1)
+	go func() {
+		defer conf.Stop.Done()
+		<-conf.Stop.Chan()
+		if err := c.db.Close(); err != nil {
+			c.logger.Errorw("Could not close database", "error", err)
+		}
+	}()
+
+	// Permanently remove deleted things after the retention period
+	if purgeAfter := config.GetDuration("storage.purge_after"); purgeAfter > 0 {
+		conf.Stop.Add(1)
+		go c.purger(purgeAfter, config.GetDuration("storage.purge_interval"))
+	}
+
+	return c, nil
+
+}
+
+// open opens the database file and creates the buckets
+func open(path string) (*Client, error) {
+
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: openTimeout})
+	if err == bbolt.ErrTimeout {
+		return nil, fmt.Errorf("Could not open database: %s is in use by another process", path)
+	} else if err != nil {
+		return nil, fmt.Errorf("Could not open database: %s", err)
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range [][]byte{thingBucket, thingRevisionBucket, thingEventBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Could not create buckets: %s", err)
+	}
+
+	return &Client{
+		logger: zap.S().With("package", "storage.bolt"),
+		db:     db,
+		newID: func() string {
+			return xid.New().String()
+		},
+		watchers: make(map[chan struct{}]struct{}),
+	}, nil
+
+}
+
+// Backup writes a consistent copy of the database file at path to dst
+// The database must not be in use by a running server.
+func Backup(path string, dst string) error {
+
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: openTimeout, ReadOnly: true})
+	if err == bbolt.ErrTimeout {
+		return fmt.Errorf("%s is in use by another process", path)
+	} else if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bbolt.Tx) error {
+		return tx.CopyFile(dst, 0600)
+	})
+
+}
+
+// Compact writes a copy of the database file at path to dst without the free space left by deleted things
+// The database must not be in use by a running server.
+func Compact(path string, dst string) error {
+
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("%s already exists", dst)
+	}
+
+	src, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: openTimeout, ReadOnly: true})
+	if err == bbolt.ErrTimeout {
+		return fmt.Errorf("%s is in use by another process", path)
+	} else if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	db, err := bbolt.Open(dst, 0600, &bbolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Copy each bucket with full pages since nothing will be inserted in between
+	return src.View(func(srcTx *bbolt.Tx) error {
+		return srcTx.ForEach(func(name []byte, srcBucket *bbolt.Bucket) error {
+			return db.Update(func(tx *bbolt.Tx) error {
+				bucket, err := tx.CreateBucketIfNotExists(name)
+				if err != nil {
+					return err
+				}
+				bucket.FillPercent = 1.0
+				if err = srcBucket.ForEach(bucket.Put); err != nil {
+					return err
+				}
+				return bucket.SetSequence(srcBucket.Sequence())
+			})
+		})
+	})
+
+}
+
+// purger periodically purges things that were deleted longer than purgeAfter ago until the program stops
+func (c *Client) purger(purgeAfter time.Duration, interval time.Duration) {
+
+	store.Purger(interval, func() {
+		purged, err := c.ThingPurge(time.Now().Add(-purgeAfter))
+		if err != nil {
+			c.logger.Errorw("Could not purge deleted things", "error", err)
+		} else if purged > 0 {
+			c.logger.Infow("Purged deleted things", "count", purged)
+		}
+	})
+
+}
+
+// uint64Key returns a key that sorts in numeric order
+func uint64Key(n uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, n)
+	return key
+}
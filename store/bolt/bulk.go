@@ -0,0 +1,59 @@
+package bolt
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/ptypes"
+	"go.etcd.io/bbolt"
+
+	"github.com/snowzach/gogrpcapi/store/query"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+// ThingImport saves the things in one transaction replacing any existing things with the same ids
+// Deleted things are restored with the imported values and recorded as undeleted.
+func (c *Client) ThingImport(ctx context.Context, things []*thingrpc.Thing) error {
+
+	return c.update(func(tx *bbolt.Tx) error {
+		for _, t := range things {
+			t.Etag = "" // Replace regardless of the etag
+			existing, err := thingGet(ctx, tx, t.Id)
+			if err != nil {
+				return err
+			}
+			if existing != nil && existing.DeleteTime != nil {
+				existing.Name = t.Name
+				existing.Labels = t.Labels
+				existing.Attributes = t.Attributes
+				existing.Etag = nextEtag(existing.Etag)
+				existing.UpdateTime = ptypes.TimestampNow()
+				existing.DeleteTime = nil
+				err = c.thingPut(ctx, tx, existing, thingActionUndelete)
+			} else {
+				err = c.thingSave(ctx, tx, t)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+}
+
+// ThingExport calls send with every thing matching the query, in order, until it returns an error
+// The things are found before sending so the read transaction is not held open while sending.
+func (c *Client) ThingExport(ctx context.Context, q *query.Query, send func(*thingrpc.Thing) error) error {
+
+	bs, err := c.ThingFind(ctx, q)
+	if err != nil {
+		return err
+	}
+	for _, t := range bs {
+		if err = send(t); err != nil {
+			return err
+		}
+	}
+	return nil
+
+}
@@ -0,0 +1,164 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"go.etcd.io/bbolt"
+
+	"github.com/snowzach/gogrpcapi/store"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+// Actions recorded in the thing revision history
+const (
+	thingActionCreate   = "create"
+	thingActionUpdate   = "update"
+	thingActionDelete   = "delete"
+	thingActionUndelete = "undelete"
+)
+
+// thingRevisionPrefix returns the prefix of the revision keys of a thing
+func thingRevisionPrefix(id string) []byte {
+	return append([]byte(id), 0)
+}
+
+// thingRevisionKey returns the key of a revision, revisions of a thing sort by version
+func thingRevisionKey(id string, version uint64) []byte {
+	return append(thingRevisionPrefix(id), uint64Key(version)...)
+}
+
+// thingPut stores a thing and records it in the revision history and change feed as part of a transaction
+func (c *Client) thingPut(ctx context.Context, tx *bbolt.Tx, t *thingrpc.Thing, action string) error {
+
+	v, err := proto.Marshal(t)
+	if err != nil {
+		return err
+	}
+	things, err := tx.CreateBucketIfNotExists(tenantBucket(ctx, thingBucket))
+	if err != nil {
+		return err
+	}
+	if err = things.Put([]byte(t.Id), v); err != nil {
+		return err
+	}
+
+	version, _ := strconv.ParseUint(t.Etag, 10, 64)
+	key := thingRevisionKey(t.Id, version)
+	if v, err = proto.Marshal(&thingrpc.ThingRevision{
+		Revision:     t.Etag,
+		Action:       action,
+		Actor:        store.Actor(ctx),
+		RevisionTime: ptypes.TimestampNow(),
+		Thing:        t,
+	}); err != nil {
+		return err
+	}
+	revisions, err := tx.CreateBucketIfNotExists(tenantBucket(ctx, thingRevisionBucket))
+	if err != nil {
+		return err
+	}
+	if err = revisions.Put(key, v); err != nil {
+		return err
+	}
+
+	events, err := tx.CreateBucketIfNotExists(tenantBucket(ctx, thingEventBucket))
+	if err != nil {
+		return err
+	}
+	seq, err := events.NextSequence()
+	if err != nil {
+		return err
+	}
+	return events.Put(uint64Key(seq), key)
+
+}
+
+// thingRevisionDecode decodes a stored thing revision
+func thingRevisionDecode(v []byte) (*thingrpc.ThingRevision, error) {
+
+	r := new(thingrpc.ThingRevision)
+	if err := proto.Unmarshal(v, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+
+}
+
+// ThingGetAsOf returns the thing as it was at a point in time from the revision history
+// Things that were deleted at that time are only returned if showDeleted is true
+func (c *Client) ThingGetAsOf(ctx context.Context, id string, asOf time.Time, showDeleted bool) (*thingrpc.Thing, error) {
+
+	var found *thingrpc.ThingRevision
+	err := c.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(tenantBucket(ctx, thingRevisionBucket))
+		if b == nil {
+			return nil
+		}
+		prefix := thingRevisionPrefix(id)
+		cursor := b.Cursor()
+		for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+			r, err := thingRevisionDecode(v)
+			if err != nil {
+				return err
+			}
+			if revisionTime, _ := ptypes.Timestamp(r.RevisionTime); revisionTime.After(asOf) {
+				break
+			}
+			found = r
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if found == nil || (found.Thing.DeleteTime != nil && !showDeleted) {
+		return nil, store.ErrNotFound
+	}
+	return found.Thing, nil
+
+}
+
+// ThingListRevisions returns up to limit revisions of a thing, newest first, older than the before revision (0 = latest)
+func (c *Client) ThingListRevisions(ctx context.Context, id string, before int64, limit int) ([]*thingrpc.ThingRevision, error) {
+
+	revisions := make([]*thingrpc.ThingRevision, 0)
+	err := c.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(tenantBucket(ctx, thingRevisionBucket))
+		if b == nil {
+			return nil
+		}
+		prefix := thingRevisionPrefix(id)
+		start := uint64(before)
+		if before <= 0 {
+			start = ^uint64(0)
+		}
+
+		// Start at the last key before the start revision
+		cursor := b.Cursor()
+		k, v := cursor.Seek(thingRevisionKey(id, start))
+		if k == nil {
+			k, v = cursor.Last()
+		} else {
+			k, v = cursor.Prev()
+		}
+
+		for ; k != nil && bytes.HasPrefix(k, prefix) && len(revisions) < limit; k, v = cursor.Prev() {
+			r, err := thingRevisionDecode(v)
+			if err != nil {
+				return err
+			}
+			revisions = append(revisions, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return revisions, nil
+
+}
@@ -0,0 +1,447 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"go.etcd.io/bbolt"
+
+	"github.com/snowzach/gogrpcapi/store"
+	"github.com/snowzach/gogrpcapi/store/query"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+// ThingGetById returns the the thing by ID
+// Deleted things are only returned if showDeleted is true
+func (c *Client) ThingGetById(ctx context.Context, id string, showDeleted bool) (*thingrpc.Thing, error) {
+
+	var t *thingrpc.Thing
+	err := c.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		t, err = thingGet(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if t == nil || (t.DeleteTime != nil && !showDeleted) {
+		return nil, store.ErrNotFound
+	}
+	return t, nil
+
+}
+
+// ThingSave saves the thing
+// If the thing has an etag it must match the stored thing or ErrEtagMismatch is returned
+func (c *Client) ThingSave(ctx context.Context, i *thingrpc.Thing) (string, error) {
+
+	err := c.update(func(tx *bbolt.Tx) error {
+		return c.thingSave(ctx, tx, i)
+	})
+	return i.Id, err
+
+}
+
+// ThingCreate saves a new thing
+// ErrAlreadyExists is returned if a thing with the id exists, including deleted things that have not been purged
+func (c *Client) ThingCreate(ctx context.Context, i *thingrpc.Thing) (string, error) {
+
+	// Generate an ID if needed
+	if i.Id == "" {
+		i.Id = c.newID()
+	}
+
+	err := c.update(func(tx *bbolt.Tx) error {
+		existing, err := thingGet(ctx, tx, i.Id)
+		if err != nil {
+			return err
+		} else if existing != nil {
+			return &store.FieldError{Err: store.ErrAlreadyExists, Field: "id"}
+		}
+		now := ptypes.TimestampNow()
+		return c.thingPut(ctx, tx, &thingrpc.Thing{
+			Id:         i.Id,
+			Name:       i.Name,
+			Labels:     i.Labels,
+			Attributes: i.Attributes,
+			Etag:       "1",
+			CreateTime: now,
+			UpdateTime: now,
+		}, thingActionCreate)
+	})
+	return i.Id, err
+
+}
+
+// thingSave saves the thing as part of a transaction
+// Store errors are returned before anything is changed so the transaction can continue.
+func (c *Client) thingSave(ctx context.Context, tx *bbolt.Tx, i *thingrpc.Thing) error {
+
+	// Generate an ID if needed
+	if i.Id == "" {
+		i.Id = c.newID()
+	}
+
+	existing, err := thingGet(ctx, tx, i.Id)
+	if err != nil {
+		return err
+	}
+
+	// Only update the existing thing if it is not deleted and the etag, if any, matches
+	if existing != nil || i.Etag != "" {
+		if err = thingMissingError(existing, i.Etag); err != nil {
+			return err
+		}
+	}
+
+	now := ptypes.TimestampNow()
+	if existing == nil {
+		return c.thingPut(ctx, tx, &thingrpc.Thing{
+			Id:         i.Id,
+			Name:       i.Name,
+			Labels:     i.Labels,
+			Attributes: i.Attributes,
+			Etag:       "1",
+			CreateTime: now,
+			UpdateTime: now,
+		}, thingActionCreate)
+	}
+
+	existing.Name = i.Name
+	existing.Labels = i.Labels
+	existing.Attributes = i.Attributes
+	existing.Etag = nextEtag(existing.Etag)
+	existing.UpdateTime = now
+	return c.thingPut(ctx, tx, existing, thingActionUpdate)
+
+}
+
+// ThingUpdate updates only the given fields of the thing and returns the result
+// If the thing has an etag it must match the stored thing or ErrEtagMismatch is returned
+func (c *Client) ThingUpdate(ctx context.Context, i *thingrpc.Thing, paths []string) (*thingrpc.Thing, error) {
+
+	var t *thingrpc.Thing
+	err := c.update(func(tx *bbolt.Tx) error {
+		var err error
+		if t, err = thingGet(ctx, tx, i.Id); err != nil {
+			return err
+		}
+		if err = thingMissingError(t, i.Etag); err != nil {
+			return err
+		}
+		for _, path := range paths {
+			switch path {
+			case "name":
+				t.Name = i.Name
+			case "labels":
+				t.Labels = i.Labels
+			case "attributes":
+				t.Attributes = i.Attributes
+			default:
+				return fmt.Errorf("unknown update path: %s", path)
+			}
+		}
+		t.Etag = nextEtag(t.Etag)
+		t.UpdateTime = ptypes.TimestampNow()
+		return c.thingPut(ctx, tx, t, thingActionUpdate)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+
+}
+
+// ThingDeleteById marks a thing as deleted, it is removed by ThingPurge after the retention period
+// ErrNotFound is returned if the thing does not exist or is already deleted.
+// If etag is not empty it must match the stored thing or ErrEtagMismatch is returned
+func (c *Client) ThingDeleteById(ctx context.Context, id string, etag string) error {
+
+	return c.update(func(tx *bbolt.Tx) error {
+		return c.thingDelete(ctx, tx, id, etag)
+	})
+
+}
+
+// thingDelete marks a thing as deleted as part of a transaction
+// Store errors are returned before anything is changed so the transaction can continue.
+func (c *Client) thingDelete(ctx context.Context, tx *bbolt.Tx, id string, etag string) error {
+
+	t, err := thingGet(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if err = thingMissingError(t, etag); err != nil {
+		return err
+	}
+	t.Etag = nextEtag(t.Etag)
+	t.DeleteTime = ptypes.TimestampNow()
+	return c.thingPut(ctx, tx, t, thingActionDelete)
+
+}
+
+// ThingUndeleteById restores a deleted thing, things that are not deleted are returned unchanged
+// If etag is not empty it must match the stored thing or ErrEtagMismatch is returned
+func (c *Client) ThingUndeleteById(ctx context.Context, id string, etag string) (*thingrpc.Thing, error) {
+
+	var t *thingrpc.Thing
+	err := c.update(func(tx *bbolt.Tx) error {
+		var err error
+		if t, err = thingGet(ctx, tx, id); err != nil {
+			return err
+		}
+		if t == nil {
+			return store.ErrNotFound
+		} else if !store.EtagMatches(etag, t.Etag) {
+			return store.ErrEtagMismatch
+		} else if t.DeleteTime == nil {
+			return nil
+		}
+		t.Etag = nextEtag(t.Etag)
+		t.DeleteTime = nil
+		return c.thingPut(ctx, tx, t, thingActionUndelete)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+
+}
+
+// ThingPurge permanently removes things of every tenant deleted before the given time
+func (c *Client) ThingPurge(before time.Time) (int64, error) {
+
+	var purged int64
+	err := c.db.Update(func(tx *bbolt.Tx) error {
+		var buckets []*bbolt.Bucket
+		tenantPrefix := []byte(string(thingBucket) + "/")
+		tx.ForEach(func(name []byte, b *bbolt.Bucket) error {
+			if bytes.Equal(name, thingBucket) || bytes.HasPrefix(name, tenantPrefix) {
+				buckets = append(buckets, b)
+			}
+			return nil
+		})
+
+		for _, b := range buckets {
+			// Find them first, deleting while iterating with a cursor skips keys
+			var ids [][]byte
+			err := b.ForEach(func(k, v []byte) error {
+				t, err := thingDecode(v)
+				if err != nil {
+					return err
+				}
+				if t.DeleteTime != nil {
+					if deleteTime, err := ptypes.Timestamp(t.DeleteTime); err == nil && deleteTime.Before(before) {
+						ids = append(ids, k)
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, id := range ids {
+				if err = b.Delete(id); err != nil {
+					return err
+				}
+			}
+			purged += int64(len(ids))
+		}
+		return nil
+	})
+	return purged, err
+
+}
+
+// ThingFind gets things
+// Things are stored in id order so finds ordered only by id read just the requested page.
+func (c *Client) ThingFind(ctx context.Context, q *query.Query) ([]*thingrpc.Thing, error) {
+
+	bs := make([]*thingrpc.Thing, 0)
+	err := c.db.View(func(tx *bbolt.Tx) error {
+
+		// Returns true when there are enough results
+		add := func(v []byte) (bool, error) {
+			t, err := thingDecode(v)
+			if err != nil {
+				return false, err
+			}
+			if t.DeleteTime != nil && !q.ShowDeleted {
+				return false, nil
+			}
+			value := thingValue(t)
+			if query.Match(q.Filter, value) && q.Labels.Matches(t.Labels) && query.IsAfter(q.OrderBy, q.After, value) {
+				bs = append(bs, t)
+			}
+			return q.Limit > 0 && len(bs) >= q.Limit, nil
+		}
+
+		b := tx.Bucket(tenantBucket(ctx, thingBucket))
+		if b == nil {
+			return nil // The tenant has no things
+		}
+		cursor := b.Cursor()
+		if len(q.OrderBy) == 1 && q.OrderBy[0].Field == thingrpc.ThingSchema.Key {
+			var after []byte
+			if len(q.After) == 1 {
+				id, _ := q.After[0].(string)
+				after = []byte(id)
+			}
+			var k, v []byte
+			var next func() ([]byte, []byte)
+			if q.OrderBy[0].Desc {
+				next = cursor.Prev
+				if after != nil {
+					// Start at the last key before the cursor
+					if k, _ = cursor.Seek(after); k == nil {
+						k, v = cursor.Last()
+					} else {
+						k, v = cursor.Prev()
+					}
+				} else {
+					k, v = cursor.Last()
+				}
+			} else {
+				next = cursor.Next
+				if after != nil {
+					k, v = cursor.Seek(after)
+				} else {
+					k, v = cursor.First()
+				}
+			}
+			for ; k != nil; k, v = next() {
+				if done, err := add(v); err != nil || done {
+					return err
+				}
+			}
+			return nil
+		}
+
+		// Anything else needs everything sorted
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			if _, err := add(v); err != nil {
+				return err
+			}
+		}
+		sort.Slice(bs, func(i, j int) bool {
+			return query.Less(q.OrderBy, thingValue(bs[i]), thingValue(bs[j]))
+		})
+		if q.Limit > 0 && len(bs) > q.Limit {
+			bs = bs[:q.Limit]
+		}
+		return nil
+
+	})
+	if err != nil {
+		return nil, err
+	}
+	return bs, nil
+
+}
+
+// ThingAggregate counts things
+func (c *Client) ThingAggregate(ctx context.Context, q *query.Query, groupBy string) (*query.Aggregation, error) {
+
+	a := query.NewAggregator(groupBy)
+	err := c.db.View(func(tx *bbolt.Tx) error {
+
+		b := tx.Bucket(tenantBucket(ctx, thingBucket))
+		if b == nil {
+			return nil // The tenant has no things
+		}
+		return b.ForEach(func(k, v []byte) error {
+			t, err := thingDecode(v)
+			if err != nil {
+				return err
+			}
+			if t.DeleteTime != nil && !q.ShowDeleted {
+				return nil
+			}
+			value := thingValue(t)
+			if query.Match(q.Filter, value) && q.Labels.Matches(t.Labels) {
+				a.Add(value)
+			}
+			return nil
+		})
+
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a.Aggregation(q.Limit), nil
+
+}
+
+// ThingSearch is not supported, full-text search requires the postgres store
+func (c *Client) ThingSearch(ctx context.Context, q *query.Query, text string) ([]*thingrpc.ThingSearchResult, error) {
+	return nil, store.ErrUnimplemented.Errorf("Search requires the postgres store")
+}
+
+// update runs f in a read-write transaction and wakes up the watchers if it succeeds
+func (c *Client) update(f func(tx *bbolt.Tx) error) error {
+
+	if err := c.db.Update(f); err != nil {
+		return err
+	}
+	c.wakeWatchers()
+	return nil
+
+}
+
+// thingGet returns a thing of the tenant of the context from the database, nil if it does not exist
+func thingGet(ctx context.Context, tx *bbolt.Tx, id string) (*thingrpc.Thing, error) {
+
+	b := tx.Bucket(tenantBucket(ctx, thingBucket))
+	if b == nil {
+		return nil, nil
+	}
+	v := b.Get([]byte(id))
+	if v == nil {
+		return nil, nil
+	}
+	return thingDecode(v)
+
+}
+
+// thingDecode decodes a stored thing
+func thingDecode(v []byte) (*thingrpc.Thing, error) {
+
+	t := new(thingrpc.Thing)
+	if err := proto.Unmarshal(v, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+
+}
+
+// thingMissingError returns why a change to a thing can not be made, nil if it can
+func thingMissingError(t *thingrpc.Thing, etag string) error {
+
+	if t == nil || t.DeleteTime != nil {
+		return store.ErrNotFound
+	} else if !store.EtagMatches(etag, t.Etag) {
+		return store.ErrEtagMismatch
+	}
+	return nil
+
+}
+
+// thingValue returns the query values of a thing
+func thingValue(t *thingrpc.Thing) query.Value {
+	return func(field string) interface{} {
+		return thingrpc.ThingFieldValue(t, field)
+	}
+}
+
+// nextEtag returns the etag of the next version of a thing
+func nextEtag(etag string) string {
+	version, _ := strconv.ParseInt(etag, 10, 64)
+	return strconv.FormatInt(version+1, 10)
+}
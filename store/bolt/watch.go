@@ -0,0 +1,118 @@
+package bolt
+
+import (
+	"context"
+	"encoding/binary"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+// thingEventBatch is the maximum number of events read at once
+const thingEventBatch = 100
+
+// thingEvent converts a revision to a thing event
+func thingEvent(r *thingrpc.ThingRevision) *thingrpc.ThingEvent {
+	e := &thingrpc.ThingEvent{
+		Thing:     r.Thing,
+		Actor:     r.Actor,
+		EventTime: r.RevisionTime,
+	}
+	switch r.Action {
+	case thingActionCreate:
+		e.Type = thingrpc.ThingEvent_CREATED
+	case thingActionUpdate, thingActionUndelete:
+		e.Type = thingrpc.ThingEvent_UPDATED
+	case thingActionDelete:
+		e.Type = thingrpc.ThingEvent_DELETED
+	}
+	return e
+}
+
+// ThingWatch calls send with each change to things after the after sequence number (0 = now) in order
+// It returns when the context is canceled or send returns an error
+func (c *Client) ThingWatch(ctx context.Context, after int64, send func(int64, *thingrpc.ThingEvent) error) error {
+
+	wake := make(chan struct{}, 1)
+	c.watchersLock.Lock()
+	c.watchers[wake] = struct{}{}
+	c.watchersLock.Unlock()
+	defer func() {
+		c.watchersLock.Lock()
+		delete(c.watchers, wake)
+		c.watchersLock.Unlock()
+	}()
+
+	// Start with changes made from now on
+	if after <= 0 {
+		c.db.View(func(tx *bbolt.Tx) error {
+			if events := tx.Bucket(tenantBucket(ctx, thingEventBucket)); events != nil {
+				after = int64(events.Sequence())
+			}
+			return nil
+		})
+	}
+
+	type event struct {
+		seq   int64
+		event *thingrpc.ThingEvent
+	}
+
+	for {
+		// Read the next events so they can be sent outside of the transaction
+		var events []event
+		err := c.db.View(func(tx *bbolt.Tx) error {
+			revisions := tx.Bucket(tenantBucket(ctx, thingRevisionBucket))
+			feed := tx.Bucket(tenantBucket(ctx, thingEventBucket))
+			if revisions == nil || feed == nil {
+				return nil // The tenant has no changes yet
+			}
+			cursor := feed.Cursor()
+			for k, v := cursor.Seek(uint64Key(uint64(after + 1))); k != nil && len(events) < thingEventBatch; k, v = cursor.Next() {
+				r, err := thingRevisionDecode(revisions.Get(v))
+				if err != nil {
+					return err
+				}
+				events = append(events, event{seq: int64(binary.BigEndian.Uint64(k)), event: thingEvent(r)})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, e := range events {
+			if err = send(e.seq, e.event); err != nil {
+				return err
+			}
+			after = e.seq
+		}
+
+		// There may be more waiting
+		if len(events) == thingEventBatch {
+			continue
+		}
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+}
+
+// wakeWatchers tells the ThingWatch calls there are changes
+func (c *Client) wakeWatchers() {
+
+	c.watchersLock.Lock()
+	for wake := range c.watchers {
+		select {
+		case wake <- struct{}{}:
+		default: // Already awake
+		}
+	}
+	c.watchersLock.Unlock()
+
+}
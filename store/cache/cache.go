@@ -0,0 +1,242 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/snowzach/gogrpcapi/store"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+// Cache is a ThingStore that caches things fetched by id from another ThingStore
+// The least recently used things are evicted once it holds size things and things expire after ttl.
+// Changes made through the cache invalidate the cached things, changes made by other instances
+// of the api are seen once the things expire.
+type Cache struct {
+	thingrpc.ThingStore // Everything that is not cached goes directly to the store
+
+	size int
+	ttl  time.Duration
+
+	sync.Mutex
+	items      map[key]*list.Element // tenant and id => entry
+	lru        *list.List            // Most recently used at the front
+	generation uint64                // Incremented on invalidation to discard fetches that started before
+
+	hits   uint64
+	misses uint64
+}
+
+// key identifies a cached thing, ids are unique within a tenant
+type key struct {
+	tenant string
+	id     string
+}
+
+// entry is a cached thing
+type entry struct {
+	key     key
+	thing   *thingrpc.Thing
+	expires time.Time
+}
+
+// Stats are the cache counters
+type Stats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	Size   int    `json:"size"`
+}
+
+// New returns a new cache of up to size things for ttl in front of store
+func New(store thingrpc.ThingStore, size int, ttl time.Duration) *Cache {
+
+	return &Cache{
+		ThingStore: store,
+		size:       size,
+		ttl:        ttl,
+		items:      make(map[key]*list.Element),
+		lru:        list.New(),
+	}
+
+}
+
+// Stats returns the cache counters
+func (c *Cache) Stats() Stats {
+
+	c.Lock()
+	size := c.lru.Len()
+	c.Unlock()
+
+	return Stats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+		Size:   size,
+	}
+
+}
+
+// ThingGetById returns the thing from the cache or fetches it from the store
+// Deleted things are not cached, requests that include them go to the store. Things fetched with only some fields
+// (store.WithFields) are not cached either.
+func (c *Cache) ThingGetById(ctx context.Context, id string, showDeleted bool) (*thingrpc.Thing, error) {
+
+	if showDeleted {
+		return c.ThingStore.ThingGetById(ctx, id, showDeleted)
+	}
+
+	k := key{tenant: store.Tenant(ctx), id: id}
+	c.Lock()
+	if e, ok := c.items[k]; ok {
+		if item := e.Value.(*entry); time.Now().Before(item.expires) {
+			c.lru.MoveToFront(e)
+			c.Unlock()
+			atomic.AddUint64(&c.hits, 1)
+			return cloneThing(item.thing), nil
+		}
+		c.remove(k)
+	}
+	generation := c.generation
+	c.Unlock()
+	atomic.AddUint64(&c.misses, 1)
+
+	t, err := c.ThingStore.ThingGetById(ctx, id, showDeleted)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Lock()
+	if generation == c.generation && store.Fields(ctx) == nil {
+		c.add(k, cloneThing(t))
+	}
+	c.Unlock()
+
+	return t, nil
+
+}
+
+// ThingSave saves the thing and removes it from the cache
+func (c *Cache) ThingSave(ctx context.Context, t *thingrpc.Thing) (string, error) {
+
+	id, err := c.ThingStore.ThingSave(ctx, t)
+	c.invalidate(ctx, id)
+	return id, err
+
+}
+
+// ThingCreate creates the thing and removes it from the cache
+func (c *Cache) ThingCreate(ctx context.Context, t *thingrpc.Thing) (string, error) {
+
+	id, err := c.ThingStore.ThingCreate(ctx, t)
+	c.invalidate(ctx, id)
+	return id, err
+
+}
+
+// ThingUpdate updates the thing and removes it from the cache
+func (c *Cache) ThingUpdate(ctx context.Context, t *thingrpc.Thing, paths []string) (*thingrpc.Thing, error) {
+
+	b, err := c.ThingStore.ThingUpdate(ctx, t, paths)
+	c.invalidate(ctx, t.Id)
+	return b, err
+
+}
+
+// ThingDeleteById deletes the thing and removes it from the cache
+func (c *Cache) ThingDeleteById(ctx context.Context, id string, etag string) error {
+
+	err := c.ThingStore.ThingDeleteById(ctx, id, etag)
+	c.invalidate(ctx, id)
+	return err
+
+}
+
+// ThingUndeleteById undeletes the thing and removes it from the cache
+func (c *Cache) ThingUndeleteById(ctx context.Context, id string, etag string) (*thingrpc.Thing, error) {
+
+	b, err := c.ThingStore.ThingUndeleteById(ctx, id, etag)
+	c.invalidate(ctx, id)
+	return b, err
+
+}
+
+// ThingBatchSave saves the things and removes them from the cache
+func (c *Cache) ThingBatchSave(ctx context.Context, things []*thingrpc.Thing, allowPartial bool) ([]error, error) {
+
+	errs, err := c.ThingStore.ThingBatchSave(ctx, things, allowPartial)
+	ids := make([]string, len(things))
+	for i, t := range things {
+		ids[i] = t.Id
+	}
+	c.invalidate(ctx, ids...)
+	return errs, err
+
+}
+
+// ThingBatchDelete deletes the things and removes them from the cache
+func (c *Cache) ThingBatchDelete(ctx context.Context, ids []string, allowPartial bool) ([]error, error) {
+
+	errs, err := c.ThingStore.ThingBatchDelete(ctx, ids, allowPartial)
+	c.invalidate(ctx, ids...)
+	return errs, err
+
+}
+
+// ThingImport imports the things and removes them from the cache
+func (c *Cache) ThingImport(ctx context.Context, things []*thingrpc.Thing) error {
+
+	err := c.ThingStore.ThingImport(ctx, things)
+	ids := make([]string, len(things))
+	for i, t := range things {
+		ids[i] = t.Id
+	}
+	c.invalidate(ctx, ids...)
+	return err
+
+}
+
+// invalidate removes things of the tenant of the context from the cache
+func (c *Cache) invalidate(ctx context.Context, ids ...string) {
+
+	tenant := store.Tenant(ctx)
+	c.Lock()
+	c.generation++
+	for _, id := range ids {
+		c.remove(key{tenant: tenant, id: id})
+	}
+	c.Unlock()
+
+}
+
+// add adds a thing to the cache evicting the least recently used things if full, the caller must hold the lock
+func (c *Cache) add(k key, t *thingrpc.Thing) {
+
+	if c.size <= 0 {
+		return
+	}
+	c.remove(k)
+	c.items[k] = c.lru.PushFront(&entry{key: k, thing: t, expires: time.Now().Add(c.ttl)})
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back().Value.(*entry).key)
+	}
+
+}
+
+// remove removes a thing from the cache, the caller must hold the lock
+func (c *Cache) remove(k key) {
+
+	if e, ok := c.items[k]; ok {
+		c.lru.Remove(e)
+		delete(c.items, k)
+	}
+
+}
+
+// cloneThing returns a copy of a thing so cached things can not be changed
+func cloneThing(t *thingrpc.Thing) *thingrpc.Thing {
+	return proto.Clone(t).(*thingrpc.Thing)
+}
@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/snowzach/gogrpcapi/mocks"
+	"github.com/snowzach/gogrpcapi/store"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+func TestCacheThingGetById(t *testing.T) {
+
+	ts := new(mocks.ThingStore)
+	c := New(ts, 2, time.Minute)
+	ctx := context.Background()
+
+	i := &thingrpc.Thing{Id: "id1", Name: "name1"}
+	ts.On("ThingGetById", mock.AnythingOfType("*context.emptyCtx"), "id1", false).Once().Return(i, nil)
+
+	// Miss then hit
+	for n := 0; n < 2; n++ {
+		b, err := c.ThingGetById(ctx, "id1", false)
+		assert.Nil(t, err)
+		assert.Equal(t, i, b)
+	}
+	assert.Equal(t, Stats{Hits: 1, Misses: 1, Size: 1}, c.Stats())
+
+	// Errors are not cached
+	ts.On("ThingGetById", mock.AnythingOfType("*context.emptyCtx"), "missing", false).Twice().Return(nil, store.ErrNotFound)
+	for n := 0; n < 2; n++ {
+		_, err := c.ThingGetById(ctx, "missing", false)
+		assert.Equal(t, store.ErrNotFound, err)
+	}
+
+	// Saving invalidates
+	ts.On("ThingSave", mock.AnythingOfType("*context.emptyCtx"), i).Once().Return("id1", nil)
+	ts.On("ThingGetById", mock.AnythingOfType("*context.emptyCtx"), "id1", false).Once().Return(i, nil)
+	_, err := c.ThingSave(ctx, i)
+	assert.Nil(t, err)
+	_, err = c.ThingGetById(ctx, "id1", false)
+	assert.Nil(t, err)
+
+	// Things with only some fields are not cached
+	fieldsCtx := store.WithFields(ctx, []string{"name"})
+	ts.On("ThingGetById", fieldsCtx, "id2", false).Once().Return(&thingrpc.Thing{Name: "name2"}, nil)
+	_, err = c.ThingGetById(fieldsCtx, "id2", false)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, c.Stats().Size)
+
+	ts.AssertExpectations(t)
+
+}
+
+func TestCacheEviction(t *testing.T) {
+
+	ts := new(mocks.ThingStore)
+	c := New(ts, 2, time.Minute)
+	ctx := context.Background()
+
+	for _, id := range []string{"id1", "id2", "id3"} {
+		ts.On("ThingGetById", mock.AnythingOfType("*context.emptyCtx"), id, false).Return(&thingrpc.Thing{Id: id}, nil)
+	}
+
+	// id1 is the least recently used when id3 is added
+	for _, id := range []string{"id1", "id2", "id2", "id3", "id2", "id1"} {
+		_, err := c.ThingGetById(ctx, id, false)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, Stats{Hits: 2, Misses: 4, Size: 2}, c.Stats())
+
+	// Expired
+	c.ttl = 0
+	c.invalidate(ctx, "id1")
+	_, err := c.ThingGetById(ctx, "id1", false)
+	assert.Nil(t, err)
+	_, err = c.ThingGetById(ctx, "id1", false)
+	assert.Nil(t, err)
+	assert.Equal(t, Stats{Hits: 2, Misses: 6, Size: 2}, c.Stats())
+
+}
+
+func TestCacheTenants(t *testing.T) {
+
+	ts := new(mocks.ThingStore)
+	c := New(ts, 2, time.Minute)
+	ctx1 := store.WithTenant(context.Background(), "tenant1")
+	ctx2 := store.WithTenant(context.Background(), "tenant2")
+
+	// The same id in each tenant is fetched and cached separately
+	ts.On("ThingGetById", ctx1, "id1", false).Once().Return(&thingrpc.Thing{Id: "id1", Name: "name1"}, nil)
+	ts.On("ThingGetById", ctx2, "id1", false).Once().Return(&thingrpc.Thing{Id: "id1", Name: "name2"}, nil)
+	for n := 0; n < 2; n++ {
+		b, err := c.ThingGetById(ctx1, "id1", false)
+		assert.Nil(t, err)
+		assert.Equal(t, "name1", b.Name)
+		b, err = c.ThingGetById(ctx2, "id1", false)
+		assert.Nil(t, err)
+		assert.Equal(t, "name2", b.Name)
+	}
+	assert.Equal(t, Stats{Hits: 2, Misses: 2, Size: 2}, c.Stats())
+
+	// Invalidating one tenant keeps the other
+	c.invalidate(ctx1, "id1")
+	assert.Equal(t, Stats{Hits: 2, Misses: 2, Size: 1}, c.Stats())
+
+	ts.AssertExpectations(t)
+
+}
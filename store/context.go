@@ -0,0 +1,60 @@
+package store
+
+import (
+	"context"
+)
+
+type contextKey int
+
+const (
+	actorContextKey contextKey = iota
+	tenantContextKey
+	idempotencyKeyContextKey
+	fieldsContextKey
+)
+
+// WithActor returns a context that records who is making changes to the store
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorContextKey, actor)
+}
+
+// Actor returns who is making changes to the store, empty if unknown
+func Actor(ctx context.Context) string {
+	actor, _ := ctx.Value(actorContextKey).(string)
+	return actor
+}
+
+// WithTenant returns a context that limits the store to the records of a tenant
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantContextKey, tenant)
+}
+
+// Tenant returns the tenant the store is limited to, empty for the default tenant
+func Tenant(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantContextKey).(string)
+	return tenant
+}
+
+// WithIdempotencyKey returns a context that identifies retries of the same change
+// Stores that support it save the key with the result of the change and return that result for repeats.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyContextKey, key)
+}
+
+// IdempotencyKey returns the idempotency key of the change, empty if none
+func IdempotencyKey(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKeyContextKey).(string)
+	return key
+}
+
+// WithFields returns a context that tells ThingGetById which fields of the thing the caller needs
+// Stores may return more, query.Query.Fields does the same for ThingFind.
+func WithFields(ctx context.Context, fields []string) context.Context {
+	return context.WithValue(ctx, fieldsContextKey, fields)
+}
+
+// Fields returns the fields the caller needs, nil for all
+func Fields(ctx context.Context) []string {
+	fields, _ := ctx.Value(fieldsContextKey).([]string)
+	return fields
+}
@@ -0,0 +1,80 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+// ThingBatchGet returns the things with the given ids that exist, in no particular order
+func (c *Client) ThingBatchGet(ctx context.Context, ids []string, showDeleted bool) ([]*thingrpc.Thing, error) {
+
+	c.RLock()
+	defer c.RUnlock()
+
+	bs := make([]*thingrpc.Thing, 0, len(ids))
+	seen := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		if t, ok := c.things[key(ctx, id)]; ok && (t.DeleteTime == nil || showDeleted) {
+			bs = append(bs, cloneThing(t))
+		}
+	}
+	return bs, nil
+
+}
+
+// ThingBatchSave saves all of the things at once
+func (c *Client) ThingBatchSave(ctx context.Context, things []*thingrpc.Thing, allowPartial bool) ([]error, error) {
+
+	return c.batch(len(things), allowPartial, func(tx *tx, i int) error {
+		return c.thingSave(ctx, tx, things[i])
+	}), nil
+
+}
+
+// ThingBatchDelete marks all of the things as deleted at once
+func (c *Client) ThingBatchDelete(ctx context.Context, ids []string, allowPartial bool) ([]error, error) {
+
+	return c.batch(len(ids), allowPartial, func(tx *tx, i int) error {
+		return c.thingDelete(ctx, tx, ids[i], "")
+	}), nil
+
+}
+
+// batch runs f for count items while holding the lock returning the error for each item.
+// A failed item is rolled back while keeping the others.
+// If any item fails and allowPartial is false, all of the changes are rolled back.
+func (c *Client) batch(count int, allowPartial bool, f func(tx *tx, i int) error) []error {
+
+	c.Lock()
+
+	errs := make([]error, count)
+	all := c.begin()
+	for i := 0; i < count; i++ {
+		item := c.begin()
+		if errs[i] = f(item, i); errs[i] != nil {
+			c.rollback(item)
+			if !allowPartial {
+				c.rollback(all)
+				c.Unlock()
+				return errs
+			}
+			continue
+		}
+		// Keep the originals for rolling back everything
+		for k, t := range item.things {
+			if _, ok := all.things[k]; !ok {
+				all.things[k] = t
+			}
+		}
+	}
+
+	c.Unlock()
+	c.wakeWatchers()
+	return errs
+
+}
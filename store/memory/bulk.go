@@ -0,0 +1,54 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/ptypes"
+
+	"github.com/snowzach/gogrpcapi/store/query"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+// ThingImport saves the things at once replacing any existing things with the same ids
+// Deleted things are restored with the imported values and recorded as undeleted.
+func (c *Client) ThingImport(ctx context.Context, things []*thingrpc.Thing) error {
+
+	errs := c.batch(len(things), false, func(tx *tx, i int) error {
+		things[i].Etag = "" // Replace regardless of the etag
+		if existing, ok := c.things[key(ctx, things[i].Id)]; ok && existing.DeleteTime != nil {
+			t := cloneThing(existing)
+			t.Name = things[i].Name
+			t.Labels = cloneLabels(things[i].Labels)
+			t.Attributes = cloneAttributes(things[i].Attributes)
+			t.Etag = nextEtag(t.Etag)
+			t.UpdateTime = ptypes.TimestampNow()
+			t.DeleteTime = nil
+			c.put(ctx, tx, t, thingActionUndelete)
+			return nil
+		}
+		return c.thingSave(ctx, tx, things[i])
+	})
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+
+}
+
+// ThingExport calls send with every thing matching the query, in order, until it returns an error
+func (c *Client) ThingExport(ctx context.Context, q *query.Query, send func(*thingrpc.Thing) error) error {
+
+	bs, err := c.ThingFind(ctx, q)
+	if err != nil {
+		return err
+	}
+	for _, t := range bs {
+		if err = send(t); err != nil {
+			return err
+		}
+	}
+	return nil
+
+}
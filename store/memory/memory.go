@@ -0,0 +1,251 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/rs/xid"
+	config "github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"github.com/snowzach/gogrpcapi/conf"
+	"github.com/snowzach/gogrpcapi/store"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+// Client is an in memory store, it is intended for development and testing
+type Client struct {
+	logger *zap.SugaredLogger
+	newID  func() string
+
+	sync.RWMutex
+	things    map[thingKey]*thingrpc.Thing // Current things including deleted things
+	revisions map[thingKey][]*revision     // Revision history of each thing, oldest first
+	log       []*revision                  // The latest revisions of all things in the order they were made
+	logSize   int                          // The number of revisions ThingPurge keeps in the log
+	seq       int64                        // Sequence number of the last revision
+
+	// ThingWatch calls waiting for changes
+	watchers     map[chan struct{}]struct{}
+	watchersLock sync.Mutex
+}
+
+// thingKey identifies a thing, ids are unique within a tenant
+type thingKey struct {
+	Tenant string
+	ID     string
+}
+
+// key returns the key of the thing with the id in the tenant of the context
+func key(ctx context.Context, id string) thingKey {
+	return thingKey{Tenant: store.Tenant(ctx), ID: id}
+}
+
+// revision is a change made to a thing
+type revision struct {
+	Seq    int64           `json:"seq"`
+	Tenant string          `json:"tenant,omitempty"`
+	Action string          `json:"action"`
+	Actor  string          `json:"actor"`
+	Time   time.Time       `json:"time"`
+	Thing  *thingrpc.Thing `json:"thing"`
+}
+
+// key returns the key of the thing of the revision
+func (r *revision) key() thingKey {
+	return thingKey{Tenant: r.Tenant, ID: r.Thing.Id}
+}
+
+// MarshalJSON encodes the thing of the revision with jsonpb
+func (r *revision) MarshalJSON() ([]byte, error) {
+	type plain revision
+	return json.Marshal(&struct {
+		*plain
+		Thing snapshotThing `json:"thing"`
+	}{(*plain)(r), snapshotThing{r.Thing}})
+}
+
+// UnmarshalJSON decodes a revision encoded by MarshalJSON
+func (r *revision) UnmarshalJSON(b []byte) error {
+	type plain revision
+	var s struct {
+		*plain
+		Thing snapshotThing `json:"thing"`
+	}
+	s.plain = (*plain)(r)
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	r.Thing = s.Thing.Thing
+	return nil
+}
+
+// snapshot is the contents of a snapshot file
+type snapshot struct {
+	Seq       int64                      `json:"seq"`
+	Things    []snapshotThing            `json:"things"`            // Things of the default tenant
+	Tenants   map[string][]snapshotThing `json:"tenants,omitempty"` // Things of the other tenants
+	Revisions []*revision                `json:"revisions"`
+}
+
+// snapshotThing encodes a thing with jsonpb which, unlike encoding/json, supports the attributes struct
+type snapshotThing struct {
+	*thingrpc.Thing
+}
+
+// MarshalJSON implements json.Marshaler
+func (t snapshotThing) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	err := new(jsonpb.Marshaler).Marshal(&buf, t.Thing)
+	return buf.Bytes(), err
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (t *snapshotThing) UnmarshalJSON(b []byte) error {
+	t.Thing = new(thingrpc.Thing)
+	if err := jsonpb.Unmarshal(bytes.NewReader(b), t.Thing); err != nil {
+		// Snapshots written before things were encoded with jsonpb
+		t.Thing = new(thingrpc.Thing)
+		return json.Unmarshal(b, t.Thing)
+	}
+	return nil
+}
+
+// New returns a new in memory store
+// If storage.memory.snapshot is set the things are loaded from that file on start and written to it on shutdown
+func New() (*Client, error) {
+
+	c := newClient()
+
+	if filename := config.GetString("storage.memory.snapshot"); filename != "" {
+		if err := c.load(filename); err != nil {
+			return nil, fmt.Errorf("Could not load snapshot: %v", err)
+		}
+		conf.Stop.Add(1)
+		go func() {
+			defer conf.Stop.Done()
+			<-conf.Stop.Chan()
+			if err := c.save(filename); err != nil {
+				c.logger.Errorw("Could not save snapshot", "error", err, "filename", filename)
+			} else {
+				c.logger.Infow("Saved snapshot", "filename", filename)
+			}
+		}()
+	}
+
+	// Permanently remove deleted things after the retention period
+	if purgeAfter := config.GetDuration("storage.purge_after"); purgeAfter > 0 {
+		conf.Stop.Add(1)
+		go c.purger(purgeAfter, config.GetDuration("storage.purge_interval"))
+	}
+
+	return c, nil
+
+}
+
+func newClient() *Client {
+
+	return &Client{
+		logger: zap.S().With("package", "storage.memory"),
+		newID: func() string {
+			return xid.New().String()
+		},
+		things:    make(map[thingKey]*thingrpc.Thing),
+		revisions: make(map[thingKey][]*revision),
+		logSize:   thingEventLogSize,
+		watchers:  make(map[chan struct{}]struct{}),
+	}
+
+}
+
+// load reads a snapshot file, it is not an error if it does not exist
+func (c *Client) load(filename string) error {
+
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var s snapshot
+	if err = json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.seq = s.Seq
+	for _, t := range s.Things {
+		c.things[thingKey{ID: t.Id}] = t.Thing
+	}
+	for tenant, things := range s.Tenants {
+		for _, t := range things {
+			c.things[thingKey{Tenant: tenant, ID: t.Id}] = t.Thing
+		}
+	}
+	for _, r := range s.Revisions {
+		c.log = append(c.log, r)
+		c.revisions[r.key()] = append(c.revisions[r.key()], r)
+	}
+	c.trimLog()
+
+	c.logger.Infow("Loaded snapshot", "filename", filename, "things", len(s.Things))
+	return nil
+
+}
+
+// save writes a snapshot file, the file is replaced once it has been completely written
+func (c *Client) save(filename string) error {
+
+	c.RLock()
+	s := snapshot{
+		Seq:       c.seq,
+		Things:    make([]snapshotThing, 0, len(c.things)),
+		Tenants:   make(map[string][]snapshotThing),
+		Revisions: make([]*revision, 0, len(c.log)),
+	}
+	// The log only has the latest revisions, the snapshot has the whole history
+	for _, revisions := range c.revisions {
+		s.Revisions = append(s.Revisions, revisions...)
+	}
+	sort.Slice(s.Revisions, func(i, j int) bool { return s.Revisions[i].Seq < s.Revisions[j].Seq })
+	for k, t := range c.things {
+		if k.Tenant == "" {
+			s.Things = append(s.Things, snapshotThing{t})
+		} else {
+			s.Tenants[k.Tenant] = append(s.Tenants[k.Tenant], snapshotThing{t})
+		}
+	}
+	b, err := json.Marshal(&s)
+	c.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	if err = ioutil.WriteFile(filename+".tmp", b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+
+}
+
+// purger periodically purges things that were deleted longer than purgeAfter ago until the program stops
+func (c *Client) purger(purgeAfter time.Duration, interval time.Duration) {
+
+	store.Purger(interval, func() {
+		if purged := c.ThingPurge(time.Now().Add(-purgeAfter)); purged > 0 {
+			c.logger.Infow("Purged deleted things", "count", purged)
+		}
+	})
+
+}
@@ -0,0 +1,537 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/snowzach/gogrpcapi/store"
+	"github.com/snowzach/gogrpcapi/store/query"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+func TestThingSaveGetDelete(t *testing.T) {
+
+	c := newClient()
+	ctx := context.Background()
+
+	id, err := c.ThingSave(ctx, &thingrpc.Thing{Name: "name1"})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, id)
+
+	b, err := c.ThingGetById(ctx, id, false)
+	assert.Nil(t, err)
+	assert.Equal(t, "name1", b.Name)
+	assert.Equal(t, "1", b.Etag)
+	assert.NotNil(t, b.CreateTime)
+
+	// Stale etag
+	_, err = c.ThingSave(ctx, &thingrpc.Thing{Id: id, Name: "name2", Etag: "2"})
+	assert.Equal(t, store.ErrEtagMismatch, err)
+
+	// Any etag matches * but the thing must exist
+	_, err = c.ThingSave(ctx, &thingrpc.Thing{Id: "missing", Name: "name2", Etag: store.EtagAny})
+	assert.Equal(t, store.ErrNotFound, err)
+
+	_, err = c.ThingSave(ctx, &thingrpc.Thing{Id: id, Name: "name2", Etag: "1"})
+	assert.Nil(t, err)
+
+	b, err = c.ThingUpdate(ctx, &thingrpc.Thing{Id: id, Name: "name3"}, []string{"name"})
+	assert.Nil(t, err)
+	assert.Equal(t, "3", b.Etag)
+
+	// Changing the result does not change the store
+	b.Name = "changed"
+	b, err = c.ThingGetById(ctx, id, false)
+	assert.Nil(t, err)
+	assert.Equal(t, "name3", b.Name)
+
+	assert.Nil(t, c.ThingDeleteById(ctx, id, ""))
+	_, err = c.ThingGetById(ctx, id, false)
+	assert.Equal(t, store.ErrNotFound, err)
+	b, err = c.ThingGetById(ctx, id, true)
+	assert.Nil(t, err)
+	assert.NotNil(t, b.DeleteTime)
+
+	// Deleted things are not saved over
+	_, err = c.ThingSave(ctx, &thingrpc.Thing{Id: id, Name: "name4"})
+	assert.Equal(t, store.ErrNotFound, err)
+
+	// Deleting a missing thing is an error
+	assert.Equal(t, store.ErrNotFound, c.ThingDeleteById(ctx, "missing", ""))
+	assert.Equal(t, store.ErrNotFound, c.ThingDeleteById(ctx, "missing", "1"))
+	assert.Equal(t, store.ErrNotFound, c.ThingDeleteById(ctx, id, store.EtagAny))
+
+	b, err = c.ThingUndeleteById(ctx, id, "")
+	assert.Nil(t, err)
+	assert.Nil(t, b.DeleteTime)
+
+	// Undeleting a thing that is not deleted leaves it unchanged
+	etag := b.Etag
+	b, err = c.ThingUndeleteById(ctx, id, "")
+	assert.Nil(t, err)
+	assert.Equal(t, etag, b.Etag)
+
+	revisions, err := c.ThingListRevisions(ctx, id, 0, 10)
+	assert.Nil(t, err)
+	if assert.Len(t, revisions, 5) {
+		assert.Equal(t, "undelete", revisions[0].Action)
+		assert.Equal(t, "create", revisions[4].Action)
+	}
+
+	// Purge
+	assert.Nil(t, c.ThingDeleteById(ctx, id, ""))
+	assert.Equal(t, int64(1), c.ThingPurge(time.Now().Add(time.Minute)))
+	_, err = c.ThingGetById(ctx, id, true)
+	assert.Equal(t, store.ErrNotFound, err)
+
+}
+
+func TestThingCreate(t *testing.T) {
+
+	c := newClient()
+	ctx := context.Background()
+
+	id, err := c.ThingCreate(ctx, &thingrpc.Thing{Name: "name1"})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, id)
+
+	_, err = c.ThingCreate(ctx, &thingrpc.Thing{Id: id, Name: "name2"})
+	assert.Equal(t, &store.FieldError{Err: store.ErrAlreadyExists, Field: "id"}, err)
+
+	// Deleted things still use the id
+	assert.Nil(t, c.ThingDeleteById(ctx, id, ""))
+	_, err = c.ThingCreate(ctx, &thingrpc.Thing{Id: id, Name: "name2"})
+	assert.Equal(t, &store.FieldError{Err: store.ErrAlreadyExists, Field: "id"}, err)
+
+	b, err := c.ThingGetById(ctx, id, true)
+	assert.Nil(t, err)
+	assert.Equal(t, "name1", b.Name)
+
+}
+
+func TestThingAggregate(t *testing.T) {
+
+	c := newClient()
+	ctx := context.Background()
+
+	for i, thing := range []*thingrpc.Thing{
+		{Name: "a", Labels: map[string]string{"env": "prod"}},
+		{Name: "b", Labels: map[string]string{"env": "prod"}, Attributes: thingrpc.ProtoValue(map[string]interface{}{"size": 2.0}).GetStructValue()},
+		{Name: "a", Labels: map[string]string{"env": "dev"}, Attributes: thingrpc.ProtoValue(map[string]interface{}{"size": 2.0}).GetStructValue()},
+		{Name: "c"},
+	} {
+		thing.Id = "id" + string('1'+rune(i))
+		_, err := c.ThingSave(ctx, thing)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, c.ThingDeleteById(ctx, "id4", ""))
+
+	ag, err := c.ThingAggregate(ctx, &query.Query{}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, &query.Aggregation{Total: 3, Groups: []query.Group{}}, ag)
+
+	// Largest groups first, missing values are nil
+	ag, err = c.ThingAggregate(ctx, &query.Query{ShowDeleted: true}, "labels.env")
+	assert.Nil(t, err)
+	assert.Equal(t, &query.Aggregation{Total: 4, Groups: []query.Group{{Value: "prod", Count: 2}, {Value: "dev", Count: 1}, {Value: nil, Count: 1}}}, ag)
+
+	ag, err = c.ThingAggregate(ctx, &query.Query{Limit: 1}, "attributes.size")
+	assert.Nil(t, err)
+	assert.Equal(t, &query.Aggregation{Total: 3, Groups: []query.Group{{Value: 2.0, Count: 2}}}, ag)
+
+	q := &query.Query{}
+	q.Filter, err = thingrpc.ThingSchema.ParseFilter(`name != "b"`)
+	assert.Nil(t, err)
+	ag, err = c.ThingAggregate(ctx, q, "name")
+	assert.Nil(t, err)
+	assert.Equal(t, &query.Aggregation{Total: 2, Groups: []query.Group{{Value: "a", Count: 2}}}, ag)
+
+}
+
+func TestThingSearch(t *testing.T) {
+
+	c := newClient()
+
+	// Full-text search requires the postgres store
+	_, err := c.ThingSearch(context.Background(), &query.Query{OrderBy: thingrpc.ThingSearchOrderBy}, "red")
+	assert.True(t, errors.Is(err, store.ErrUnimplemented))
+
+}
+
+func TestThingTenants(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "memory")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "snapshot.json")
+
+	c := newClient()
+	ctx1 := store.WithTenant(context.Background(), "tenant1")
+	ctx2 := store.WithTenant(context.Background(), "tenant2")
+
+	// The same id in two tenants are different things
+	_, err = c.ThingSave(ctx1, &thingrpc.Thing{Id: "id1", Name: "name1"})
+	assert.Nil(t, err)
+	_, err = c.ThingSave(ctx2, &thingrpc.Thing{Id: "id1", Name: "name2"})
+	assert.Nil(t, err)
+
+	b, err := c.ThingGetById(ctx1, "id1", false)
+	assert.Nil(t, err)
+	assert.Equal(t, "name1", b.Name)
+	assert.Equal(t, "1", b.Etag)
+
+	// Other tenants can not see them
+	_, err = c.ThingGetById(context.Background(), "id1", true)
+	assert.Equal(t, store.ErrNotFound, err)
+	assert.Equal(t, store.ErrNotFound, c.ThingDeleteById(store.WithTenant(context.Background(), "tenant3"), "id1", "1"))
+	bs, err := c.ThingFind(ctx2, &query.Query{OrderBy: []query.OrderBy{{Field: "id"}}})
+	assert.Nil(t, err)
+	if assert.Len(t, bs, 1) {
+		assert.Equal(t, "name2", bs[0].Name)
+	}
+	revisions, err := c.ThingListRevisions(ctx2, "id1", 0, 10)
+	assert.Nil(t, err)
+	assert.Len(t, revisions, 1)
+
+	assert.Nil(t, c.save(filename))
+	c2 := newClient()
+	assert.Nil(t, c2.load(filename))
+	b, err = c2.ThingGetById(ctx2, "id1", false)
+	assert.Nil(t, err)
+	assert.Equal(t, "name2", b.Name)
+	_, err = c2.ThingGetById(context.Background(), "id1", false)
+	assert.Equal(t, store.ErrNotFound, err)
+
+}
+
+func TestThingFind(t *testing.T) {
+
+	c := newClient()
+	ctx := context.Background()
+
+	for _, name := range []string{"b", "a", "c", "a"} {
+		_, err := c.ThingSave(ctx, &thingrpc.Thing{Name: name})
+		assert.Nil(t, err)
+	}
+
+	q := &query.Query{Limit: 3}
+	var err error
+	q.OrderBy, err = thingrpc.ThingSchema.ParseOrderBy("name desc")
+	assert.Nil(t, err)
+	q.Filter, err = thingrpc.ThingSchema.ParseFilter(`name != "c"`)
+	assert.Nil(t, err)
+
+	bs, err := c.ThingFind(ctx, q)
+	assert.Nil(t, err)
+	if assert.Len(t, bs, 3) {
+		assert.Equal(t, []string{"b", "a", "a"}, []string{bs[0].Name, bs[1].Name, bs[2].Name})
+		assert.True(t, bs[1].Id < bs[2].Id)
+	}
+
+	// Next page
+	q.After = []interface{}{bs[1].Name, bs[1].Id}
+	bs2, err := c.ThingFind(ctx, q)
+	assert.Nil(t, err)
+	if assert.Len(t, bs2, 1) {
+		assert.Equal(t, bs[2].Id, bs2[0].Id)
+	}
+
+}
+
+func TestThingFindLabels(t *testing.T) {
+
+	c := newClient()
+	ctx := context.Background()
+
+	for id, labels := range map[string]map[string]string{
+		"id1": {"env": "prod", "tier": "a"},
+		"id2": {"env": "dev", "tier": "b"},
+		"id3": {"env": "prod"},
+		"id4": nil,
+	} {
+		_, err := c.ThingSave(ctx, &thingrpc.Thing{Id: id, Labels: labels})
+		assert.Nil(t, err)
+	}
+
+	for selector, ids := range map[string][]string{
+		"env=prod":               {"id1", "id3"},
+		"env!=prod":              {"id2", "id4"},
+		"tier in (a,b)":          {"id1", "id2"},
+		"tier notin (a),!nope":   {"id2", "id3", "id4"},
+		"env,!tier":              {"id3"},
+		"env=prod,tier in (b,c)": {},
+	} {
+		q := &query.Query{}
+		var err error
+		q.OrderBy, err = thingrpc.ThingSchema.ParseOrderBy("")
+		assert.Nil(t, err)
+		q.Labels, err = query.ParseSelector(selector)
+		assert.Nil(t, err)
+
+		bs, err := c.ThingFind(ctx, q)
+		assert.Nil(t, err)
+		found := make([]string, len(bs))
+		for i, b := range bs {
+			found[i] = b.Id
+		}
+		assert.Equal(t, ids, found, selector)
+	}
+
+}
+
+func TestThingFindAttributes(t *testing.T) {
+
+	c := newClient()
+	ctx := context.Background()
+
+	for id, color := range map[string]*structpb.Value{
+		"id1": {Kind: &structpb.Value_StringValue{StringValue: "red"}},
+		"id2": {Kind: &structpb.Value_ListValue{ListValue: &structpb.ListValue{Values: []*structpb.Value{
+			{Kind: &structpb.Value_StringValue{StringValue: "red"}},
+			{Kind: &structpb.Value_StringValue{StringValue: "blue"}},
+		}}}},
+		"id3": nil,
+	} {
+		b := &thingrpc.Thing{Id: id}
+		if color != nil {
+			b.Attributes = &structpb.Struct{Fields: map[string]*structpb.Value{"color": color}}
+		}
+		_, err := c.ThingSave(ctx, b)
+		assert.Nil(t, err)
+	}
+
+	for filter, ids := range map[string][]string{
+		`attributes.color = "red"`:  {"id1"},
+		`attributes.color != "red"`: {"id2", "id3"},
+		`attributes.color : "red"`:  {"id1", "id2"},
+		`attributes.color = null`:   {"id3"},
+	} {
+		q := &query.Query{}
+		var err error
+		q.OrderBy, err = thingrpc.ThingSchema.ParseOrderBy("")
+		assert.Nil(t, err)
+		q.Filter, err = thingrpc.ThingSchema.ParseFilter(filter)
+		assert.Nil(t, err)
+
+		bs, err := c.ThingFind(ctx, q)
+		assert.Nil(t, err)
+		found := make([]string, len(bs))
+		for i, b := range bs {
+			found[i] = b.Id
+		}
+		assert.Equal(t, ids, found, filter)
+	}
+
+}
+
+func TestThingBatch(t *testing.T) {
+
+	c := newClient()
+	ctx := context.Background()
+
+	id, err := c.ThingSave(ctx, &thingrpc.Thing{Name: "name1"})
+	assert.Nil(t, err)
+
+	// All or nothing
+	errs, err := c.ThingBatchSave(ctx, []*thingrpc.Thing{{Id: "new", Name: "new"}, {Id: id, Name: "name2", Etag: "5"}}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []error{nil, store.ErrEtagMismatch}, errs)
+	_, err = c.ThingGetById(ctx, "new", false)
+	assert.Equal(t, store.ErrNotFound, err)
+	revisions, err := c.ThingListRevisions(ctx, id, 0, 10)
+	assert.Nil(t, err)
+	assert.Len(t, revisions, 1)
+
+	// Partial
+	errs, err = c.ThingBatchSave(ctx, []*thingrpc.Thing{{Id: "new", Name: "new"}, {Id: id, Name: "name2", Etag: "5"}}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []error{nil, store.ErrEtagMismatch}, errs)
+	_, err = c.ThingGetById(ctx, "new", false)
+	assert.Nil(t, err)
+
+	bs, err := c.ThingBatchGet(ctx, []string{"new", id, "missing"}, false)
+	assert.Nil(t, err)
+	assert.Len(t, bs, 2)
+
+	errs, err = c.ThingBatchDelete(ctx, []string{"new", "missing"}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []error{nil, store.ErrNotFound}, errs)
+
+}
+
+func TestThingImportExport(t *testing.T) {
+
+	c := newClient()
+	ctx := context.Background()
+
+	id, err := c.ThingSave(ctx, &thingrpc.Thing{Name: "name1"})
+	assert.Nil(t, err)
+
+	// Replaces regardless of the etag and generates missing ids
+	err = c.ThingImport(ctx, []*thingrpc.Thing{{Id: id, Name: "name2", Etag: "5"}, {Name: "name3"}})
+	assert.Nil(t, err)
+	b, err := c.ThingGetById(ctx, id, false)
+	assert.Nil(t, err)
+	assert.Equal(t, "name2", b.Name)
+
+	// Restores deleted things
+	assert.Nil(t, c.ThingDeleteById(ctx, id, ""))
+	assert.Nil(t, c.ThingImport(ctx, []*thingrpc.Thing{{Id: id, Name: "name4"}}))
+	b, err = c.ThingGetById(ctx, id, false)
+	assert.Nil(t, err)
+	assert.Equal(t, "name4", b.Name)
+	revisions, err := c.ThingListRevisions(ctx, id, 0, 1)
+	assert.Nil(t, err)
+	if assert.Len(t, revisions, 1) {
+		assert.Equal(t, "undelete", revisions[0].Action)
+	}
+
+	orderBy, err := thingrpc.ThingSchema.ParseOrderBy("")
+	assert.Nil(t, err)
+	var exported []*thingrpc.Thing
+	err = c.ThingExport(ctx, &query.Query{OrderBy: orderBy}, func(t *thingrpc.Thing) error {
+		exported = append(exported, t)
+		return nil
+	})
+	assert.Nil(t, err)
+	if assert.Len(t, exported, 2) {
+		assert.True(t, exported[0].Id < exported[1].Id)
+	}
+
+	// Stops at the first error
+	err = c.ThingExport(ctx, &query.Query{OrderBy: orderBy}, func(t *thingrpc.Thing) error {
+		return store.ErrNotFound
+	})
+	assert.Equal(t, store.ErrNotFound, err)
+
+}
+
+func TestThingWatch(t *testing.T) {
+
+	c := newClient()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Make one change before watching to resume from
+	_, err := c.ThingSave(ctx, &thingrpc.Thing{Id: "id1", Name: "name1"})
+	assert.Nil(t, err)
+
+	events := make(chan *thingrpc.ThingEvent)
+	go c.ThingWatch(ctx, 0, func(seq int64, event *thingrpc.ThingEvent) error {
+		events <- event
+		return nil
+	})
+
+	// Wait for the watcher to start
+	for {
+		c.watchersLock.Lock()
+		watching := len(c.watchers) > 0
+		c.watchersLock.Unlock()
+		if watching {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.Nil(t, c.ThingDeleteById(ctx, "id1", ""))
+	select {
+	case event := <-events:
+		assert.Equal(t, thingrpc.ThingEvent_DELETED, event.Type)
+		assert.Equal(t, "id1", event.Thing.Id)
+	case <-time.After(time.Second):
+		assert.Fail(t, "no event")
+	}
+
+	// Resume from the start
+	var seqs []int64
+	err = c.ThingWatch(ctx, 1, func(seq int64, event *thingrpc.ThingEvent) error {
+		seqs = append(seqs, seq)
+		return context.Canceled
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, []int64{2}, seqs)
+
+}
+
+func TestThingPurge(t *testing.T) {
+
+	c := newClient()
+	c.logSize = 3
+	ctx := context.Background()
+
+	for _, id := range []string{"id1", "id2"} {
+		_, err := c.ThingSave(ctx, &thingrpc.Thing{Id: id, Name: id})
+		assert.Nil(t, err)
+		_, err = c.ThingSave(ctx, &thingrpc.Thing{Id: id, Name: id + "b"})
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, c.ThingDeleteById(ctx, "id1", ""))
+
+	assert.Equal(t, int64(1), c.ThingPurge(time.Now().Add(time.Minute)))
+
+	// The history of purged things is removed, the others keep theirs
+	revisions, err := c.ThingListRevisions(ctx, "id1", 0, 10)
+	assert.Nil(t, err)
+	assert.Len(t, revisions, 0)
+	revisions, err = c.ThingListRevisions(ctx, "id2", 0, 10)
+	assert.Nil(t, err)
+	assert.Len(t, revisions, 2)
+
+	// The log only keeps the latest changes
+	if assert.Len(t, c.log, 3) {
+		assert.Equal(t, int64(3), c.log[0].Seq)
+	}
+	var seqs []int64
+	err = c.ThingWatch(ctx, 1, func(seq int64, event *thingrpc.ThingEvent) error {
+		seqs = append(seqs, seq)
+		if len(seqs) == 3 {
+			return context.Canceled
+		}
+		return nil
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, []int64{3, 4, 5}, seqs)
+
+}
+
+func TestSnapshot(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "memory")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "snapshot.json")
+
+	c := newClient()
+	ctx := context.Background()
+	attributes := &structpb.Struct{Fields: map[string]*structpb.Value{
+		"color": {Kind: &structpb.Value_StringValue{StringValue: "red"}},
+	}}
+	id, err := c.ThingSave(ctx, &thingrpc.Thing{Name: "name1", Attributes: attributes})
+	assert.Nil(t, err)
+	assert.Nil(t, c.save(filename))
+
+	c2 := newClient()
+	assert.Nil(t, c2.load(filename))
+	b, err := c2.ThingGetById(ctx, id, false)
+	assert.Nil(t, err)
+	assert.Equal(t, "name1", b.Name)
+	assert.True(t, proto.Equal(attributes, b.Attributes))
+	revisions, err := c2.ThingListRevisions(ctx, id, 0, 10)
+	assert.Nil(t, err)
+	if assert.Len(t, revisions, 1) {
+		assert.True(t, proto.Equal(attributes, revisions[0].Thing.Attributes))
+	}
+
+	// Missing files are not an error
+	assert.Nil(t, newClient().load(filepath.Join(dir, "missing.json")))
+
+}
@@ -0,0 +1,126 @@
+package memory
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+
+	"github.com/snowzach/gogrpcapi/store"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+// tx records what a batch changed so it can be rolled back
+type tx struct {
+	things map[thingKey]*thingrpc.Thing // The things before they were changed, nil if they did not exist
+	logLen int
+	seq    int64
+}
+
+// begin starts recording changes, the caller must hold the lock
+func (c *Client) begin() *tx {
+	return &tx{
+		things: make(map[thingKey]*thingrpc.Thing),
+		logLen: len(c.log),
+		seq:    c.seq,
+	}
+}
+
+// rollback undoes the changes made since begin, the caller must hold the lock
+func (c *Client) rollback(tx *tx) {
+
+	for i := len(c.log) - 1; i >= tx.logLen; i-- {
+		k := c.log[i].key()
+		if revisions := c.revisions[k]; len(revisions) > 1 {
+			c.revisions[k] = revisions[:len(revisions)-1]
+		} else {
+			delete(c.revisions, k)
+		}
+	}
+	c.log = c.log[:tx.logLen]
+	c.seq = tx.seq
+
+	for k, t := range tx.things {
+		if t == nil {
+			delete(c.things, k)
+		} else {
+			c.things[k] = t
+		}
+	}
+
+}
+
+// put stores a new version of a thing and records it in the revision history, the caller must hold the lock
+func (c *Client) put(ctx context.Context, tx *tx, t *thingrpc.Thing, action string) {
+
+	k := key(ctx, t.Id)
+	if tx != nil {
+		if _, ok := tx.things[k]; !ok {
+			tx.things[k] = c.things[k]
+		}
+	}
+	c.things[k] = t
+
+	c.seq++
+	r := &revision{
+		Seq:    c.seq,
+		Tenant: k.Tenant,
+		Action: action,
+		Actor:  store.Actor(ctx),
+		Time:   time.Now(),
+		Thing:  t,
+	}
+	c.log = append(c.log, r)
+	c.revisions[k] = append(c.revisions[k], r)
+
+}
+
+// thingRevision converts a revision to a thing revision
+func (r *revision) thingRevision() *thingrpc.ThingRevision {
+	revisionTime, _ := ptypes.TimestampProto(r.Time)
+	return &thingrpc.ThingRevision{
+		Revision:     r.Thing.Etag,
+		Action:       r.Action,
+		Actor:        r.Actor,
+		RevisionTime: revisionTime,
+		Thing:        cloneThing(r.Thing),
+	}
+}
+
+// ThingGetAsOf returns the thing as it was at a point in time from the revision history
+// Things that were deleted at that time are only returned if showDeleted is true
+func (c *Client) ThingGetAsOf(ctx context.Context, id string, asOf time.Time, showDeleted bool) (*thingrpc.Thing, error) {
+
+	c.RLock()
+	defer c.RUnlock()
+
+	revisions := c.revisions[key(ctx, id)]
+	for i := len(revisions) - 1; i >= 0; i-- {
+		if r := revisions[i]; !r.Time.After(asOf) {
+			if r.Thing.DeleteTime != nil && !showDeleted {
+				return nil, store.ErrNotFound
+			}
+			return cloneThing(r.Thing), nil
+		}
+	}
+	return nil, store.ErrNotFound
+
+}
+
+// ThingListRevisions returns up to limit revisions of a thing, newest first, older than the before revision (0 = latest)
+func (c *Client) ThingListRevisions(ctx context.Context, id string, before int64, limit int) ([]*thingrpc.ThingRevision, error) {
+
+	c.RLock()
+	defer c.RUnlock()
+
+	revisions := make([]*thingrpc.ThingRevision, 0)
+	history := c.revisions[key(ctx, id)]
+	for i := len(history) - 1; i >= 0 && len(revisions) < limit; i-- {
+		if version, _ := strconv.ParseInt(history[i].Thing.Etag, 10, 64); before == 0 || version < before {
+			revisions = append(revisions, history[i].thingRevision())
+		}
+	}
+	return revisions, nil
+
+}
@@ -0,0 +1,347 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	structpb "github.com/golang/protobuf/ptypes/struct"
+
+	"github.com/snowzach/gogrpcapi/store"
+	"github.com/snowzach/gogrpcapi/store/query"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+// Actions recorded in the thing revision history
+const (
+	thingActionCreate   = "create"
+	thingActionUpdate   = "update"
+	thingActionDelete   = "delete"
+	thingActionUndelete = "undelete"
+)
+
+// ThingGetById returns the the thing by ID
+// Deleted things are only returned if showDeleted is true
+func (c *Client) ThingGetById(ctx context.Context, id string, showDeleted bool) (*thingrpc.Thing, error) {
+
+	c.RLock()
+	defer c.RUnlock()
+
+	t, ok := c.things[key(ctx, id)]
+	if !ok || (t.DeleteTime != nil && !showDeleted) {
+		return nil, store.ErrNotFound
+	}
+	return cloneThing(t), nil
+
+}
+
+// ThingSave saves the thing
+// If the thing has an etag it must match the stored thing or ErrEtagMismatch is returned
+func (c *Client) ThingSave(ctx context.Context, i *thingrpc.Thing) (string, error) {
+
+	c.Lock()
+	err := c.thingSave(ctx, nil, i)
+	c.Unlock()
+	if err != nil {
+		return i.Id, err
+	}
+	c.wakeWatchers()
+	return i.Id, nil
+
+}
+
+// ThingCreate saves a new thing
+// ErrAlreadyExists is returned if a thing with the id exists, including deleted things that have not been purged
+func (c *Client) ThingCreate(ctx context.Context, i *thingrpc.Thing) (string, error) {
+
+	c.Lock()
+	// Generate an ID if needed
+	if i.Id == "" {
+		i.Id = c.newID()
+	}
+	if _, ok := c.things[key(ctx, i.Id)]; ok {
+		c.Unlock()
+		return i.Id, &store.FieldError{Err: store.ErrAlreadyExists, Field: "id"}
+	}
+	now := ptypes.TimestampNow()
+	c.put(ctx, nil, &thingrpc.Thing{
+		Id:         i.Id,
+		Name:       i.Name,
+		Labels:     cloneLabels(i.Labels),
+		Attributes: cloneAttributes(i.Attributes),
+		Etag:       "1",
+		CreateTime: now,
+		UpdateTime: now,
+	}, thingActionCreate)
+	c.Unlock()
+	c.wakeWatchers()
+	return i.Id, nil
+
+}
+
+// thingSave saves the thing as part of a transaction (tx may be nil), the caller must hold the lock
+func (c *Client) thingSave(ctx context.Context, tx *tx, i *thingrpc.Thing) error {
+
+	// Generate an ID if needed
+	if i.Id == "" {
+		i.Id = c.newID()
+	}
+
+	now := ptypes.TimestampNow()
+	existing, ok := c.things[key(ctx, i.Id)]
+	if ok || i.Etag != "" {
+		// Only update the existing thing if it is not deleted and the etag, if any, matches
+		if err := c.thingMissingError(ctx, i.Id, i.Etag); err != nil {
+			return err
+		}
+	} else {
+		c.put(ctx, tx, &thingrpc.Thing{
+			Id:         i.Id,
+			Name:       i.Name,
+			Labels:     cloneLabels(i.Labels),
+			Attributes: cloneAttributes(i.Attributes),
+			Etag:       "1",
+			CreateTime: now,
+			UpdateTime: now,
+		}, thingActionCreate)
+		return nil
+	}
+
+	t := cloneThing(existing)
+	t.Name = i.Name
+	t.Labels = cloneLabels(i.Labels)
+	t.Attributes = cloneAttributes(i.Attributes)
+	t.Etag = nextEtag(t.Etag)
+	t.UpdateTime = now
+	c.put(ctx, tx, t, thingActionUpdate)
+	return nil
+
+}
+
+// ThingUpdate updates only the given fields of the thing and returns the result
+// If the thing has an etag it must match the stored thing or ErrEtagMismatch is returned
+func (c *Client) ThingUpdate(ctx context.Context, i *thingrpc.Thing, paths []string) (*thingrpc.Thing, error) {
+
+	c.Lock()
+	if err := c.thingMissingError(ctx, i.Id, i.Etag); err != nil {
+		c.Unlock()
+		return nil, err
+	}
+
+	t := cloneThing(c.things[key(ctx, i.Id)])
+	for _, path := range paths {
+		switch path {
+		case "name":
+			t.Name = i.Name
+		case "labels":
+			t.Labels = cloneLabels(i.Labels)
+		case "attributes":
+			t.Attributes = cloneAttributes(i.Attributes)
+		default:
+			c.Unlock()
+			return nil, fmt.Errorf("unknown update path: %s", path)
+		}
+	}
+	t.Etag = nextEtag(t.Etag)
+	t.UpdateTime = ptypes.TimestampNow()
+	c.put(ctx, nil, t, thingActionUpdate)
+	c.Unlock()
+
+	c.wakeWatchers()
+	return cloneThing(t), nil
+
+}
+
+// ThingDeleteById marks a thing as deleted, it is removed by ThingPurge after the retention period
+// ErrNotFound is returned if the thing does not exist or is already deleted.
+// If etag is not empty it must match the stored thing or ErrEtagMismatch is returned
+func (c *Client) ThingDeleteById(ctx context.Context, id string, etag string) error {
+
+	c.Lock()
+	err := c.thingDelete(ctx, nil, id, etag)
+	c.Unlock()
+	if err != nil {
+		return err
+	}
+	c.wakeWatchers()
+	return nil
+
+}
+
+// thingDelete marks a thing as deleted as part of a transaction (tx may be nil), the caller must hold the lock
+func (c *Client) thingDelete(ctx context.Context, tx *tx, id string, etag string) error {
+
+	if err := c.thingMissingError(ctx, id, etag); err != nil {
+		return err
+	}
+
+	t := cloneThing(c.things[key(ctx, id)])
+	t.Etag = nextEtag(t.Etag)
+	t.DeleteTime = ptypes.TimestampNow()
+	c.put(ctx, tx, t, thingActionDelete)
+	return nil
+
+}
+
+// ThingUndeleteById restores a deleted thing, things that are not deleted are returned unchanged
+// If etag is not empty it must match the stored thing or ErrEtagMismatch is returned
+func (c *Client) ThingUndeleteById(ctx context.Context, id string, etag string) (*thingrpc.Thing, error) {
+
+	c.Lock()
+	existing, ok := c.things[key(ctx, id)]
+	if !ok {
+		c.Unlock()
+		return nil, store.ErrNotFound
+	} else if !store.EtagMatches(etag, existing.Etag) {
+		c.Unlock()
+		return nil, store.ErrEtagMismatch
+	} else if existing.DeleteTime == nil {
+		c.Unlock()
+		return cloneThing(existing), nil
+	}
+
+	t := cloneThing(existing)
+	t.Etag = nextEtag(t.Etag)
+	t.DeleteTime = nil
+	c.put(ctx, nil, t, thingActionUndelete)
+	c.Unlock()
+
+	c.wakeWatchers()
+	return cloneThing(t), nil
+
+}
+
+// thingMissingError returns why a change to a thing can not be made, nil if it can, the caller must hold the lock
+func (c *Client) thingMissingError(ctx context.Context, id string, etag string) error {
+
+	t, ok := c.things[key(ctx, id)]
+	if !ok || t.DeleteTime != nil {
+		return store.ErrNotFound
+	} else if !store.EtagMatches(etag, t.Etag) {
+		return store.ErrEtagMismatch
+	}
+	return nil
+
+}
+
+// ThingPurge permanently removes things of every tenant deleted before the given time and returns how many were removed
+// Their revision history is removed too and the log of changes for ThingWatch is trimmed to its size.
+func (c *Client) ThingPurge(before time.Time) int64 {
+
+	c.Lock()
+	defer c.Unlock()
+
+	var purged int64
+	for k, t := range c.things {
+		if t.DeleteTime != nil {
+			if deleteTime, err := ptypes.Timestamp(t.DeleteTime); err == nil && deleteTime.Before(before) {
+				delete(c.things, k)
+				delete(c.revisions, k)
+				purged++
+			}
+		}
+	}
+	c.trimLog()
+	return purged
+
+}
+
+// ThingFind gets things
+func (c *Client) ThingFind(ctx context.Context, q *query.Query) ([]*thingrpc.Thing, error) {
+
+	c.RLock()
+	defer c.RUnlock()
+
+	tenant := store.Tenant(ctx)
+	bs := make([]*thingrpc.Thing, 0)
+	for k, t := range c.things {
+		if k.Tenant != tenant || (t.DeleteTime != nil && !q.ShowDeleted) {
+			continue
+		}
+		value := thingValue(t)
+		if query.Match(q.Filter, value) && q.Labels.Matches(t.Labels) && query.IsAfter(q.OrderBy, q.After, value) {
+			bs = append(bs, t)
+		}
+	}
+
+	sort.Slice(bs, func(i, j int) bool {
+		return query.Less(q.OrderBy, thingValue(bs[i]), thingValue(bs[j]))
+	})
+	if q.Limit > 0 && len(bs) > q.Limit {
+		bs = bs[:q.Limit]
+	}
+
+	for i, t := range bs {
+		bs[i] = cloneThing(t)
+	}
+	return bs, nil
+
+}
+
+// ThingAggregate counts things
+func (c *Client) ThingAggregate(ctx context.Context, q *query.Query, groupBy string) (*query.Aggregation, error) {
+
+	c.RLock()
+	defer c.RUnlock()
+
+	tenant := store.Tenant(ctx)
+	a := query.NewAggregator(groupBy)
+	for k, t := range c.things {
+		if k.Tenant != tenant || (t.DeleteTime != nil && !q.ShowDeleted) {
+			continue
+		}
+		value := thingValue(t)
+		if query.Match(q.Filter, value) && q.Labels.Matches(t.Labels) {
+			a.Add(value)
+		}
+	}
+	return a.Aggregation(q.Limit), nil
+
+}
+
+// ThingSearch is not supported, full-text search requires the postgres store
+func (c *Client) ThingSearch(ctx context.Context, q *query.Query, text string) ([]*thingrpc.ThingSearchResult, error) {
+	return nil, store.ErrUnimplemented.Errorf("Search requires the postgres store")
+}
+
+// thingValue returns the query values of a thing
+func thingValue(t *thingrpc.Thing) query.Value {
+	return func(field string) interface{} {
+		return thingrpc.ThingFieldValue(t, field)
+	}
+}
+
+// cloneLabels returns a copy of labels so the stored thing does not share the map of the caller
+func cloneLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	ret := make(map[string]string, len(labels))
+	for k, v := range labels {
+		ret[k] = v
+	}
+	return ret
+}
+
+// cloneAttributes returns a copy of attributes so the stored thing does not share them with the caller
+func cloneAttributes(attributes *structpb.Struct) *structpb.Struct {
+	if attributes == nil {
+		return nil
+	}
+	return proto.Clone(attributes).(*structpb.Struct)
+}
+
+// cloneThing returns a copy of a thing so it can not be changed outside of the lock
+func cloneThing(t *thingrpc.Thing) *thingrpc.Thing {
+	return proto.Clone(t).(*thingrpc.Thing)
+}
+
+// nextEtag returns the etag of the next version of a thing
+func nextEtag(etag string) string {
+	version, _ := strconv.ParseInt(etag, 10, 64)
+	return strconv.FormatInt(version+1, 10)
+}
@@ -0,0 +1,116 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/snowzach/gogrpcapi/store"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+// thingEventBatch is the maximum number of events copied at once
+const thingEventBatch = 100
+
+// thingEventLogSize is the number of revisions kept in the log for ThingWatch
+// Watchers resuming from an older sequence number miss the changes that were trimmed.
+const thingEventLogSize = 10000
+
+// thingEvent converts a revision to a thing event
+func (r *revision) thingEvent() *thingrpc.ThingEvent {
+	revision := r.thingRevision()
+	e := &thingrpc.ThingEvent{
+		Thing:     revision.Thing,
+		Actor:     revision.Actor,
+		EventTime: revision.RevisionTime,
+	}
+	switch r.Action {
+	case thingActionCreate:
+		e.Type = thingrpc.ThingEvent_CREATED
+	case thingActionUpdate, thingActionUndelete:
+		e.Type = thingrpc.ThingEvent_UPDATED
+	case thingActionDelete:
+		e.Type = thingrpc.ThingEvent_DELETED
+	}
+	return e
+}
+
+// ThingWatch calls send with each change to things after the after sequence number (0 = now) in order
+// It returns when the context is canceled or send returns an error
+func (c *Client) ThingWatch(ctx context.Context, after int64, send func(int64, *thingrpc.ThingEvent) error) error {
+
+	wake := make(chan struct{}, 1)
+	c.watchersLock.Lock()
+	c.watchers[wake] = struct{}{}
+	c.watchersLock.Unlock()
+	defer func() {
+		c.watchersLock.Lock()
+		delete(c.watchers, wake)
+		c.watchersLock.Unlock()
+	}()
+
+	tenant := store.Tenant(ctx)
+
+	// Start with changes made from now on
+	if after <= 0 {
+		c.RLock()
+		after = c.seq
+		c.RUnlock()
+	}
+
+	for {
+		// Copy the next events so they can be sent without holding the lock
+		c.RLock()
+		start := sort.Search(len(c.log), func(i int) bool { return c.log[i].Seq > after })
+		end := start + thingEventBatch
+		if end > len(c.log) {
+			end = len(c.log)
+		}
+		rs := append([]*revision(nil), c.log[start:end]...)
+		c.RUnlock()
+
+		for _, r := range rs {
+			if r.Tenant == tenant {
+				if err := send(r.Seq, r.thingEvent()); err != nil {
+					return err
+				}
+			}
+			after = r.Seq
+		}
+
+		// There may be more waiting
+		if len(rs) == thingEventBatch {
+			continue
+		}
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+}
+
+// trimLog drops the oldest revisions from the log when it has more than logSize, the caller must hold the lock
+// The revision history of things that have not been purged keeps them.
+func (c *Client) trimLog() {
+
+	if len(c.log) > c.logSize {
+		c.log = append([]*revision(nil), c.log[len(c.log)-c.logSize:]...)
+	}
+
+}
+
+// wakeWatchers tells the ThingWatch calls there are changes
+func (c *Client) wakeWatchers() {
+
+	c.watchersLock.Lock()
+	for wake := range c.watchers {
+		select {
+		case wake <- struct{}{}:
+		default: // Already awake
+		}
+	}
+	c.watchersLock.Unlock()
+
+}
@@ -0,0 +1,90 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/snowzach/gogrpcapi/store"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+// errBatchFailed is used to roll back a batch when an item fails and partial success is not allowed
+var errBatchFailed = errors.New("batch failed")
+
+// ThingBatchGet returns the things with the given ids that exist, in no particular order
+func (c *Client) ThingBatchGet(ctx context.Context, ids []string, showDeleted bool) ([]*thingrpc.Thing, error) {
+
+	var rs []*thingRow
+	err := c.withTx(ctx, func(tx *sqlx.Tx) error {
+		return tx.SelectContext(ctx, &rs, `SELECT `+thingColumns+` FROM thing WHERE tenant = $1 AND id = ANY($2) AND ($3 OR delete_time IS NULL)`, store.Tenant(ctx), pq.Array(ids), showDeleted)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var bs = make([]*thingrpc.Thing, len(rs))
+	for i, r := range rs {
+		bs[i] = r.thing()
+	}
+	return bs, nil
+
+}
+
+// ThingBatchSave saves all of the things in one transaction
+func (c *Client) ThingBatchSave(ctx context.Context, things []*thingrpc.Thing, allowPartial bool) ([]error, error) {
+
+	return c.batch(ctx, len(things), allowPartial, func(tx *sqlx.Tx, i int) error {
+		return c.thingSave(ctx, tx, things[i])
+	})
+
+}
+
+// ThingBatchDelete marks all of the things as deleted in one transaction
+func (c *Client) ThingBatchDelete(ctx context.Context, ids []string, allowPartial bool) ([]error, error) {
+
+	return c.batch(ctx, len(ids), allowPartial, func(tx *sqlx.Tx, i int) error {
+		return c.thingDelete(ctx, tx, ids[i], "")
+	})
+
+}
+
+// batch runs f for count items in one transaction returning the error for each item.
+// Each item runs in a savepoint so a failed item can be rolled back while keeping the others.
+// If any item fails and allowPartial is false, the entire transaction is rolled back.
+func (c *Client) batch(ctx context.Context, count int, allowPartial bool, f func(tx *sqlx.Tx, i int) error) ([]error, error) {
+
+	errs := make([]error, count)
+	err := c.withTx(ctx, func(tx *sqlx.Tx) error {
+		var failed bool
+		for i := 0; i < count; i++ {
+			if _, err := tx.ExecContext(ctx, `SAVEPOINT batch_item`); err != nil {
+				return err
+			}
+			if errs[i] = f(tx, i); errs[i] != nil {
+				errs[i] = storeError(errs[i])
+				failed = true
+				if _, err := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT batch_item`); err != nil {
+					return err
+				}
+				if !allowPartial {
+					break
+				}
+			}
+			if _, err := tx.ExecContext(ctx, `RELEASE SAVEPOINT batch_item`); err != nil {
+				return err
+			}
+		}
+		if failed && !allowPartial {
+			return errBatchFailed
+		}
+		return nil
+	})
+	if err == errBatchFailed {
+		return errs, nil
+	}
+	return errs, err
+
+}
@@ -0,0 +1,131 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/snowzach/gogrpcapi/store"
+	"github.com/snowzach/gogrpcapi/store/query"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+// ThingImport saves the things in one transaction replacing any existing things with the same ids
+// Deleted things are restored with the imported values and recorded as undeleted. The things are copied into a temporary table with COPY and merged with a single statement.
+// If an id is imported more than once the last one is saved.
+func (c *Client) ThingImport(ctx context.Context, things []*thingrpc.Thing) error {
+
+	return c.withTx(ctx, func(tx *sqlx.Tx) error {
+
+		_, err := tx.ExecContext(ctx, `
+			CREATE TEMPORARY TABLE thing_import (
+				n BIGSERIAL,
+				id TEXT NOT NULL,
+				name TEXT,
+				labels JSONB NOT NULL,
+				attributes JSONB NOT NULL,
+				undelete BOOLEAN NOT NULL DEFAULT FALSE
+			) ON COMMIT DROP
+		`)
+		if err != nil {
+			return err
+		}
+
+		stmt, err := tx.PrepareContext(ctx, pq.CopyIn("thing_import", "id", "name", "labels", "attributes"))
+		if err != nil {
+			return err
+		}
+		for _, t := range things {
+			if t.Id == "" {
+				t.Id = c.newID()
+			}
+			l, err := labels(t.Labels).Value()
+			if err != nil {
+				stmt.Close()
+				return err
+			}
+			a, err := attributes{t.Attributes}.Value()
+			if err != nil {
+				stmt.Close()
+				return err
+			}
+			if _, err = stmt.ExecContext(ctx, t.Id, t.Name, l, a); err != nil {
+				stmt.Close()
+				return err
+			}
+		}
+		// Flush the copy
+		if _, err = stmt.ExecContext(ctx); err != nil {
+			stmt.Close()
+			return err
+		}
+		if err = stmt.Close(); err != nil {
+			return err
+		}
+
+		// Deleted things are restored and recorded as undeleted, the existing things are locked so none are deleted
+		// before they are merged
+		_, err = tx.ExecContext(ctx, `
+			WITH existing AS (
+				SELECT id, delete_time IS NOT NULL AS deleted FROM thing
+				WHERE tenant = $1 AND id IN (SELECT id FROM thing_import) FOR UPDATE
+			)
+			UPDATE thing_import SET undelete = existing.deleted FROM existing WHERE thing_import.id = existing.id
+		`, store.Tenant(ctx))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO thing (tenant, id, name, labels, attributes)
+			SELECT DISTINCT ON (id) $1, id, name, labels, attributes FROM thing_import ORDER BY id, n DESC
+			ON CONFLICT (tenant, id) DO UPDATE
+			SET name = EXCLUDED.name, labels = EXCLUDED.labels, attributes = EXCLUDED.attributes, version = thing.version + 1,
+				update_time = NOW(), delete_time = NULL
+		`, store.Tenant(ctx))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO thing_revision (tenant, thing_id, revision, action, actor, revision_time, name, labels, attributes, create_time, update_time, delete_time)
+			SELECT tenant, id, version, CASE WHEN version = 1 THEN $2 WHEN id IN (SELECT id FROM thing_import WHERE undelete) THEN $3 ELSE $4 END,
+				$5, NOW(), name, labels, attributes, create_time, update_time, delete_time
+			FROM thing WHERE tenant = $1 AND id IN (SELECT id FROM thing_import)
+		`, store.Tenant(ctx), thingActionCreate, thingActionUndelete, thingActionUpdate, store.Actor(ctx))
+		return err
+
+	})
+
+}
+
+// ThingExport calls send with every thing matching the query, in order, until it returns an error
+// The things are read from a single query as they are sent.
+func (c *Client) ThingExport(ctx context.Context, q *query.Query, send func(*thingrpc.Thing) error) error {
+
+	qb, err := thingQuery(ctx, q)
+	if err != nil {
+		return err
+	}
+
+	return c.withTx(ctx, func(tx *sqlx.Tx) error {
+		rows, err := tx.QueryxContext(ctx, `SELECT `+thingColumns+` FROM thing`+qb.SQL(q), qb.Args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var r thingRow
+			if err = rows.StructScan(&r); err != nil {
+				return err
+			}
+			if err = send(r.thing()); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	})
+
+}
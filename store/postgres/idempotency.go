@@ -0,0 +1,106 @@
+package postgres
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/snowzach/gogrpcapi/store"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+// idempotent runs the change f unless the idempotency key of the context was already used within the idempotency
+// window, in which case the response of the first change is returned. The request identifies the change so a key
+// repeated with a different change returns ErrIdempotencyKeyReused. Changes without a key always run, as do all
+// changes if the window is 0.
+func (c *Client) idempotent(ctx context.Context, tx *sqlx.Tx, request string, f func() (string, error)) (string, error) {
+
+	key := store.IdempotencyKey(ctx)
+	if key == "" || c.idempotencyWindow <= 0 {
+		return f()
+	}
+
+	// Forget the key once the window has passed
+	if _, err := tx.ExecContext(ctx, `DELETE FROM idempotency_key WHERE tenant = $1 AND key = $2 AND create_time < $3`,
+		store.Tenant(ctx), key, time.Now().Add(-c.idempotencyWindow)); err != nil {
+		return "", err
+	}
+
+	// Claim the key, this waits for any concurrent change with the same key to finish
+	result, err := tx.ExecContext(ctx, `INSERT INTO idempotency_key (tenant, key, request) VALUES ($1, $2, $3) ON CONFLICT (tenant, key) DO NOTHING`,
+		store.Tenant(ctx), key, request)
+	if err != nil {
+		return "", err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return "", err
+	} else if rows == 0 {
+		var stored struct {
+			Request  string `db:"request"`
+			Response string `db:"response"`
+		}
+		err = tx.GetContext(ctx, &stored, `SELECT request, response FROM idempotency_key WHERE tenant = $1 AND key = $2`, store.Tenant(ctx), key)
+		if err == sql.ErrNoRows {
+			return "", store.ErrNotFound
+		} else if err != nil {
+			return "", err
+		}
+		if stored.Request != request {
+			return "", store.ErrIdempotencyKeyReused
+		}
+		return stored.Response, nil
+	}
+
+	response, err := f()
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE idempotency_key SET response = $3 WHERE tenant = $1 AND key = $2`, store.Tenant(ctx), key, response)
+	return response, err
+
+}
+
+// idempotencyRequest identifies a change by its operation and arguments
+func idempotencyRequest(operation string, args ...string) string {
+
+	h := sha256.New()
+	for _, arg := range args {
+		h.Write([]byte(arg))
+		h.Write([]byte{0})
+	}
+	return operation + ":" + hex.EncodeToString(h.Sum(nil))
+
+}
+
+// thingIdempotencyRequest identifies a change to the thing as requested, before an id is generated
+func thingIdempotencyRequest(operation string, i *thingrpc.Thing) (string, error) {
+
+	buf := proto.NewBuffer(nil)
+	buf.SetDeterministic(true)
+	if err := buf.Marshal(i); err != nil {
+		return "", err
+	}
+	return idempotencyRequest(operation, string(buf.Bytes())), nil
+
+}
+
+// IdempotencyKeyPurge removes the idempotency keys of every tenant created before the given time
+func (c *Client) IdempotencyKeyPurge(ctx context.Context, before time.Time) (int64, error) {
+
+	var purged int64
+	err := c.withTx(store.WithTenant(ctx, allTenants), func(tx *sqlx.Tx) error {
+		result, err := tx.ExecContext(ctx, `DELETE FROM idempotency_key WHERE create_time < $1`, before)
+		if err != nil {
+			return err
+		}
+		purged, err = result.RowsAffected()
+		return err
+	})
+	return purged, err
+
+}
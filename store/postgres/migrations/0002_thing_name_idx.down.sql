@@ -0,0 +1 @@
+DROP INDEX IF EXISTS thing_name_id_idx;
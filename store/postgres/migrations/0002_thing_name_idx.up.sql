@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS thing_name_id_idx ON thing (name, id);
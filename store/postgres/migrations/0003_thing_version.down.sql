@@ -0,0 +1 @@
+ALTER TABLE thing DROP COLUMN IF EXISTS version;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS thing_update_time_id_idx;
+DROP INDEX IF EXISTS thing_create_time_id_idx;
+ALTER TABLE thing DROP COLUMN IF EXISTS update_time;
+ALTER TABLE thing DROP COLUMN IF EXISTS create_time;
@@ -0,0 +1,4 @@
+ALTER TABLE thing ADD COLUMN IF NOT EXISTS create_time TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE thing ADD COLUMN IF NOT EXISTS update_time TIMESTAMPTZ NOT NULL DEFAULT NOW();
+CREATE INDEX IF NOT EXISTS thing_create_time_id_idx ON thing (create_time, id);
+CREATE INDEX IF NOT EXISTS thing_update_time_id_idx ON thing (update_time, id);
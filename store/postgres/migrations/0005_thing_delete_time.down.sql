@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS thing_delete_time_idx;
+ALTER TABLE thing DROP COLUMN IF EXISTS delete_time;
@@ -0,0 +1,2 @@
+ALTER TABLE thing ADD COLUMN IF NOT EXISTS delete_time TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS thing_delete_time_idx ON thing (delete_time) WHERE delete_time IS NOT NULL;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS thing_revision (
+  thing_id TEXT NOT NULL,
+  revision BIGINT NOT NULL,
+  action TEXT NOT NULL,
+  actor TEXT NOT NULL DEFAULT '',
+  revision_time TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  name TEXT,
+  create_time TIMESTAMPTZ NOT NULL,
+  update_time TIMESTAMPTZ NOT NULL,
+  delete_time TIMESTAMPTZ,
+  PRIMARY KEY (thing_id, revision)
+);
+
+-- Start the history with the current state of every thing
+INSERT INTO thing_revision (thing_id, revision, action, revision_time, name, create_time, update_time, delete_time)
+SELECT id, version, 'create', update_time, name, create_time, update_time, delete_time FROM thing
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS thing_revision_notify ON thing_revision;
+DROP FUNCTION IF EXISTS thing_revision_notify();
+DROP INDEX IF EXISTS thing_revision_seq_idx;
+ALTER TABLE thing_revision DROP COLUMN IF EXISTS seq;
@@ -0,0 +1,15 @@
+-- Global order of changes, used as the ThingWatch resume token
+ALTER TABLE thing_revision ADD COLUMN IF NOT EXISTS seq BIGSERIAL;
+CREATE UNIQUE INDEX IF NOT EXISTS thing_revision_seq_idx ON thing_revision (seq);
+
+-- Wake up watchers when a change is committed
+CREATE OR REPLACE FUNCTION thing_revision_notify() RETURNS TRIGGER AS $$
+BEGIN
+  PERFORM pg_notify('thing_event', '');
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS thing_revision_notify ON thing_revision;
+CREATE TRIGGER thing_revision_notify AFTER INSERT ON thing_revision
+FOR EACH STATEMENT EXECUTE PROCEDURE thing_revision_notify();
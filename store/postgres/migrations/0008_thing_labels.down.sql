@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS thing_labels_idx;
+ALTER TABLE thing_revision DROP COLUMN IF EXISTS labels;
+ALTER TABLE thing DROP COLUMN IF EXISTS labels;
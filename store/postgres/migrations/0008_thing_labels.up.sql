@@ -0,0 +1,5 @@
+ALTER TABLE thing ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE thing_revision ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+
+-- Supports the label selector containment (@>) and existence (?) operators
+CREATE INDEX IF NOT EXISTS thing_labels_idx ON thing USING GIN (labels);
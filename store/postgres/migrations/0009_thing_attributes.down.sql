@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS thing_attributes_idx;
+ALTER TABLE thing_revision DROP COLUMN IF EXISTS attributes;
+ALTER TABLE thing DROP COLUMN IF EXISTS attributes;
@@ -0,0 +1,5 @@
+ALTER TABLE thing ADD COLUMN IF NOT EXISTS attributes JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE thing_revision ADD COLUMN IF NOT EXISTS attributes JSONB NOT NULL DEFAULT '{}';
+
+-- Supports attribute filters, which are compiled to containment (@>) checks
+CREATE INDEX IF NOT EXISTS thing_attributes_idx ON thing USING GIN (attributes jsonb_path_ops);
@@ -0,0 +1,22 @@
+DROP POLICY IF EXISTS thing_revision_tenant ON thing_revision;
+ALTER TABLE thing_revision NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE thing_revision DISABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS thing_tenant ON thing;
+ALTER TABLE thing NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE thing DISABLE ROW LEVEL SECURITY;
+
+DROP INDEX IF EXISTS thing_tenant_name_id_idx;
+DROP INDEX IF EXISTS thing_tenant_create_time_id_idx;
+DROP INDEX IF EXISTS thing_tenant_update_time_id_idx;
+CREATE INDEX IF NOT EXISTS thing_name_id_idx ON thing (name, id);
+CREATE INDEX IF NOT EXISTS thing_create_time_id_idx ON thing (create_time, id);
+CREATE INDEX IF NOT EXISTS thing_update_time_id_idx ON thing (update_time, id);
+
+-- Fails if the same id is used by more than one tenant
+ALTER TABLE thing_revision DROP CONSTRAINT IF EXISTS thing_revision_pkey;
+ALTER TABLE thing_revision ADD PRIMARY KEY (thing_id, revision);
+ALTER TABLE thing DROP CONSTRAINT IF EXISTS thing_pkey;
+ALTER TABLE thing ADD PRIMARY KEY (id);
+
+ALTER TABLE thing_revision DROP COLUMN IF EXISTS tenant;
+ALTER TABLE thing DROP COLUMN IF EXISTS tenant;
@@ -0,0 +1,29 @@
+ALTER TABLE thing ADD COLUMN IF NOT EXISTS tenant TEXT NOT NULL DEFAULT '';
+ALTER TABLE thing_revision ADD COLUMN IF NOT EXISTS tenant TEXT NOT NULL DEFAULT '';
+
+-- Ids are unique within a tenant
+ALTER TABLE thing DROP CONSTRAINT IF EXISTS thing_pkey;
+ALTER TABLE thing ADD PRIMARY KEY (tenant, id);
+ALTER TABLE thing_revision DROP CONSTRAINT IF EXISTS thing_revision_pkey;
+ALTER TABLE thing_revision ADD PRIMARY KEY (tenant, thing_id, revision);
+
+DROP INDEX IF EXISTS thing_name_id_idx;
+DROP INDEX IF EXISTS thing_create_time_id_idx;
+DROP INDEX IF EXISTS thing_update_time_id_idx;
+CREATE INDEX IF NOT EXISTS thing_tenant_name_id_idx ON thing (tenant, name, id);
+CREATE INDEX IF NOT EXISTS thing_tenant_create_time_id_idx ON thing (tenant, create_time, id);
+CREATE INDEX IF NOT EXISTS thing_tenant_update_time_id_idx ON thing (tenant, update_time, id);
+
+-- Rows are only visible to transactions that set gogrpcapi.tenant to their tenant, * is every tenant (purging)
+-- Superusers and roles with BYPASSRLS are not restricted
+ALTER TABLE thing ENABLE ROW LEVEL SECURITY;
+ALTER TABLE thing FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS thing_tenant ON thing;
+CREATE POLICY thing_tenant ON thing
+USING (tenant = current_setting('gogrpcapi.tenant', true) OR current_setting('gogrpcapi.tenant', true) = '*');
+
+ALTER TABLE thing_revision ENABLE ROW LEVEL SECURITY;
+ALTER TABLE thing_revision FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS thing_revision_tenant ON thing_revision;
+CREATE POLICY thing_revision_tenant ON thing_revision
+USING (tenant = current_setting('gogrpcapi.tenant', true) OR current_setting('gogrpcapi.tenant', true) = '*');
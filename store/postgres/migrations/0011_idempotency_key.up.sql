@@ -0,0 +1,16 @@
+-- The result of a change made with an idempotency key, repeats of the change return it instead of changing again
+CREATE TABLE IF NOT EXISTS idempotency_key (
+  tenant TEXT NOT NULL DEFAULT '',
+  key TEXT NOT NULL,
+  request TEXT NOT NULL,
+  response TEXT NOT NULL DEFAULT '',
+  create_time TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (tenant, key)
+);
+CREATE INDEX IF NOT EXISTS idempotency_key_create_time_idx ON idempotency_key (create_time);
+
+ALTER TABLE idempotency_key ENABLE ROW LEVEL SECURITY;
+ALTER TABLE idempotency_key FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS idempotency_key_tenant ON idempotency_key;
+CREATE POLICY idempotency_key_tenant ON idempotency_key
+USING (tenant = current_setting('gogrpcapi.tenant', true) OR current_setting('gogrpcapi.tenant', true) = '*');
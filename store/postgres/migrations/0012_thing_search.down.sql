@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS thing_search_idx;
+DROP TRIGGER IF EXISTS thing_search_update ON thing;
+DROP FUNCTION IF EXISTS thing_search_update();
+ALTER TABLE thing DROP COLUMN IF EXISTS search;
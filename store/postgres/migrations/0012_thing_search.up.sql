@@ -0,0 +1,21 @@
+-- The words of the name of a thing for full-text search, kept up to date by a trigger. Names are nullable and
+-- to_tsvector of NULL is NULL, so a missing name has no words.
+ALTER TABLE thing ADD COLUMN IF NOT EXISTS search TSVECTOR NOT NULL DEFAULT ''::TSVECTOR;
+
+CREATE OR REPLACE FUNCTION thing_search_update() RETURNS TRIGGER AS $$
+BEGIN
+  NEW.search := to_tsvector('simple', COALESCE(NEW.name, ''));
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS thing_search_update ON thing;
+CREATE TRIGGER thing_search_update BEFORE INSERT OR UPDATE OF name ON thing
+FOR EACH ROW EXECUTE PROCEDURE thing_search_update();
+
+-- Index the existing things of every tenant
+SELECT set_config('gogrpcapi.tenant', '*', true);
+UPDATE thing SET search = to_tsvector('simple', COALESCE(name, ''));
+
+-- Supports the match (@@) operator
+CREATE INDEX IF NOT EXISTS thing_search_idx ON thing USING GIN (search);
@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS thing_revision_commit_seq ON thing_revision;
+DROP FUNCTION IF EXISTS thing_revision_commit_seq();
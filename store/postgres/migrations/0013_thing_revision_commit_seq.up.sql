@@ -0,0 +1,17 @@
+-- Number revisions in commit order so watchers resuming after a seq never skip a change. A seq taken when the
+-- revision is inserted can commit after a higher seq of another transaction that watchers have already passed.
+CREATE OR REPLACE FUNCTION thing_revision_commit_seq() RETURNS TRIGGER AS $$
+BEGIN
+  -- Held until the transaction ends, so the next transaction to commit revisions gets higher seqs
+  PERFORM pg_advisory_xact_lock('thing_revision'::REGCLASS::OID::BIGINT);
+  UPDATE thing_revision SET seq = nextval(pg_get_serial_sequence('thing_revision', 'seq'))
+  WHERE tenant = NEW.tenant AND thing_id = NEW.thing_id AND revision = NEW.revision;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Deferred so it runs as the transaction commits, after every other lock of the transaction is taken
+DROP TRIGGER IF EXISTS thing_revision_commit_seq ON thing_revision;
+CREATE CONSTRAINT TRIGGER thing_revision_commit_seq AFTER INSERT ON thing_revision
+DEFERRABLE INITIALLY DEFERRED
+FOR EACH ROW EXECUTE PROCEDURE thing_revision_commit_seq();
@@ -0,0 +1,9 @@
+CREATE OR REPLACE FUNCTION thing_revision_commit_seq() RETURNS TRIGGER AS $$
+BEGIN
+  -- Held until the transaction ends, so the next transaction to commit revisions gets higher seqs
+  PERFORM pg_advisory_xact_lock('thing_revision'::REGCLASS::OID::BIGINT);
+  UPDATE thing_revision SET seq = nextval(pg_get_serial_sequence('thing_revision', 'seq'))
+  WHERE tenant = NEW.tenant AND thing_id = NEW.thing_id AND revision = NEW.revision;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
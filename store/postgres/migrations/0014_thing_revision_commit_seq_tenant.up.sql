@@ -0,0 +1,12 @@
+-- Lock per tenant instead of across every tenant. Watchers only read the revisions of one tenant so seqs only need to
+-- follow the commit order within a tenant. Transactions of the same tenant that insert revisions still commit one at
+-- a time, which limits the write throughput of a single tenant. Tenants with the same hash share a lock.
+CREATE OR REPLACE FUNCTION thing_revision_commit_seq() RETURNS TRIGGER AS $$
+BEGIN
+  -- Held until the transaction ends, so the next transaction of the tenant to commit revisions gets higher seqs
+  PERFORM pg_advisory_xact_lock('thing_revision'::REGCLASS::OID::INT, hashtext(NEW.tenant));
+  UPDATE thing_revision SET seq = nextval(pg_get_serial_sequence('thing_revision', 'seq'))
+  WHERE tenant = NEW.tenant AND thing_id = NEW.thing_id AND revision = NEW.revision;
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
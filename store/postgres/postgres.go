@@ -1,21 +1,24 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres" // Import Database Migrate Postgres suppose
 	"github.com/golang-migrate/migrate/v4/source/go_bindata"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq" // Import Postgres Support
+	"github.com/lib/pq"
 	"github.com/rs/xid"
 	config "github.com/spf13/viper"
 	"go.uber.org/zap"
 
 	"github.com/snowzach/gogrpcapi/conf"
+	"github.com/snowzach/gogrpcapi/store"
 	"github.com/snowzach/gogrpcapi/store/postgres/migrations"
 )
 
@@ -25,6 +28,13 @@ type Client struct {
 	dbName string
 	db     *sqlx.DB
 	newID  func() string
+
+	// How long idempotency keys are remembered
+	idempotencyWindow time.Duration
+
+	// ThingWatch calls waiting for changes
+	watchers     map[chan struct{}]struct{}
+	watchersLock sync.Mutex
 }
 
 // New returns a new database client
@@ -107,7 +117,7 @@ func New() (*Client, error) {
 			continue
 		}
 		logger.Infow("Creating database", "database", dbName)
-		_, err = createDb.Exec(`CREATE DATABASE ` + dbName)
+		_, err = createDb.Exec(`CREATE DATABASE ` + dbName + ` OWNER ` + pq.QuoteIdentifier(config.GetString("storage.username")))
 		if err != nil {
 			return nil, fmt.Errorf("Could not create database: %s", err)
 		}
@@ -138,6 +148,16 @@ func New() (*Client, error) {
 		return nil, fmt.Errorf("Could not ping database %s", err)
 	}
 
+	// Row level security isolates tenants, it does not apply to superusers or roles that bypass it
+	var bypassRLS bool
+	if err = db.Get(&bypassRLS, `SELECT rolsuper OR rolbypassrls FROM pg_roles WHERE rolname = current_user`); err != nil {
+		return nil, fmt.Errorf("Could not check database user: %s", err)
+	} else if bypassRLS && !config.GetBool("storage.allow_bypass_rls") {
+		return nil, fmt.Errorf("Database user %s is a superuser or bypasses row level security, use an ordinary user so tenants are isolated or set storage.allow_bypass_rls for development", config.GetString("storage.username"))
+	} else if bypassRLS {
+		logger.Warnw("WARNING: Database user bypasses row level security, tenants are not isolated by the database. This is for development only!!!", "storage.username", config.GetString("storage.username"))
+	}
+
 	db.SetMaxOpenConns(config.GetInt("storage.max_connections"))
 
 	logger.Debugw("Connected to database server",
@@ -154,6 +174,8 @@ func New() (*Client, error) {
 		newID: func() string {
 			return xid.New().String()
 		},
+		idempotencyWindow: config.GetDuration("storage.idempotency_window"),
+		watchers:          make(map[chan struct{}]struct{}),
 	}
 
 	// wrap assets into Resource
@@ -201,6 +223,112 @@ func New() (*Client, error) {
 		logger.Info("Database migration completed")
 	}
 
+	// Optionally require the names of things to be unique within a tenant
+	if err = c.thingNameUnique(config.GetBool("storage.unique_names")); err != nil {
+		return nil, fmt.Errorf("Could not configure unique names: %s", err)
+	}
+
+	// Permanently remove deleted things after the retention period and expired idempotency keys
+	if purgeAfter := config.GetDuration("storage.purge_after"); purgeAfter > 0 || c.idempotencyWindow > 0 {
+		conf.Stop.Add(1)
+		go c.purger(purgeAfter, config.GetDuration("storage.purge_interval"))
+	}
+
+	// Wake up watchers when things change
+	conf.Stop.Add(1)
+	go c.thingEventListener(fullDbURL)
+
 	return c, nil
 
 }
+
+// allTenants is the tenant that can see the things of every tenant, it is not a valid tenant identifier
+const allTenants = "*"
+
+// withTx runs f in a transaction, it is committed if f returns nil and rolled back otherwise
+// The row level security policies only allow the transaction to see the things of the tenant of the context.
+func (c *Client) withTx(ctx context.Context, f func(tx *sqlx.Tx) error) error {
+
+	tx, err := c.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, `SELECT set_config('gogrpcapi.tenant', $1, true)`, store.Tenant(ctx)); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = f(tx); err != nil {
+		tx.Rollback()
+		return storeError(err)
+	}
+
+	return storeError(tx.Commit())
+
+}
+
+// thingNameUniqueIndex makes the names of things that are not deleted unique within a tenant
+const thingNameUniqueIndex = "thing_tenant_name_key"
+
+// uniqueFields are the fields of the unique constraints and indexes of things
+var uniqueFields = map[string]string{
+	"thing_pkey":         "id",
+	thingNameUniqueIndex: "name",
+}
+
+// storeError translates a unique violation (SQLSTATE 23505) to ErrAlreadyExists with the field that conflicts
+func storeError(err error) error {
+
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return &store.FieldError{Err: store.ErrAlreadyExists, Field: uniqueFields[pqErr.Constraint]}
+	}
+	return err
+
+}
+
+// thingNameUnique adds or removes the unique index on the names of things when it does not match the setting
+// It is not a migration as it is optional. Adding it fails with the duplicated names if there are any.
+func (c *Client) thingNameUnique(unique bool) error {
+
+	var exists bool
+	if err := c.db.Get(&exists, `SELECT EXISTS (SELECT 1 FROM pg_indexes WHERE schemaname = current_schema() AND indexname = $1)`, thingNameUniqueIndex); err != nil {
+		return err
+	}
+	if !unique {
+		if exists {
+			_, err := c.db.Exec(`DROP INDEX IF EXISTS ` + thingNameUniqueIndex)
+			return err
+		}
+		return nil
+	} else if exists {
+		return nil
+	}
+
+	// Duplicates in every tenant, the index can not be created until they are renamed or deleted
+	var duplicates []struct {
+		Tenant string `db:"tenant"`
+		Name   string `db:"name"`
+		Count  int64  `db:"count"`
+	}
+	err := c.withTx(store.WithTenant(context.Background(), allTenants), func(tx *sqlx.Tx) error {
+		return tx.Select(&duplicates, `
+			SELECT tenant, name, COUNT(*) AS count FROM thing WHERE delete_time IS NULL AND name IS NOT NULL
+			GROUP BY tenant, name HAVING COUNT(*) > 1 ORDER BY tenant, name LIMIT 20
+		`)
+	})
+	if err != nil {
+		return err
+	}
+	if len(duplicates) > 0 {
+		names := make([]string, len(duplicates))
+		for i, d := range duplicates {
+			names[i] = fmt.Sprintf("%q in tenant %q (%d things)", d.Name, d.Tenant, d.Count)
+		}
+		return fmt.Errorf("names are duplicated, rename or delete the things first: %s", strings.Join(names, ", "))
+	}
+
+	_, err = c.db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS ` + thingNameUniqueIndex + ` ON thing (tenant, name) WHERE delete_time IS NULL`)
+	return err
+
+}
@@ -0,0 +1,204 @@
+package postgres
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/xid"
+	config "github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/snowzach/gogrpcapi/store"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+// testClient connects to the postgres server in POSTGRES_TEST_HOST as the ordinary user POSTGRES_TEST_USERNAME with
+// POSTGRES_TEST_PASSWORD, the test is skipped if the host is not set
+// Each test uses its own tenant so they do not see each other's things.
+func testClient(t *testing.T) (*Client, context.Context) {
+
+	host := os.Getenv("POSTGRES_TEST_HOST")
+	if host == "" {
+		t.Skip("POSTGRES_TEST_HOST is not set")
+	}
+	config.Set("storage.host", host)
+	config.Set("storage.username", os.Getenv("POSTGRES_TEST_USERNAME"))
+	config.Set("storage.password", os.Getenv("POSTGRES_TEST_PASSWORD"))
+	config.Set("storage.database", "gogrpcapi_test")
+	config.Set("storage.purge_after", 0)
+	config.Set("storage.idempotency_window", 0)
+
+	c, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, store.WithTenant(context.Background(), xid.New().String())
+
+}
+
+// testTx begins a transaction for the tenant of the context that the test commits
+func testTx(t *testing.T, c *Client, ctx context.Context) *sqlx.Tx {
+
+	tx, err := c.db.BeginTxx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.ExecContext(ctx, `SELECT set_config('gogrpcapi.tenant', $1, true)`, store.Tenant(ctx)); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+
+}
+
+func TestThingWatchCommitOrder(t *testing.T) {
+
+	c, ctx := testClient(t)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	_, err := c.ThingSave(ctx, &thingrpc.Thing{Id: "id0", Name: "name0"})
+	assert.Nil(t, err)
+	var after int64
+	assert.Nil(t, c.withTx(ctx, func(tx *sqlx.Tx) error {
+		return tx.GetContext(ctx, &after, `SELECT MAX(seq) FROM thing_revision WHERE tenant = $1`, store.Tenant(ctx))
+	}))
+
+	// The first transaction to change a thing commits last
+	tx1 := testTx(t, c, ctx)
+	defer tx1.Rollback()
+	assert.Nil(t, c.thingSave(ctx, tx1, &thingrpc.Thing{Id: "id1", Name: "name1"}))
+	tx2 := testTx(t, c, ctx)
+	assert.Nil(t, c.thingSave(ctx, tx2, &thingrpc.Thing{Id: "id2", Name: "name2"}))
+	assert.Nil(t, tx2.Commit())
+
+	ids := make(chan string, 2)
+	go c.ThingWatch(ctx, after, func(seq int64, event *thingrpc.ThingEvent) error {
+		ids <- event.Thing.Id
+		return nil
+	})
+	receive := func() string {
+		select {
+		case id := <-ids:
+			return id
+		case <-time.After(10 * time.Second):
+			return ""
+		}
+	}
+
+	// The watcher has passed the second change before the first commits and still gets the first
+	assert.Equal(t, "id2", receive())
+	assert.Nil(t, tx1.Commit())
+	assert.Equal(t, "id1", receive())
+
+}
+
+func TestThingMissingErrorSingleConnection(t *testing.T) {
+
+	c, ctx := testClient(t)
+	// Checking why a change failed must not need a second connection
+	c.db.SetMaxOpenConns(1)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	id, err := c.ThingSave(ctx, &thingrpc.Thing{Name: "name1"})
+	assert.Nil(t, err)
+
+	_, err = c.ThingUpdate(ctx, &thingrpc.Thing{Id: id, Name: "name2", Etag: "5"}, []string{"name"})
+	assert.Equal(t, store.ErrEtagMismatch, err)
+	_, err = c.ThingUpdate(ctx, &thingrpc.Thing{Id: "missing", Name: "name2", Etag: "1"}, []string{"name"})
+	assert.Equal(t, store.ErrNotFound, err)
+	assert.Equal(t, store.ErrEtagMismatch, c.ThingDeleteById(ctx, id, "5"))
+	assert.Nil(t, c.ThingDeleteById(ctx, id, ""))
+	_, err = c.ThingUndeleteById(ctx, id, "5")
+	assert.Equal(t, store.ErrEtagMismatch, err)
+
+}
+
+func TestThingPurgeRevisions(t *testing.T) {
+
+	c, ctx := testClient(t)
+
+	id, err := c.ThingSave(ctx, &thingrpc.Thing{Name: "name1"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.ThingDeleteById(ctx, id, ""))
+
+	// The revisions are purged with the thing
+	purged, err := c.ThingPurge(ctx, time.Now().Add(time.Minute))
+	assert.Nil(t, err)
+	assert.True(t, purged >= 1)
+	revisions, err := c.ThingListRevisions(ctx, id, 0, 10)
+	assert.Nil(t, err)
+	assert.Empty(t, revisions)
+
+}
+
+func TestThingUndeleteNotDeleted(t *testing.T) {
+
+	c, ctx := testClient(t)
+
+	id, err := c.ThingSave(ctx, &thingrpc.Thing{Name: "name1"})
+	assert.Nil(t, err)
+
+	// A thing that is not deleted is returned unchanged without a revision
+	b, err := c.ThingUndeleteById(ctx, id, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "1", b.Etag)
+	revisions, err := c.ThingListRevisions(ctx, id, 0, 10)
+	assert.Nil(t, err)
+	assert.Len(t, revisions, 1)
+
+	_, err = c.ThingUndeleteById(ctx, id, "5")
+	assert.Equal(t, store.ErrEtagMismatch, err)
+
+}
+
+func TestThingGetByIdFields(t *testing.T) {
+
+	c, ctx := testClient(t)
+
+	id, err := c.ThingSave(ctx, &thingrpc.Thing{Name: "name1", Labels: map[string]string{"env": "prod"}})
+	assert.Nil(t, err)
+
+	// Only the columns of the fields and the id are selected
+	b, err := c.ThingGetById(store.WithFields(ctx, []string{"name"}), id, false)
+	assert.Nil(t, err)
+	assert.Equal(t, id, b.Id)
+	assert.Equal(t, "name1", b.Name)
+	assert.Len(t, b.Labels, 0)
+
+}
+
+func TestThingNameUnique(t *testing.T) {
+
+	c, ctx := testClient(t)
+	defer c.thingNameUnique(false)
+
+	// Off allows duplicates
+	assert.Nil(t, c.thingNameUnique(false))
+	_, err := c.ThingSave(ctx, &thingrpc.Thing{Id: "id1", Name: "name1"})
+	assert.Nil(t, err)
+	_, err = c.ThingSave(ctx, &thingrpc.Thing{Id: "id2", Name: "name1"})
+	assert.Nil(t, err)
+
+	// The index can not be added while names are duplicated, the error names them
+	err = c.thingNameUnique(true)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), `"name1" in tenant "`+store.Tenant(ctx)+`" (2 things)`)
+	}
+
+	// On rejects duplicates, deleted things do not count
+	assert.Nil(t, c.ThingDeleteById(ctx, "id2", ""))
+	assert.Nil(t, c.thingNameUnique(true))
+	assert.Nil(t, c.thingNameUnique(true))
+	_, err = c.ThingSave(ctx, &thingrpc.Thing{Id: "id3", Name: "name1"})
+	assert.Equal(t, &store.FieldError{Err: store.ErrAlreadyExists, Field: "name"}, err)
+
+	// Off again
+	assert.Nil(t, c.thingNameUnique(false))
+	_, err = c.ThingSave(ctx, &thingrpc.Thing{Id: "id3", Name: "name1"})
+	assert.Nil(t, err)
+
+}
@@ -0,0 +1,33 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/snowzach/gogrpcapi/store"
+)
+
+// purger periodically purges things that were deleted longer than purgeAfter ago and idempotency keys older than the
+// idempotency window until the program stops
+func (c *Client) purger(purgeAfter time.Duration, interval time.Duration) {
+
+	store.Purger(interval, func() {
+		if purgeAfter > 0 {
+			purged, err := c.ThingPurge(context.Background(), time.Now().Add(-purgeAfter))
+			if err != nil {
+				c.logger.Errorw("Could not purge deleted things", "error", err)
+			} else if purged > 0 {
+				c.logger.Infow("Purged deleted things", "count", purged)
+			}
+		}
+		if c.idempotencyWindow > 0 {
+			purged, err := c.IdempotencyKeyPurge(context.Background(), time.Now().Add(-c.idempotencyWindow))
+			if err != nil {
+				c.logger.Errorw("Could not purge idempotency keys", "error", err)
+			} else if purged > 0 {
+				c.logger.Debugw("Purged idempotency keys", "count", purged)
+			}
+		}
+	})
+
+}
@@ -0,0 +1,88 @@
+package postgres
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"github.com/snowzach/gogrpcapi/store/query"
+	"github.com/snowzach/gogrpcapi/store/sqlquery"
+)
+
+// dialect builds postgres SQL, JSONB columns can be filtered
+var dialect = &sqlquery.Dialect{
+	Placeholder: "$%d",
+	JSONCompare: jsonCompare,
+}
+
+// queryBuilder builds the clauses of a postgres select from a query
+type queryBuilder struct {
+	*sqlquery.Builder
+}
+
+func newQueryBuilder() *queryBuilder {
+	return &queryBuilder{sqlquery.New(dialect)}
+}
+
+// labels adds the label selector requirements to the where clause
+// Equality uses JSONB containment and existence checks so the GIN index on labels can be used.
+func (qb *queryBuilder) labels(s query.Selector) {
+
+	for _, r := range s {
+		var sql string
+		switch r.Op {
+		case query.SelectorEquals, query.SelectorNotEquals, query.SelectorIn, query.SelectorNotIn:
+			ors := make([]string, len(r.Values))
+			for i, v := range r.Values {
+				b, _ := json.Marshal(map[string]string{r.Key: v})
+				ors[i] = "labels @> " + qb.Arg(string(b)) + "::JSONB"
+			}
+			sql = "(" + strings.Join(ors, " OR ") + ")"
+			if r.Op == query.SelectorNotEquals || r.Op == query.SelectorNotIn {
+				sql = "NOT " + sql
+			}
+		case query.SelectorExists:
+			sql = "(labels ? " + qb.Arg(r.Key) + ")"
+		case query.SelectorDoesNotExist:
+			sql = "NOT (labels ? " + qb.Arg(r.Key) + ")"
+		}
+		qb.Where = append(qb.Where, sql)
+	}
+
+}
+
+// jsonCompare compiles a comparison of the value at a path below a JSONB column, a missing value is null.
+// Containment (@>) is checked where possible so the GIN index on the column can be used.
+func jsonCompare(qb *sqlquery.Builder, path []string, op query.Op, value interface{}) (string, error) {
+
+	// Builds {"a":{"b":v}} for the path column.a.b
+	doc := func(v interface{}) string {
+		for i := len(path) - 1; i > 0; i-- {
+			v = map[string]interface{}{path[i]: v}
+		}
+		b, _ := json.Marshal(v)
+		return qb.Arg(string(b)) + "::JSONB"
+	}
+	column := path[0]
+	b, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	pathValue := "COALESCE(" + column + " #> " + qb.Arg(pq.Array(path[1:])) + ", 'null'::JSONB)"
+
+	switch op {
+	case query.OpEq:
+		if value == nil {
+			return "(" + pathValue + " = 'null'::JSONB)", nil
+		}
+		return "(" + column + " @> " + doc(value) + " AND " + pathValue + " = " + qb.Arg(string(b)) + "::JSONB)", nil
+	case query.OpNe:
+		return "(" + pathValue + " != " + qb.Arg(string(b)) + "::JSONB)", nil
+	case query.OpHas:
+		return "(" + column + " @> " + doc(value) + " OR " + column + " @> " + doc([]interface{}{value}) + ")", nil
+	}
+	return "", fmt.Errorf("unsupported operator %s for %s", op, strings.Join(path, "."))
+
+}
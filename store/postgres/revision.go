@@ -0,0 +1,103 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/snowzach/gogrpcapi/store"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+// Actions recorded in the thing revision history
+const (
+	thingActionCreate   = "create"
+	thingActionUpdate   = "update"
+	thingActionDelete   = "delete"
+	thingActionUndelete = "undelete"
+)
+
+// thingRevisionColumns are the columns selected for a thing revision, the thing columns match thingRow
+const thingRevisionColumns = `thing_id AS id, name, labels, attributes, revision::TEXT AS etag, create_time, update_time, delete_time, action, actor, revision_time`
+
+// thingRevisionRow is a thing revision as stored in the database
+type thingRevisionRow struct {
+	thingRow
+	Action       string    `db:"action"`
+	Actor        string    `db:"actor"`
+	RevisionTime time.Time `db:"revision_time"`
+}
+
+// thingRevision converts the row to a thing revision
+func (r *thingRevisionRow) thingRevision() *thingrpc.ThingRevision {
+	revisionTime, _ := ptypes.TimestampProto(r.RevisionTime)
+	return &thingrpc.ThingRevision{
+		Revision:     r.Etag,
+		Action:       r.Action,
+		Actor:        r.Actor,
+		RevisionTime: revisionTime,
+		Thing:        r.thing(),
+	}
+}
+
+// thingRevision records the current state of a thing in the revision history as part of the transaction making the change
+func (c *Client) thingRevision(ctx context.Context, tx *sqlx.Tx, id string, action string) error {
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO thing_revision (tenant, thing_id, revision, action, actor, revision_time, name, labels, attributes, create_time, update_time, delete_time)
+		SELECT tenant, id, version, $3, $4, NOW(), name, labels, attributes, create_time, update_time, delete_time
+		FROM thing WHERE tenant = $1 AND id = $2
+	`, store.Tenant(ctx), id, action, store.Actor(ctx))
+	return err
+
+}
+
+// ThingGetAsOf returns the thing as it was at a point in time from the revision history
+// Things that were deleted at that time are only returned if showDeleted is true
+func (c *Client) ThingGetAsOf(ctx context.Context, id string, asOf time.Time, showDeleted bool) (*thingrpc.Thing, error) {
+
+	var r thingRevisionRow
+	err := c.withTx(ctx, func(tx *sqlx.Tx) error {
+		return tx.GetContext(ctx, &r, `
+			SELECT `+thingRevisionColumns+` FROM thing_revision
+			WHERE tenant = $1 AND thing_id = $2 AND revision_time <= $3
+			ORDER BY revision DESC LIMIT 1
+		`, store.Tenant(ctx), id, asOf)
+	})
+	if err == sql.ErrNoRows {
+		return nil, store.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if r.DeleteTime != nil && !showDeleted {
+		return nil, store.ErrNotFound
+	}
+	return r.thing(), nil
+
+}
+
+// ThingListRevisions returns up to limit revisions of a thing, newest first, older than the before revision (0 = latest)
+func (c *Client) ThingListRevisions(ctx context.Context, id string, before int64, limit int) ([]*thingrpc.ThingRevision, error) {
+
+	var rs []*thingRevisionRow
+	err := c.withTx(ctx, func(tx *sqlx.Tx) error {
+		return tx.SelectContext(ctx, &rs, `
+			SELECT `+thingRevisionColumns+` FROM thing_revision
+			WHERE tenant = $1 AND thing_id = $2 AND ($3 = 0 OR revision < $3)
+			ORDER BY revision DESC LIMIT $4
+		`, store.Tenant(ctx), id, before, limit)
+	})
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	var revisions = make([]*thingrpc.ThingRevision, len(rs))
+	for i, r := range rs {
+		revisions[i] = r.thingRevision()
+	}
+	return revisions, nil
+
+}
@@ -3,67 +3,583 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/ptypes"
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 
 	"github.com/snowzach/gogrpcapi/store"
+	"github.com/snowzach/gogrpcapi/store/query"
 	"github.com/snowzach/gogrpcapi/thingrpc"
 )
 
+// thingColumns are the columns selected for a thing, the etag is the row version
+const thingColumns = `id, name, labels, attributes, version::TEXT AS etag, create_time, update_time, delete_time`
+
+// thingFieldColumns are the columns selected for each field of a thing in the order of thingColumns
+var thingFieldColumns = []struct {
+	field  string
+	column string
+}{
+	{"id", "id"},
+	{"name", "name"},
+	{"labels", "labels"},
+	{"attributes", "attributes"},
+	{"etag", "version::TEXT AS etag"},
+	{"create_time", "create_time"},
+	{"update_time", "update_time"},
+	{"delete_time", "delete_time"},
+}
+
+// thingSelectColumns returns the columns selected for the fields and order by fields of a query, the key is always
+// selected. All columns are selected if the query does not limit the fields.
+func thingSelectColumns(q *query.Query) string {
+
+	if q.Fields == nil {
+		return thingColumns
+	}
+
+	needed := map[string]struct{}{"id": struct{}{}}
+	for _, field := range q.Fields {
+		needed[field] = struct{}{}
+	}
+	for _, o := range q.OrderBy {
+		needed[o.Field] = struct{}{}
+	}
+	var columns []string
+	for _, fc := range thingFieldColumns {
+		if _, ok := needed[fc.field]; ok {
+			columns = append(columns, fc.column)
+		}
+	}
+	return strings.Join(columns, ", ")
+
+}
+
+// thingRow is a thing as stored in the database
+type thingRow struct {
+	ID         string     `db:"id"`
+	Name       string     `db:"name"`
+	Labels     labels     `db:"labels"`
+	Attributes attributes `db:"attributes"`
+	Etag       string     `db:"etag"`
+	CreateTime time.Time  `db:"create_time"`
+	UpdateTime time.Time  `db:"update_time"`
+	DeleteTime *time.Time `db:"delete_time"`
+}
+
+// thing converts the row to a thing
+func (r *thingRow) thing() *thingrpc.Thing {
+	t := &thingrpc.Thing{
+		Id:   r.ID,
+		Name: r.Name,
+		Etag: r.Etag,
+	}
+	// Times are zero if their column was not selected
+	if !r.CreateTime.IsZero() {
+		t.CreateTime, _ = ptypes.TimestampProto(r.CreateTime)
+	}
+	if !r.UpdateTime.IsZero() {
+		t.UpdateTime, _ = ptypes.TimestampProto(r.UpdateTime)
+	}
+	if r.DeleteTime != nil {
+		t.DeleteTime, _ = ptypes.TimestampProto(*r.DeleteTime)
+	}
+	if len(r.Labels) > 0 {
+		t.Labels = r.Labels
+	}
+	if len(r.Attributes.GetFields()) > 0 {
+		t.Attributes = r.Attributes.Struct
+	}
+	return t
+}
+
+// labels are the labels of a thing stored as a JSONB object
+type labels map[string]string
+
+// Value implements driver.Valuer
+func (l labels) Value() (driver.Value, error) {
+	if l == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(l)
+	return string(b), err
+}
+
+// Scan implements sql.Scanner
+func (l *labels) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case []byte:
+		return json.Unmarshal(src, l)
+	case string:
+		return json.Unmarshal([]byte(src), l)
+	case nil:
+		*l = nil
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into labels", src)
+}
+
+// attributes are the attributes of a thing stored as a JSONB object
+type attributes struct {
+	*structpb.Struct
+}
+
+// Value implements driver.Valuer
+func (a attributes) Value() (driver.Value, error) {
+	if a.Struct == nil {
+		return "{}", nil
+	}
+	return new(jsonpb.Marshaler).MarshalToString(a.Struct)
+}
+
+// Scan implements sql.Scanner
+func (a *attributes) Scan(src interface{}) error {
+	a.Struct = new(structpb.Struct)
+	switch src := src.(type) {
+	case []byte:
+		return jsonpb.UnmarshalString(string(src), a.Struct)
+	case string:
+		return jsonpb.UnmarshalString(src, a.Struct)
+	case nil:
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into attributes", src)
+}
+
 // ThingGetByID returns the the thing by ID
-func (c *Client) ThingGetById(ctx context.Context, id string) (*thingrpc.Thing, error) {
+// Deleted things are only returned if showDeleted is true. Only the columns of the fields of the context are selected.
+func (c *Client) ThingGetById(ctx context.Context, id string, showDeleted bool) (*thingrpc.Thing, error) {
 
-	b := new(thingrpc.Thing)
-	err := c.db.GetContext(ctx, b, `SELECT * FROM thing WHERE id = $1`, id)
+	var t *thingrpc.Thing
+	err := c.withTx(ctx, func(tx *sqlx.Tx) error {
+		var err error
+		t, err = c.thingGet(ctx, tx, id, showDeleted)
+		return err
+	})
+	return t, err
+
+}
+
+// thingGet returns the thing by ID as part of a transaction, only the fields of the context are selected
+func (c *Client) thingGet(ctx context.Context, tx *sqlx.Tx, id string, showDeleted bool) (*thingrpc.Thing, error) {
+
+	var r thingRow
+	err := tx.GetContext(ctx, &r, `SELECT `+thingSelectColumns(&query.Query{Fields: store.Fields(ctx)})+` FROM thing WHERE tenant = $1 AND id = $2 AND ($3 OR delete_time IS NULL)`, store.Tenant(ctx), id, showDeleted)
 	if err == sql.ErrNoRows {
 		return nil, store.ErrNotFound
 	} else if err != nil {
 		return nil, err
 	}
-	return b, nil
+	return r.thing(), nil
 
 }
 
 // ThingSave saves the thing
+// If the thing has an etag it must match the stored thing or ErrEtagMismatch is returned. Deleted things are not
+// changed, ErrNotFound is returned instead. A repeated idempotency key returns the id of the first save.
 func (c *Client) ThingSave(ctx context.Context, i *thingrpc.Thing) (string, error) {
 
+	request, err := thingIdempotencyRequest("ThingSave", i)
+	if err != nil {
+		return "", err
+	}
+
+	var id string
+	err = c.withTx(ctx, func(tx *sqlx.Tx) error {
+		var err error
+		id, err = c.idempotent(ctx, tx, request, func() (string, error) {
+			err := c.thingSave(ctx, tx, i)
+			return i.Id, err
+		})
+		return err
+	})
+	return id, err
+
+}
+
+// ThingCreate saves a new thing
+// ErrAlreadyExists is returned if a thing with the id exists, including deleted things that have not been purged.
+// A repeated idempotency key returns the id of the first create.
+func (c *Client) ThingCreate(ctx context.Context, i *thingrpc.Thing) (string, error) {
+
+	request, err := thingIdempotencyRequest("ThingCreate", i)
+	if err != nil {
+		return "", err
+	}
+
+	var id string
+	err = c.withTx(ctx, func(tx *sqlx.Tx) error {
+		var err error
+		id, err = c.idempotent(ctx, tx, request, func() (string, error) {
+			// Generate an ID if needed
+			if i.Id == "" {
+				i.Id = c.newID()
+			}
+			result, err := tx.ExecContext(ctx, `
+				INSERT INTO thing (tenant, id, name, labels, attributes)
+				VALUES($1, $2, $3, $4, $5)
+				ON CONFLICT (tenant, id) DO NOTHING
+			`, store.Tenant(ctx), i.Id, i.Name, labels(i.Labels), attributes{i.Attributes})
+			if err != nil {
+				return "", err
+			}
+			if rows, err := result.RowsAffected(); err != nil {
+				return "", err
+			} else if rows == 0 {
+				return "", &store.FieldError{Err: store.ErrAlreadyExists, Field: "id"}
+			}
+			return i.Id, c.thingRevision(ctx, tx, i.Id, thingActionCreate)
+		})
+		return err
+	})
+	return id, err
+
+}
+
+// thingSave saves the thing as part of a transaction
+func (c *Client) thingSave(ctx context.Context, tx *sqlx.Tx, i *thingrpc.Thing) error {
+
 	// Generate an ID if needed
 	if i.Id == "" {
 		i.Id = c.newID()
 	}
 
-	_, err := c.db.ExecContext(ctx, `
-		INSERT INTO thing (id, name)
-		VALUES($1, $2)
-		ON CONFLICT (id) DO UPDATE
-		SET name = $2
-	`, i.Id, i.Name)
+	var version int64
+	var err error
+	if i.Etag != "" {
+		// Only update the existing thing if the etag matches, any etag matches store.EtagAny
+		err = tx.GetContext(ctx, &version, `
+			UPDATE thing SET name = $3, labels = $4, attributes = $5, version = version + 1, update_time = NOW()
+			WHERE tenant = $1 AND id = $2 AND ($6 = '*' OR version::TEXT = $6) AND delete_time IS NULL
+			RETURNING version
+		`, store.Tenant(ctx), i.Id, i.Name, labels(i.Labels), attributes{i.Attributes}, i.Etag)
+		if err == sql.ErrNoRows {
+			return c.thingMissingError(ctx, tx, i.Id, i.Etag)
+		}
+	} else {
+		// Deleted things are not changed
+		err = tx.GetContext(ctx, &version, `
+			INSERT INTO thing (tenant, id, name, labels, attributes)
+			VALUES($1, $2, $3, $4, $5)
+			ON CONFLICT (tenant, id) DO UPDATE
+			SET name = $3, labels = $4, attributes = $5, version = thing.version + 1, update_time = NOW()
+			WHERE thing.delete_time IS NULL
+			RETURNING version
+		`, store.Tenant(ctx), i.Id, i.Name, labels(i.Labels), attributes{i.Attributes})
+		if err == sql.ErrNoRows {
+			return store.ErrNotFound
+		}
+	}
 	if err != nil {
-		return i.Id, err
+		return err
+	}
+
+	if version == 1 {
+		return c.thingRevision(ctx, tx, i.Id, thingActionCreate)
 	}
-	return i.Id, nil
+	return c.thingRevision(ctx, tx, i.Id, thingActionUpdate)
 
 }
 
-// ThingDeleteById a thing
-func (c *Client) ThingDeleteById(ctx context.Context, id string) error {
+// ThingUpdate updates only the given fields of the thing and returns the result
+// If the thing has an etag it must match the stored thing or ErrEtagMismatch is returned
+func (c *Client) ThingUpdate(ctx context.Context, i *thingrpc.Thing, paths []string) (*thingrpc.Thing, error) {
+
+	qb := newQueryBuilder()
+	where := "tenant = " + qb.Arg(store.Tenant(ctx)) + " AND id = " + qb.Arg(i.Id) + " AND delete_time IS NULL"
+	if i.Etag != "" && i.Etag != store.EtagAny {
+		where += " AND version::TEXT = " + qb.Arg(i.Etag)
+	}
+
+	set := []string{"version = version + 1", "update_time = NOW()"}
+	for _, path := range paths {
+		switch path {
+		case "name":
+			set = append(set, "name = "+qb.Arg(i.Name))
+		case "labels":
+			set = append(set, "labels = "+qb.Arg(labels(i.Labels)))
+		case "attributes":
+			set = append(set, "attributes = "+qb.Arg(attributes{i.Attributes}))
+		default:
+			return nil, fmt.Errorf("unknown update path: %s", path)
+		}
+	}
 
-	_, err := c.db.ExecContext(ctx, `DELETE FROM thing WHERE id = $1`, id)
+	var r thingRow
+	err := c.withTx(ctx, func(tx *sqlx.Tx) error {
+		err := tx.GetContext(ctx, &r, `UPDATE thing SET `+strings.Join(set, ", ")+` WHERE `+where+` RETURNING `+thingColumns, qb.Args...)
+		if err == sql.ErrNoRows {
+			return c.thingMissingError(ctx, tx, i.Id, i.Etag)
+		} else if err != nil {
+			return err
+		}
+		return c.thingRevision(ctx, tx, i.Id, thingActionUpdate)
+	})
 	if err != nil {
+		return nil, err
+	}
+	return r.thing(), nil
+
+}
+
+// ThingDeleteById marks a thing as deleted, it is removed by ThingPurge after the retention period
+// ErrNotFound is returned if the thing does not exist or is already deleted.
+// If etag is not empty it must match the stored thing or ErrEtagMismatch is returned
+func (c *Client) ThingDeleteById(ctx context.Context, id string, etag string) error {
+
+	return c.withTx(ctx, func(tx *sqlx.Tx) error {
+		_, err := c.idempotent(ctx, tx, idempotencyRequest("ThingDelete", id, etag), func() (string, error) {
+			return "", c.thingDelete(ctx, tx, id, etag)
+		})
+		return err
+	})
+
+}
+
+// thingDelete marks a thing as deleted as part of a transaction
+func (c *Client) thingDelete(ctx context.Context, tx *sqlx.Tx, id string, etag string) error {
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE thing SET delete_time = NOW(), version = version + 1
+		WHERE tenant = $1 AND id = $2 AND ($3 IN ('', '*') OR version::TEXT = $3) AND delete_time IS NULL
+	`, store.Tenant(ctx), id, etag)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return c.thingMissingError(ctx, tx, id, etag)
+	}
+	return c.thingRevision(ctx, tx, id, thingActionDelete)
+
+}
+
+// ThingUndeleteById restores a deleted thing, things that are not deleted are returned unchanged
+// If etag is not empty it must match the stored thing or ErrEtagMismatch is returned
+func (c *Client) ThingUndeleteById(ctx context.Context, id string, etag string) (*thingrpc.Thing, error) {
+
+	var t *thingrpc.Thing
+	err := c.withTx(ctx, func(tx *sqlx.Tx) error {
+		var r thingRow
+		err := tx.GetContext(ctx, &r, `
+			UPDATE thing SET delete_time = NULL, version = version + 1
+			WHERE tenant = $1 AND id = $2 AND ($3 IN ('', '*') OR version::TEXT = $3) AND delete_time IS NOT NULL
+			RETURNING `+thingColumns, store.Tenant(ctx), id, etag)
+		if err == sql.ErrNoRows {
+			// The thing is missing, does not match the etag or is not deleted
+			if t, err = c.thingGet(ctx, tx, id, true); err != nil {
+				return err
+			} else if !store.EtagMatches(etag, t.Etag) {
+				return store.ErrEtagMismatch
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+		t = r.thing()
+		return c.thingRevision(ctx, tx, id, thingActionUndelete)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+
+}
+
+// thingMissingError determines why a change to a thing in the transaction did not affect any rows
+func (c *Client) thingMissingError(ctx context.Context, tx *sqlx.Tx, id string, etag string) error {
+
+	if etag == "" || etag == store.EtagAny {
+		return store.ErrNotFound
+	}
+
+	if _, err := c.thingGet(ctx, tx, id, false); err != nil {
 		return err
 	}
-	return nil
+	return store.ErrEtagMismatch
+
+}
+
+// ThingPurge permanently removes things of every tenant deleted before the given time with their revisions
+func (c *Client) ThingPurge(ctx context.Context, before time.Time) (int64, error) {
+
+	var purged int64
+	err := c.withTx(store.WithTenant(ctx, allTenants), func(tx *sqlx.Tx) error {
+		// One statement so a thing undeleted concurrently keeps its revisions
+		return tx.GetContext(ctx, &purged, `
+			WITH purged AS (DELETE FROM thing WHERE delete_time < $1 RETURNING tenant, id),
+			revisions AS (DELETE FROM thing_revision WHERE (tenant, thing_id) IN (SELECT tenant, id FROM purged))
+			SELECT COUNT(*) FROM purged`, before)
+	})
+	return purged, err
 
 }
 
 // ThingFind gets things
-func (c *Client) ThingFind(ctx context.Context) ([]*thingrpc.Thing, error) {
+func (c *Client) ThingFind(ctx context.Context, q *query.Query) ([]*thingrpc.Thing, error) {
 
-	var bs = make([]*thingrpc.Thing, 0)
-	err := c.db.SelectContext(ctx, &bs, `SELECT * FROM thing`)
+	qb, err := thingQuery(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	var rs []*thingRow
+	err = c.withTx(ctx, func(tx *sqlx.Tx) error {
+		return tx.SelectContext(ctx, &rs, `SELECT `+thingSelectColumns(q)+` FROM thing`+qb.SQL(q), qb.Args...)
+	})
 	if err == sql.ErrNoRows {
 		// No Error
 	} else if err != nil {
-		return bs, err
+		return make([]*thingrpc.Thing, 0), err
+	}
+
+	var bs = make([]*thingrpc.Thing, len(rs))
+	for i, r := range rs {
+		bs[i] = r.thing()
 	}
 	return bs, nil
 
 }
+
+// thingGroupRow is the number of things with a value of the group by field
+type thingGroupRow struct {
+	Value []byte `db:"value"` // JSON
+	Count int64  `db:"count"`
+}
+
+// ThingAggregate counts things with SQL aggregation
+func (c *Client) ThingAggregate(ctx context.Context, q *query.Query, groupBy string) (*query.Aggregation, error) {
+
+	// The order and cursor of the query do not apply
+	qb, err := thingQuery(ctx, &query.Query{
+		Filter:      q.Filter,
+		Labels:      q.Labels,
+		ShowDeleted: q.ShowDeleted,
+	})
+	if err != nil {
+		return nil, err
+	}
+	where := qb.SQL(&query.Query{})
+	whereArgs := append([]interface{}(nil), qb.Args...)
+
+	// Ties are ordered by value so the groups returned are stable
+	var groupSQL string
+	if groupBy != "" {
+		groupSQL = `SELECT ` + thingGroupByValue(qb, groupBy) + ` AS value, COUNT(*) AS count FROM thing` + where + ` GROUP BY 1 ORDER BY 2 DESC, 1`
+		if q.Limit > 0 {
+			groupSQL += ` LIMIT ` + qb.Arg(q.Limit)
+		}
+	}
+
+	ag := &query.Aggregation{
+		Groups: make([]query.Group, 0),
+	}
+	var rs []*thingGroupRow
+	err = c.withTx(ctx, func(tx *sqlx.Tx) error {
+		if err := tx.GetContext(ctx, &ag.Total, `SELECT COUNT(*) FROM thing`+where, whereArgs...); err != nil || groupSQL == "" {
+			return err
+		}
+		return tx.SelectContext(ctx, &rs, groupSQL, qb.Args...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range rs {
+		g := query.Group{Count: r.Count}
+		if err = json.Unmarshal(r.Value, &g.Value); err != nil {
+			return nil, err
+		}
+		ag.Groups = append(ag.Groups, g)
+	}
+	return ag, nil
+
+}
+
+// thingGroupByValue returns the JSONB value of a group by field validated by the schema, a missing value is null
+func thingGroupByValue(qb *queryBuilder, field string) string {
+
+	path := strings.Split(field, ".")
+	switch {
+	case len(path) == 1:
+		return "to_jsonb(" + field + ")"
+	case path[0] == thingrpc.ThingSchema.Labels:
+		// Label keys may contain dots
+		return "COALESCE(" + path[0] + " -> " + qb.Arg(field[len(path[0])+1:]) + ", 'null'::JSONB)"
+	}
+	return "COALESCE(" + path[0] + " #> " + qb.Arg(pq.Array(path[1:])) + ", 'null'::JSONB)"
+
+}
+
+// thingSearchRow is a thing matching a search
+type thingSearchRow struct {
+	thingRow
+	Rank    float32 `db:"rank"`
+	Snippet string  `db:"snippet"`
+}
+
+// ThingSearch finds things with the full-text search index on their names, the query is ordered by rank then id
+// The search text is parsed with websearch_to_tsquery which accepts quoted phrases, OR and -word exclusions.
+func (c *Client) ThingSearch(ctx context.Context, q *query.Query, text string) ([]*thingrpc.ThingSearchResult, error) {
+
+	qb, err := thingQuery(ctx, &query.Query{
+		Filter:      q.Filter,
+		Labels:      q.Labels,
+		ShowDeleted: q.ShowDeleted,
+	})
+	if err != nil {
+		return nil, err
+	}
+	tsquery := "websearch_to_tsquery('simple', " + qb.Arg(text) + ")"
+	qb.Where = append(qb.Where, "search @@ "+tsquery)
+	matches := `SELECT thing.*, ts_rank(search, ` + tsquery + `) AS rank FROM thing` + qb.SQL(&query.Query{})
+
+	// The rank is only known after matching so the cursor applies to the matches, snippets are only made for the page
+	qb.Where = nil
+	qb.After(q.OrderBy, q.After)
+	var rs []*thingSearchRow
+	err = c.withTx(ctx, func(tx *sqlx.Tx) error {
+		return tx.SelectContext(ctx, &rs, `SELECT `+thingColumns+`, rank, ts_headline('simple', COALESCE(name, ''), `+tsquery+`) AS snippet
+			FROM (`+matches+`) AS thing`+qb.SQL(&query.Query{OrderBy: q.OrderBy, Limit: q.Limit}), qb.Args...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*thingrpc.ThingSearchResult, len(rs))
+	for i, r := range rs {
+		results[i] = &thingrpc.ThingSearchResult{
+			Thing:   r.thing(),
+			Rank:    r.Rank,
+			Snippet: r.Snippet,
+		}
+	}
+	return results, nil
+
+}
+
+// thingQuery builds the where clause selecting the things of the tenant of the context matching the query
+func thingQuery(ctx context.Context, q *query.Query) (*queryBuilder, error) {
+
+	qb := newQueryBuilder()
+	qb.Where = append(qb.Where, "tenant = "+qb.Arg(store.Tenant(ctx)))
+	if !q.ShowDeleted {
+		qb.Where = append(qb.Where, "delete_time IS NULL")
+	}
+	if err := qb.Filter(q.Filter); err != nil {
+		return nil, err
+	}
+	qb.labels(q.Labels)
+	qb.After(q.OrderBy, q.After)
+	return qb, nil
+
+}
@@ -0,0 +1,150 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"github.com/snowzach/gogrpcapi/conf"
+	"github.com/snowzach/gogrpcapi/store"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+const (
+	thingEventChannel = "thing_event" // Notified by the thing_revision_notify trigger
+	thingEventBatch   = 100           // The maximum number of events fetched at once
+	thingEventPoll    = 30 * time.Second
+)
+
+// thingEventRow is a thing revision with its position in the change feed
+type thingEventRow struct {
+	thingRevisionRow
+	Seq int64 `db:"seq"`
+}
+
+// thingEvent converts the row to a thing event
+func (r *thingEventRow) thingEvent() *thingrpc.ThingEvent {
+	eventTime, _ := ptypes.TimestampProto(r.RevisionTime)
+	e := &thingrpc.ThingEvent{
+		Thing:     r.thing(),
+		Actor:     r.Actor,
+		EventTime: eventTime,
+	}
+	switch r.Action {
+	case thingActionCreate:
+		e.Type = thingrpc.ThingEvent_CREATED
+	case thingActionUpdate, thingActionUndelete:
+		e.Type = thingrpc.ThingEvent_UPDATED
+	case thingActionDelete:
+		e.Type = thingrpc.ThingEvent_DELETED
+	}
+	return e
+}
+
+// ThingWatch calls send with each change to things after the after sequence number (0 = now) in order
+// It returns when the context is canceled or send returns an error
+func (c *Client) ThingWatch(ctx context.Context, after int64, send func(int64, *thingrpc.ThingEvent) error) error {
+
+	wake := make(chan struct{}, 1)
+	c.watchersLock.Lock()
+	c.watchers[wake] = struct{}{}
+	c.watchersLock.Unlock()
+	defer func() {
+		c.watchersLock.Lock()
+		delete(c.watchers, wake)
+		c.watchersLock.Unlock()
+	}()
+
+	// Start with changes made from now on
+	if after <= 0 {
+		err := c.withTx(ctx, func(tx *sqlx.Tx) error {
+			return tx.GetContext(ctx, &after, `SELECT COALESCE(MAX(seq), 0) FROM thing_revision WHERE tenant = $1`, store.Tenant(ctx))
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Check periodically in case a notification is missed
+	poll := time.NewTicker(thingEventPoll)
+	defer poll.Stop()
+
+	// Seqs are assigned as revisions commit (see the thing_revision_commit_seq trigger) so a revision of the tenant
+	// committed later never has a lower seq than one already sent
+	for {
+		var rs []*thingEventRow
+		err := c.withTx(ctx, func(tx *sqlx.Tx) error {
+			return tx.SelectContext(ctx, &rs, `
+				SELECT seq, `+thingRevisionColumns+` FROM thing_revision
+				WHERE tenant = $1 AND seq > $2 ORDER BY seq LIMIT $3
+			`, store.Tenant(ctx), after, thingEventBatch)
+		})
+		if err != nil {
+			return err
+		}
+		for _, r := range rs {
+			if err = send(r.Seq, r.thingEvent()); err != nil {
+				return err
+			}
+			after = r.Seq
+		}
+
+		// There may be more waiting
+		if len(rs) == thingEventBatch {
+			continue
+		}
+
+		select {
+		case <-wake:
+		case <-poll.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+}
+
+// thingEventListener listens for thing change notifications and wakes up the watchers until the program stops
+// The caller must call conf.Stop.Add(1) before starting it as a goroutine.
+func (c *Client) thingEventListener(dbURL string) {
+
+	defer conf.Stop.Done()
+
+	listener := pq.NewListener(dbURL, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			c.logger.Warnw("Thing event listener error", "error", err)
+		}
+	})
+	defer listener.Close()
+
+	if err := listener.Listen(thingEventChannel); err != nil {
+		c.logger.Errorw("Could not listen for thing events", "error", err)
+		return
+	}
+
+	ping := time.NewTicker(thingEventPoll)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-listener.Notify:
+			// A nil notification means the connection was reestablished, wake up anyways in case something was missed
+			c.watchersLock.Lock()
+			for wake := range c.watchers {
+				select {
+				case wake <- struct{}{}:
+				default: // Already awake
+				}
+			}
+			c.watchersLock.Unlock()
+		case <-ping.C:
+			go listener.Ping()
+		case <-conf.Stop.Chan():
+			return
+		}
+	}
+
+}
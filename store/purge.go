@@ -0,0 +1,31 @@
+package store
+
+import (
+	"time"
+
+	"github.com/snowzach/gogrpcapi/conf"
+)
+
+// Purger calls purge now and then every interval (1 hour if not set) until the program stops
+// It is run as a goroutine, the caller must call conf.Stop.Add(1) before starting it so the program waits for it.
+func Purger(interval time.Duration, purge func()) {
+
+	defer conf.Stop.Done()
+
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purge()
+
+		select {
+		case <-ticker.C:
+		case <-conf.Stop.Chan():
+			return
+		}
+	}
+
+}
@@ -0,0 +1,109 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Aggregation is the number of records matching a query, optionally counted by the value of a field
+type Aggregation struct {
+	Total  int64
+	Groups []Group // Ordered by count, largest first, then by value
+}
+
+// Group is the number of records with a value of the group by field, nil is records without a value
+type Group struct {
+	Value interface{}
+	Count int64
+}
+
+// ParseGroupBy checks a field to count records by: a string field, a path below a JSON field (ex: attributes.color)
+// or a label key (ex: labels.env)
+func (s *Schema) ParseGroupBy(field string) error {
+
+	if fieldType, ok := s.Fields[field]; ok {
+		if fieldType != TypeString {
+			return fmt.Errorf("cannot group_by field: %s", field)
+		}
+		return nil
+	}
+
+	if i := strings.IndexByte(field, '.'); i > 0 {
+		prefix, path := field[:i], field[i+1:]
+		if prefix == s.Labels && s.Labels != "" {
+			return validLabelKey(path)
+		} else if s.Fields[prefix] == TypeJSON {
+			for _, part := range strings.Split(path, ".") {
+				if part == "" {
+					return fmt.Errorf("invalid group_by path: %s", field)
+				}
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown group_by field: %s", field)
+
+}
+
+// Aggregator counts records for stores that aggregate in memory
+type Aggregator struct {
+	groupBy string
+	total   int64
+	groups  map[string]*Group // By the JSON encoding of the value
+}
+
+// NewAggregator returns an aggregator counting records by the groupBy field, only the total is counted if empty
+func NewAggregator(groupBy string) *Aggregator {
+	return &Aggregator{
+		groupBy: groupBy,
+		groups:  make(map[string]*Group),
+	}
+}
+
+// Add counts a record
+func (a *Aggregator) Add(value Value) {
+
+	a.total++
+	if a.groupBy == "" {
+		return
+	}
+	v := value(a.groupBy)
+	b, _ := json.Marshal(v)
+	g, ok := a.groups[string(b)]
+	if !ok {
+		g = &Group{Value: v}
+		a.groups[string(b)] = g
+	}
+	g.Count++
+
+}
+
+// Aggregation returns the counts with at most limit groups (0 = no limit)
+func (a *Aggregator) Aggregation(limit int) *Aggregation {
+
+	keys := make([]string, 0, len(a.groups))
+	for k := range a.groups {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if ci, cj := a.groups[keys[i]].Count, a.groups[keys[j]].Count; ci != cj {
+			return ci > cj
+		}
+		return keys[i] < keys[j]
+	})
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	ag := &Aggregation{
+		Total:  a.total,
+		Groups: make([]Group, len(keys)),
+	}
+	for i, k := range keys {
+		ag.Groups[i] = *a.groups[k]
+	}
+	return ag
+
+}
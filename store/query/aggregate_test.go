@@ -0,0 +1,45 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGroupBy(t *testing.T) {
+
+	schema := &Schema{Key: testSchema.Key, Fields: testSchema.Fields, Labels: "labels"}
+	for _, field := range []string{"name", "id", "attributes.color", "attributes.size.max", "labels.env", "labels.example.com/team"} {
+		assert.Nil(t, schema.ParseGroupBy(field), field)
+	}
+	for _, field := range []string{"", "nope", "create_time", "attributes", "attributes..a", "labels", "labels.not valid", "nope.a"} {
+		assert.NotNil(t, schema.ParseGroupBy(field), field)
+	}
+
+	// Labels can only be grouped by if the schema has them
+	assert.NotNil(t, testSchema.ParseGroupBy("labels.env"))
+
+}
+
+func TestAggregator(t *testing.T) {
+
+	a := NewAggregator("color")
+	for _, color := range []interface{}{"red", nil, "blue", "red", 1.0, "blue", "red"} {
+		color := color
+		a.Add(func(string) interface{} { return color })
+	}
+
+	assert.Equal(t, &Aggregation{Total: 7, Groups: []Group{
+		{Value: "red", Count: 3},
+		{Value: "blue", Count: 2},
+		{Value: 1.0, Count: 1},
+		{Value: nil, Count: 1},
+	}}, a.Aggregation(0))
+	assert.Equal(t, []Group{{Value: "red", Count: 3}}, a.Aggregation(1).Groups)
+
+	// Only the total without a group by field
+	a = NewAggregator("")
+	a.Add(func(string) interface{} { return "red" })
+	assert.Equal(t, &Aggregation{Total: 1, Groups: []Group{}}, a.Aggregation(0))
+
+}
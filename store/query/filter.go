@@ -0,0 +1,393 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Expr is a parsed filter expression
+type Expr interface {
+	expr()
+}
+
+// And is true if all of the expressions are true
+type And []Expr
+
+// Or is true if any of the expressions are true
+type Or []Expr
+
+// Not negates an expression
+type Not struct {
+	Expr Expr
+}
+
+// Compare compares a field to a value
+type Compare struct {
+	Field string
+	Op    Op
+	Value interface{}
+}
+
+func (And) expr()      {}
+func (Or) expr()       {}
+func (Not) expr()      {}
+func (*Compare) expr() {}
+
+// Op is a comparison operator
+type Op string
+
+// Supported comparison operators
+const (
+	OpEq  Op = "="
+	OpNe  Op = "!="
+	OpLt  Op = "<"
+	OpLe  Op = "<="
+	OpGt  Op = ">"
+	OpGe  Op = ">="
+	OpHas Op = ":" // The JSON value equals or is an array containing the value
+)
+
+// Error is an error in a query expression at a position (1 based)
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Pos)
+}
+
+// ParseFilter parses and type checks a filter expression (ex: `name = "foo" AND (id > "c0" OR NOT name = "bar")`)
+// The grammar is a subset of https://google.aip.dev/160 - comparisons joined with AND, OR, NOT and parenthesis.
+// An empty filter returns a nil Expr.
+func (s *Schema) ParseFilter(filter string) (Expr, error) {
+
+	p := &parser{schema: s, input: filter}
+	p.next()
+	if p.tok.kind == tokEOF {
+		return nil, nil
+	}
+
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+	return e, nil
+
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+	tokAnd
+	tokOr
+	tokNot
+	tokError
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int // offset in input
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of filter"
+	case tokString:
+		return "string " + strconv.Quote(t.text)
+	}
+	return strconv.Quote(t.text)
+}
+
+type parser struct {
+	schema *Schema
+	input  string
+	offset int
+	tok    token
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &Error{Pos: p.tok.pos + 1, Msg: fmt.Sprintf(format, args...)}
+}
+
+// parseOr parses: and { OR and }
+func (p *parser) parseOr() (Expr, error) {
+	e, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	or := Or{e}
+	for p.tok.kind == tokOr {
+		p.next()
+		if e, err = p.parseAnd(); err != nil {
+			return nil, err
+		}
+		or = append(or, e)
+	}
+	if len(or) == 1 {
+		return or[0], nil
+	}
+	return or, nil
+}
+
+// parseAnd parses: unary { AND unary }
+func (p *parser) parseAnd() (Expr, error) {
+	e, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	and := And{e}
+	for p.tok.kind == tokAnd {
+		p.next()
+		if e, err = p.parseUnary(); err != nil {
+			return nil, err
+		}
+		and = append(and, e)
+	}
+	if len(and) == 1 {
+		return and[0], nil
+	}
+	return and, nil
+}
+
+// parseUnary parses: [NOT] ( '(' or ')' | comparison )
+func (p *parser) parseUnary() (Expr, error) {
+
+	switch p.tok.kind {
+	case tokNot:
+		p.next()
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not{Expr: e}, nil
+
+	case tokLParen:
+		p.next()
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokRParen {
+			return nil, p.errorf("expected ) but found %s", p.tok)
+		}
+		p.next()
+		return e, nil
+
+	case tokIdent:
+		return p.parseCompare()
+	}
+
+	return nil, p.errorf("expected field but found %s", p.tok)
+
+}
+
+// parseCompare parses: field op value
+func (p *parser) parseCompare() (Expr, error) {
+
+	field := p.tok
+	fieldType, ok := p.schema.Fields[field.text]
+	if !ok {
+		// A path below a JSON field
+		if i := strings.IndexByte(field.text, '.'); i > 0 && p.schema.Fields[field.text[:i]] == TypeJSON {
+			return p.parseJSONCompare()
+		}
+		return nil, p.errorf("unknown field %s", field.text)
+	} else if fieldType == TypeJSON {
+		return nil, p.errorf("field %s requires a path (ex: %s.key)", field.text, field.text)
+	}
+	p.next()
+
+	if p.tok.kind != tokOp {
+		return nil, p.errorf("expected operator but found %s", p.tok)
+	}
+	op := Op(p.tok.text)
+	if op == OpHas {
+		return nil, p.errorf("operator : requires a JSON field")
+	}
+	p.next()
+
+	c := &Compare{Field: field.text, Op: op}
+	switch p.tok.kind {
+	case tokString:
+		switch fieldType {
+		case TypeString:
+			c.Value = p.tok.text
+		case TypeTimestamp:
+			t, err := time.Parse(time.RFC3339Nano, p.tok.text)
+			if err != nil {
+				return nil, p.errorf("field %s requires an RFC3339 timestamp", field.text)
+			}
+			c.Value = t
+		}
+	case tokNumber:
+		return nil, p.errorf("field %s does not accept a number", field.text)
+	default:
+		return nil, p.errorf("expected value but found %s", p.tok)
+	}
+	p.next()
+
+	return c, nil
+
+}
+
+// parseJSONCompare parses: field.path (=|!=|:) value where value is a string, number, true, false or null
+// JSON values are string, float64, bool or nil like encoding/json.
+func (p *parser) parseJSONCompare() (Expr, error) {
+
+	field := p.tok
+	for _, part := range strings.Split(field.text, ".") {
+		if part == "" {
+			return nil, p.errorf("invalid field path %s", field.text)
+		}
+	}
+	p.next()
+
+	if p.tok.kind != tokOp {
+		return nil, p.errorf("expected operator but found %s", p.tok)
+	}
+	op := Op(p.tok.text)
+	switch op {
+	case OpEq, OpNe, OpHas:
+	default:
+		return nil, p.errorf("operator %s is not supported by JSON fields", op)
+	}
+	p.next()
+
+	c := &Compare{Field: field.text, Op: op}
+	switch p.tok.kind {
+	case tokString:
+		c.Value = p.tok.text
+	case tokNumber:
+		f, err := strconv.ParseFloat(p.tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %s", p.tok.text)
+		}
+		c.Value = f
+	case tokIdent:
+		switch p.tok.text {
+		case "true":
+			c.Value = true
+		case "false":
+			c.Value = false
+		case "null":
+			if op == OpHas {
+				return nil, p.errorf("operator : does not accept null")
+			}
+			c.Value = nil
+		default:
+			return nil, p.errorf("expected value but found %s", p.tok)
+		}
+	default:
+		return nil, p.errorf("expected value but found %s", p.tok)
+	}
+	p.next()
+
+	return c, nil
+
+}
+
+// next reads the next token into p.tok
+func (p *parser) next() {
+
+	// Skip whitespace
+	for p.offset < len(p.input) && unicode.IsSpace(rune(p.input[p.offset])) {
+		p.offset++
+	}
+
+	start := p.offset
+	if start >= len(p.input) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return
+	}
+
+	c := p.input[start]
+	switch {
+	case c == '(':
+		p.offset++
+		p.tok = token{kind: tokLParen, text: "(", pos: start}
+
+	case c == ')':
+		p.offset++
+		p.tok = token{kind: tokRParen, text: ")", pos: start}
+
+	case c == ':':
+		p.offset++
+		p.tok = token{kind: tokOp, text: ":", pos: start}
+
+	case strings.ContainsRune("=!<>", rune(c)):
+		p.offset++
+		if p.offset < len(p.input) && p.input[p.offset] == '=' && c != '=' {
+			p.offset++
+		}
+		text := p.input[start:p.offset]
+		if text == "!" {
+			p.tok = token{kind: tokError, text: text, pos: start}
+		} else {
+			p.tok = token{kind: tokOp, text: text, pos: start}
+		}
+
+	case c == '"' || c == '\'':
+		var sb strings.Builder
+		p.offset++
+		for p.offset < len(p.input) {
+			ch := p.input[p.offset]
+			if ch == c {
+				p.offset++
+				p.tok = token{kind: tokString, text: sb.String(), pos: start}
+				return
+			}
+			if ch == '\\' && p.offset+1 < len(p.input) {
+				p.offset++
+				ch = p.input[p.offset]
+			}
+			sb.WriteByte(ch)
+			p.offset++
+		}
+		// Unterminated string, point at the opening quote
+		p.tok = token{kind: tokError, text: p.input[start:], pos: start}
+
+	case c == '-' || (c >= '0' && c <= '9'):
+		p.offset++
+		for p.offset < len(p.input) && strings.ContainsRune("0123456789.", rune(p.input[p.offset])) {
+			p.offset++
+		}
+		p.tok = token{kind: tokNumber, text: p.input[start:p.offset], pos: start}
+
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for p.offset < len(p.input) && (p.input[p.offset] == '_' || p.input[p.offset] == '.' || unicode.IsLetter(rune(p.input[p.offset])) || unicode.IsDigit(rune(p.input[p.offset]))) {
+			p.offset++
+		}
+		text := p.input[start:p.offset]
+		switch text {
+		case "AND":
+			p.tok = token{kind: tokAnd, text: text, pos: start}
+		case "OR":
+			p.tok = token{kind: tokOr, text: text, pos: start}
+		case "NOT":
+			p.tok = token{kind: tokNot, text: text, pos: start}
+		default:
+			p.tok = token{kind: tokIdent, text: text, pos: start}
+		}
+
+	default:
+		p.offset++
+		p.tok = token{kind: tokError, text: string(c), pos: start}
+	}
+
+}
@@ -0,0 +1,85 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFilter(t *testing.T) {
+
+	e, err := testSchema.ParseFilter("  ")
+	assert.Nil(t, err)
+	assert.Nil(t, e)
+
+	e, err = testSchema.ParseFilter(`name = "foo"`)
+	assert.Nil(t, err)
+	assert.Equal(t, &Compare{Field: "name", Op: OpEq, Value: "foo"}, e)
+
+	e, err = testSchema.ParseFilter(`name = "foo" AND id > 'c0' OR NOT (name != "b\"ar" AND id<="z")`)
+	assert.Nil(t, err)
+	assert.Equal(t, Or{
+		And{
+			&Compare{Field: "name", Op: OpEq, Value: "foo"},
+			&Compare{Field: "id", Op: OpGt, Value: "c0"},
+		},
+		Not{Expr: And{
+			&Compare{Field: "name", Op: OpNe, Value: `b"ar`},
+			&Compare{Field: "id", Op: OpLe, Value: "z"},
+		}},
+	}, e)
+
+}
+
+func TestParseFilterTimestamp(t *testing.T) {
+
+	e, err := testSchema.ParseFilter(`create_time >= "2020-04-01T12:30:00Z"`)
+	assert.Nil(t, err)
+	assert.Equal(t, &Compare{Field: "create_time", Op: OpGe, Value: time.Date(2020, 4, 1, 12, 30, 0, 0, time.UTC)}, e)
+
+}
+
+func TestParseFilterJSON(t *testing.T) {
+
+	e, err := testSchema.ParseFilter(`attributes.color = "red" AND attributes.size.max != -1.5 OR attributes.tags:true OR attributes.x = null`)
+	assert.Nil(t, err)
+	assert.Equal(t, Or{
+		And{
+			&Compare{Field: "attributes.color", Op: OpEq, Value: "red"},
+			&Compare{Field: "attributes.size.max", Op: OpNe, Value: -1.5},
+		},
+		&Compare{Field: "attributes.tags", Op: OpHas, Value: true},
+		&Compare{Field: "attributes.x", Op: OpEq, Value: nil},
+	}, e)
+
+}
+
+func TestParseFilterErrors(t *testing.T) {
+
+	for filter, pos := range map[string]int{
+		`nope = "foo"`:            1,
+		`name = 5`:                8,
+		`name "foo"`:              6,
+		`name = "foo`:             8,
+		`name = "foo" AND`:        17,
+		`(name = "foo"`:           14,
+		`name = "foo" id = "bar"`: 14,
+		`name ! "foo"`:            6,
+		`name = "foo" OR # `:      17,
+		`create_time > "today"`:   15,
+		`attributes = "foo"`:      1,
+		`attributes..a = "foo"`:   1,
+		`attributes.a > "foo"`:    14,
+		`attributes.a = yes`:      16,
+		`attributes.a : null`:     16,
+		`name : "foo"`:            6,
+		`nope.a = "foo"`:          1,
+	} {
+		_, err := testSchema.ParseFilter(filter)
+		if assert.IsType(t, &Error{}, err, filter) {
+			assert.Equal(t, pos, err.(*Error).Pos, filter)
+		}
+	}
+
+}
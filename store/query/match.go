@@ -0,0 +1,147 @@
+package query
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Value returns the value of a field of a record, strings for TypeString and time.Time for TypeTimestamp fields.
+// For paths below TypeJSON fields it returns the value decoded like encoding/json, missing values are nil.
+type Value func(field string) interface{}
+
+// Match returns true if the record matches the filter expression, a nil filter matches everything
+// It is used by stores that can not translate the filter into a native query.
+func Match(e Expr, value Value) bool {
+
+	switch e := e.(type) {
+	case nil:
+		return true
+	case And:
+		for _, sub := range e {
+			if !Match(sub, value) {
+				return false
+			}
+		}
+		return true
+	case Or:
+		for _, sub := range e {
+			if Match(sub, value) {
+				return true
+			}
+		}
+		return false
+	case Not:
+		return !Match(e.Expr, value)
+	case *Compare:
+		v := value(e.Field)
+		if e.Op == OpHas {
+			return Has(v, e.Value)
+		}
+		// Values of different JSON types are never equal
+		if reflect.TypeOf(v) != reflect.TypeOf(e.Value) {
+			return e.Op == OpNe
+		}
+		c := CompareValues(v, e.Value)
+		switch e.Op {
+		case OpEq:
+			return c == 0
+		case OpNe:
+			return c != 0
+		case OpLt:
+			return c < 0
+		case OpLe:
+			return c <= 0
+		case OpGt:
+			return c > 0
+		case OpGe:
+			return c >= 0
+		}
+	}
+	return false
+
+}
+
+// Has returns true if the JSON value equals the scalar value or is an array containing it
+func Has(v interface{}, scalar interface{}) bool {
+
+	if list, ok := v.([]interface{}); ok {
+		for _, item := range list {
+			if Has(item, scalar) {
+				return true
+			}
+		}
+		return false
+	}
+	return v != nil && reflect.TypeOf(v) == reflect.TypeOf(scalar) && CompareValues(v, scalar) == 0
+
+}
+
+// CompareValues compares two values of the same field type and returns -1, 0 or 1
+func CompareValues(a, b interface{}) int {
+
+	switch a := a.(type) {
+	case string:
+		b, _ := b.(string)
+		return strings.Compare(a, b)
+	case float64:
+		b, _ := b.(float64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	case bool:
+		b, _ := b.(bool)
+		switch {
+		case !a && b:
+			return -1
+		case a && !b:
+			return 1
+		}
+	case time.Time:
+		b, _ := b.(time.Time)
+		switch {
+		case a.Before(b):
+			return -1
+		case a.After(b):
+			return 1
+		}
+	}
+	return 0
+
+}
+
+// Less returns true if record a sorts before record b
+func Less(orderBy []OrderBy, a, b Value) bool {
+
+	for _, o := range orderBy {
+		c := CompareValues(a(o.Field), b(o.Field))
+		if o.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+	}
+	return false
+
+}
+
+// IsAfter returns true if the record sorts after the keyset cursor from a page token
+func IsAfter(orderBy []OrderBy, after []interface{}, value Value) bool {
+
+	if len(after) == 0 {
+		return true
+	}
+	return Less(orderBy, func(field string) interface{} {
+		for i, o := range orderBy {
+			if o.Field == field && i < len(after) {
+				return after[i]
+			}
+		}
+		return nil
+	}, value)
+
+}
@@ -0,0 +1,102 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testRecord(id string, name string, createTime time.Time) Value {
+	return func(field string) interface{} {
+		switch field {
+		case "id":
+			return id
+		case "name":
+			return name
+		case "create_time":
+			return createTime
+		case "attributes.color":
+			return "red"
+		case "attributes.size":
+			return 5.0
+		case "attributes.on":
+			return true
+		case "attributes.tags":
+			return []interface{}{"a", 1.0}
+		}
+		return nil
+	}
+}
+
+func TestMatch(t *testing.T) {
+
+	now := time.Now()
+	r := testRecord("id1", "foo", now)
+
+	for filter, match := range map[string]bool{
+		``:                                      true,
+		`name = "foo"`:                          true,
+		`name != "foo"`:                         false,
+		`name > "bar" AND id <= "id1"`:          true,
+		`name = "bar" OR id = "id1"`:            true,
+		`NOT (name = "bar" OR id = "id1")`:      false,
+		`create_time < "2000-01-01T00:00:00Z"`:  false,
+		`create_time >= "2000-01-01T00:00:00Z"`: true,
+	} {
+		e, err := testSchema.ParseFilter(filter)
+		assert.Nil(t, err, filter)
+		assert.Equal(t, match, Match(e, r), filter)
+	}
+
+}
+
+func TestMatchJSON(t *testing.T) {
+
+	r := testRecord("id1", "foo", time.Now())
+
+	for filter, match := range map[string]bool{
+		`attributes.color = "red"`:  true,
+		`attributes.color != "red"`: false,
+		`attributes.color = 5`:      false,
+		`attributes.color != 5`:     true,
+		`attributes.size = 5`:       true,
+		`attributes.size = 5.5`:     false,
+		`attributes.on = true`:      true,
+		`attributes.on = "true"`:    false,
+		`attributes.nope = null`:    true,
+		`attributes.nope != null`:   false,
+		`attributes.nope = "red"`:   false,
+		`attributes.tags : "a"`:     true,
+		`attributes.tags : 1`:       true,
+		`attributes.tags : "b"`:     false,
+		`attributes.color : "red"`:  true,
+		`attributes.tags = "a"`:     false,
+	} {
+		e, err := testSchema.ParseFilter(filter)
+		assert.Nil(t, err, filter)
+		assert.Equal(t, match, Match(e, r), filter)
+	}
+
+}
+
+func TestLess(t *testing.T) {
+
+	now := time.Now()
+	a := testRecord("id1", "foo", now)
+	b := testRecord("id2", "foo", now.Add(time.Second))
+
+	orderBy, err := testSchema.ParseOrderBy("name desc")
+	assert.Nil(t, err)
+	assert.True(t, Less(orderBy, a, b)) // Same name, ordered by id
+	assert.False(t, Less(orderBy, b, a))
+
+	orderBy, err = testSchema.ParseOrderBy("create_time desc")
+	assert.Nil(t, err)
+	assert.True(t, Less(orderBy, b, a))
+
+	assert.True(t, IsAfter(orderBy, []interface{}{now.Add(time.Second), "id2"}, a))
+	assert.False(t, IsAfter(orderBy, []interface{}{now.Add(time.Second), "id2"}, b))
+	assert.True(t, IsAfter(orderBy, nil, b))
+
+}
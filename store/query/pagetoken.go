@@ -0,0 +1,65 @@
+package query
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// pageToken is the contents of an opaque page token
+type pageToken struct {
+	OrderBy string        `json:"o"`
+	After   []interface{} `json:"a"`
+}
+
+// EncodePageToken returns an opaque page token that resumes after the values of the last record returned
+func EncodePageToken(orderBy []OrderBy, after []interface{}) string {
+
+	b, err := json.Marshal(&pageToken{
+		OrderBy: OrderByString(orderBy),
+		After:   after,
+	})
+	if err != nil {
+		// Should never happen with basic types
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+
+}
+
+// DecodePageToken returns the keyset cursor of a page token.
+// The token must have been created with the same ordering.
+func (s *Schema) DecodePageToken(token string, orderBy []OrderBy) ([]interface{}, error) {
+
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid page_token")
+	}
+
+	var pt pageToken
+	if err = json.Unmarshal(b, &pt); err != nil {
+		return nil, fmt.Errorf("invalid page_token")
+	}
+
+	if pt.OrderBy != OrderByString(orderBy) || len(pt.After) != len(orderBy) {
+		return nil, fmt.Errorf("page_token does not match order_by")
+	}
+
+	// Make sure the values are the type of the field
+	for i, o := range orderBy {
+		value, ok := pt.After[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid page_token")
+		}
+		switch s.Fields[o.Field] {
+		case TypeTimestamp:
+			if pt.After[i], err = time.Parse(time.RFC3339Nano, value); err != nil {
+				return nil, fmt.Errorf("invalid page_token")
+			}
+		}
+	}
+
+	return pt.After, nil
+
+}
@@ -0,0 +1,103 @@
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FieldType is the type of a queryable field
+type FieldType int
+
+// Supported field types
+const (
+	TypeString    FieldType = iota
+	TypeTimestamp           // RFC3339 string values converted to time.Time
+	TypeJSON                // JSON object, filters compare the value at a path below the field (ex: attributes.color)
+)
+
+// Schema describes the fields of a record that can be queried
+type Schema struct {
+	Key    string               // Unique field used as a tie-breaker when ordering
+	Fields map[string]FieldType // Queryable fields and their type
+	Labels string               // Field holding the labels, its keys can be grouped by (ex: labels.env)
+}
+
+// Query is a parsed request to find records in a store
+type Query struct {
+	Filter  Expr          // Only return results matching the filter (nil = all)
+	Labels  Selector      // Only return results with labels matching the selector (nil = all)
+	OrderBy []OrderBy     // Sort order of the results, always ends with the schema key
+	After   []interface{} // Keyset cursor, return results after these OrderBy values
+	Limit   int           // Maximum number of results or groups when aggregating (0 = no limit)
+	Fields  []string      // Fields the caller needs (nil = all), stores may return more and always return the OrderBy fields
+
+	ShowDeleted bool // Include soft deleted records
+}
+
+// OrderBy is a field to sort by
+type OrderBy struct {
+	Field string
+	Desc  bool
+}
+
+// String returns the order by in the same format as ParseOrderBy accepts
+func (o OrderBy) String() string {
+	if o.Desc {
+		return o.Field + " desc"
+	}
+	return o.Field
+}
+
+// ParseOrderBy parses a comma separated list of fields with an optional asc/desc suffix (ex: "name desc, id")
+// The schema key is appended if not already present so the ordering is always unique.
+func (s *Schema) ParseOrderBy(orderBy string) ([]OrderBy, error) {
+
+	var ret []OrderBy
+	seen := make(map[string]struct{})
+
+	if strings.TrimSpace(orderBy) != "" {
+		for _, part := range strings.Split(orderBy, ",") {
+			words := strings.Fields(part)
+			if len(words) == 0 || len(words) > 2 {
+				return nil, fmt.Errorf("invalid order_by clause: %q", strings.TrimSpace(part))
+			}
+			o := OrderBy{Field: words[0]}
+			if fieldType, ok := s.Fields[o.Field]; !ok {
+				return nil, fmt.Errorf("unknown order_by field: %s", o.Field)
+			} else if fieldType == TypeJSON {
+				return nil, fmt.Errorf("cannot order_by field: %s", o.Field)
+			}
+			if _, ok := seen[o.Field]; ok {
+				return nil, fmt.Errorf("duplicate order_by field: %s", o.Field)
+			}
+			if len(words) == 2 {
+				switch strings.ToLower(words[1]) {
+				case "asc":
+				case "desc":
+					o.Desc = true
+				default:
+					return nil, fmt.Errorf("invalid order_by direction: %s", words[1])
+				}
+			}
+			seen[o.Field] = struct{}{}
+			ret = append(ret, o)
+		}
+	}
+
+	// Always end with the key to keep the order stable
+	if _, ok := seen[s.Key]; !ok {
+		ret = append(ret, OrderBy{Field: s.Key})
+	}
+
+	return ret, nil
+
+}
+
+// OrderByString returns the canonical string representation of a list of OrderBy
+func OrderByString(orderBy []OrderBy) string {
+	parts := make([]string, len(orderBy))
+	for i, o := range orderBy {
+		parts[i] = o.String()
+	}
+	return strings.Join(parts, ",")
+}
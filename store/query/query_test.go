@@ -0,0 +1,74 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testSchema = &Schema{
+	Key: "id",
+	Fields: map[string]FieldType{
+		"id":          TypeString,
+		"name":        TypeString,
+		"create_time": TypeTimestamp,
+		"attributes":  TypeJSON,
+	},
+}
+
+func TestParseOrderBy(t *testing.T) {
+
+	orderBy, err := testSchema.ParseOrderBy("")
+	assert.Nil(t, err)
+	assert.Equal(t, []OrderBy{{Field: "id"}}, orderBy)
+
+	orderBy, err = testSchema.ParseOrderBy("name DESC")
+	assert.Nil(t, err)
+	assert.Equal(t, []OrderBy{{Field: "name", Desc: true}, {Field: "id"}}, orderBy)
+
+	orderBy, err = testSchema.ParseOrderBy(" id desc , name")
+	assert.Nil(t, err)
+	assert.Equal(t, []OrderBy{{Field: "id", Desc: true}, {Field: "name"}}, orderBy)
+
+	for _, bad := range []string{"nope", "name sideways", "name,name", "name desc asc", "name,", "attributes"} {
+		_, err = testSchema.ParseOrderBy(bad)
+		assert.NotNil(t, err, bad)
+	}
+
+}
+
+func TestPageToken(t *testing.T) {
+
+	orderBy, err := testSchema.ParseOrderBy("name desc")
+	assert.Nil(t, err)
+
+	token := EncodePageToken(orderBy, []interface{}{"name1", "id1"})
+	after, err := testSchema.DecodePageToken(token, orderBy)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"name1", "id1"}, after)
+
+	// Different ordering
+	otherOrderBy, err := testSchema.ParseOrderBy("name")
+	assert.Nil(t, err)
+	_, err = testSchema.DecodePageToken(token, otherOrderBy)
+	assert.NotNil(t, err)
+
+	// Garbage
+	_, err = testSchema.DecodePageToken("garbage!", orderBy)
+	assert.NotNil(t, err)
+
+}
+
+func TestPageTokenTimestamp(t *testing.T) {
+
+	orderBy, err := testSchema.ParseOrderBy("create_time desc")
+	assert.Nil(t, err)
+
+	createTime := time.Date(2020, 4, 1, 12, 30, 0, 123456000, time.UTC)
+	token := EncodePageToken(orderBy, []interface{}{createTime, "id1"})
+	after, err := testSchema.DecodePageToken(token, orderBy)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{createTime, "id1"}, after)
+
+}
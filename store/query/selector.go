@@ -0,0 +1,303 @@
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// SelectorOp is a label selector operator
+type SelectorOp string
+
+// Supported label selector operators
+const (
+	SelectorEquals       SelectorOp = "="
+	SelectorNotEquals    SelectorOp = "!="
+	SelectorIn           SelectorOp = "in"
+	SelectorNotIn        SelectorOp = "notin"
+	SelectorExists       SelectorOp = "exists"
+	SelectorDoesNotExist SelectorOp = "!"
+)
+
+// Requirement is a condition on a single label
+type Requirement struct {
+	Key    string
+	Op     SelectorOp
+	Values []string // One value for = and !=, at least one for in and notin, none otherwise
+}
+
+// Selector matches labels that meet all of its requirements, an empty selector matches everything
+type Selector []Requirement
+
+// Label keys are an optional DNS subdomain prefix and a name, values are a name or empty
+var (
+	labelNameRegexp   = regexp.MustCompile(`^([A-Za-z0-9][-A-Za-z0-9_.]*)?[A-Za-z0-9]$`)
+	labelPrefixRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+)
+
+// ValidateLabels checks that label keys and values use the same format as Kubernetes labels
+// Keys are a name with an optional prefix (ex: "example.com/team"), values are a name or empty.
+// Names are at most 63 characters of alphanumerics, '-', '_' or '.' starting and ending with an alphanumeric.
+func ValidateLabels(labels map[string]string) error {
+
+	for key, value := range labels {
+		if err := validLabelKey(key); err != nil {
+			return err
+		}
+		if err := validLabelValue(value); err != nil {
+			return fmt.Errorf("%s for label %s", err, key)
+		}
+	}
+	return nil
+
+}
+
+func validLabelKey(key string) error {
+
+	name := key
+	if i := strings.IndexByte(key, '/'); i >= 0 {
+		prefix := key[:i]
+		name = key[i+1:]
+		if len(prefix) > 253 || !labelPrefixRegexp.MatchString(prefix) {
+			return fmt.Errorf("invalid label key prefix: %q", key)
+		}
+	}
+	if len(name) > 63 || !labelNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid label key: %q", key)
+	}
+	return nil
+
+}
+
+func validLabelValue(value string) error {
+
+	if value == "" {
+		return nil
+	}
+	if len(value) > 63 || !labelNameRegexp.MatchString(value) {
+		return fmt.Errorf("invalid label value: %q", value)
+	}
+	return nil
+
+}
+
+// Matches returns true if the labels meet all the requirements
+func (s Selector) Matches(labels map[string]string) bool {
+
+	for _, r := range s {
+		if !r.Matches(labels) {
+			return false
+		}
+	}
+	return true
+
+}
+
+// Matches returns true if the labels meet the requirement
+// Like Kubernetes, != and notin also match labels that do not have the key.
+func (r Requirement) Matches(labels map[string]string) bool {
+
+	value, ok := labels[r.Key]
+	switch r.Op {
+	case SelectorEquals, SelectorIn:
+		return ok && r.hasValue(value)
+	case SelectorNotEquals, SelectorNotIn:
+		return !ok || !r.hasValue(value)
+	case SelectorExists:
+		return ok
+	case SelectorDoesNotExist:
+		return !ok
+	}
+	return false
+
+}
+
+func (r Requirement) hasValue(value string) bool {
+	for _, v := range r.Values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseSelector parses a comma separated list of label requirements using the Kubernetes syntax
+// (ex: `env=prod,team!=infra,tier in (a,b),!legacy`). An empty selector returns nil.
+func ParseSelector(selector string) (Selector, error) {
+
+	p := &selectorParser{input: selector}
+	p.next()
+	if p.tok.kind == tokEOF {
+		return nil, nil
+	}
+
+	var s Selector
+	for {
+		r, err := p.parseRequirement()
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, r)
+
+		switch p.tok.kind {
+		case tokEOF:
+			return s, nil
+		case tokComma:
+			p.next()
+		default:
+			return nil, p.errorf("expected , but found %s", p.tok)
+		}
+	}
+
+}
+
+// tokComma separates requirements and values, the other token kinds are shared with the filter parser
+const tokComma = tokError + 1
+
+type selectorParser struct {
+	input  string
+	offset int
+	tok    token
+}
+
+func (p *selectorParser) errorf(format string, args ...interface{}) error {
+	return &Error{Pos: p.tok.pos + 1, Msg: fmt.Sprintf(format, args...)}
+}
+
+// parseRequirement parses: key | !key | key (=|==|!=) [value] | key (in|notin) ( value {, value} )
+func (p *selectorParser) parseRequirement() (Requirement, error) {
+
+	var r Requirement
+
+	if p.tok.kind == tokOp && p.tok.text == "!" {
+		p.next()
+		r.Op = SelectorDoesNotExist
+	}
+
+	if p.tok.kind != tokIdent {
+		return r, p.errorf("expected label key but found %s", p.tok)
+	}
+	if err := validLabelKey(p.tok.text); err != nil {
+		return r, p.errorf("%s", err)
+	}
+	r.Key = p.tok.text
+	p.next()
+
+	if r.Op == SelectorDoesNotExist {
+		return r, nil
+	}
+
+	switch {
+	case p.tok.kind == tokEOF || p.tok.kind == tokComma:
+		r.Op = SelectorExists
+		return r, nil
+
+	case p.tok.kind == tokOp && (p.tok.text == "=" || p.tok.text == "=="):
+		r.Op = SelectorEquals
+
+	case p.tok.kind == tokOp && p.tok.text == "!=":
+		r.Op = SelectorNotEquals
+
+	case p.tok.kind == tokIdent && (p.tok.text == "in" || p.tok.text == "notin"):
+		r.Op = SelectorOp(p.tok.text)
+		p.next()
+		if p.tok.kind != tokLParen {
+			return r, p.errorf("expected ( but found %s", p.tok)
+		}
+		for {
+			p.next()
+			value, err := p.parseValue()
+			if err != nil {
+				return r, err
+			}
+			r.Values = append(r.Values, value)
+			if p.tok.kind == tokRParen {
+				p.next()
+				return r, nil
+			}
+			if p.tok.kind != tokComma {
+				return r, p.errorf("expected , or ) but found %s", p.tok)
+			}
+		}
+
+	default:
+		return r, p.errorf("expected operator but found %s", p.tok)
+	}
+
+	p.next()
+	value, err := p.parseValue()
+	if err != nil {
+		return r, err
+	}
+	r.Values = []string{value}
+	return r, nil
+
+}
+
+// parseValue parses an optional label value
+func (p *selectorParser) parseValue() (string, error) {
+
+	if p.tok.kind != tokIdent {
+		return "", nil // Empty value
+	}
+	if err := validLabelValue(p.tok.text); err != nil {
+		return "", p.errorf("%s", err)
+	}
+	value := p.tok.text
+	p.next()
+	return value, nil
+
+}
+
+// next reads the next token into p.tok
+func (p *selectorParser) next() {
+
+	// Skip whitespace
+	for p.offset < len(p.input) && p.input[p.offset] == ' ' {
+		p.offset++
+	}
+
+	start := p.offset
+	if start >= len(p.input) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return
+	}
+
+	c := p.input[start]
+	switch {
+	case c == ',':
+		p.offset++
+		p.tok = token{kind: tokComma, text: ",", pos: start}
+
+	case c == '(':
+		p.offset++
+		p.tok = token{kind: tokLParen, text: "(", pos: start}
+
+	case c == ')':
+		p.offset++
+		p.tok = token{kind: tokRParen, text: ")", pos: start}
+
+	case c == '=' || c == '!':
+		p.offset++
+		if p.offset < len(p.input) && p.input[p.offset] == '=' {
+			p.offset++
+		}
+		p.tok = token{kind: tokOp, text: p.input[start:p.offset], pos: start}
+
+	case isLabelChar(c):
+		for p.offset < len(p.input) && isLabelChar(p.input[p.offset]) {
+			p.offset++
+		}
+		p.tok = token{kind: tokIdent, text: p.input[start:p.offset], pos: start}
+
+	default:
+		p.offset++
+		p.tok = token{kind: tokError, text: string(c), pos: start}
+	}
+
+}
+
+// isLabelChar returns true for the characters allowed in label keys and values
+func isLabelChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("-_./", c) >= 0
+}
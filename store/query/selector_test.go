@@ -0,0 +1,97 @@
+package query
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSelector(t *testing.T) {
+
+	s, err := ParseSelector("  ")
+	assert.Nil(t, err)
+	assert.Nil(t, s)
+
+	s, err = ParseSelector(`env=prod, team != infra,tier in (a, b),example.com/legacy,!old,owner==,x notin (y)`)
+	assert.Nil(t, err)
+	assert.Equal(t, Selector{
+		{Key: "env", Op: SelectorEquals, Values: []string{"prod"}},
+		{Key: "team", Op: SelectorNotEquals, Values: []string{"infra"}},
+		{Key: "tier", Op: SelectorIn, Values: []string{"a", "b"}},
+		{Key: "example.com/legacy", Op: SelectorExists},
+		{Key: "old", Op: SelectorDoesNotExist},
+		{Key: "owner", Op: SelectorEquals, Values: []string{""}},
+		{Key: "x", Op: SelectorNotIn, Values: []string{"y"}},
+	}, s)
+
+}
+
+func TestParseSelectorErrors(t *testing.T) {
+
+	for selector, pos := range map[string]int{
+		`=prod`:         1,
+		`env=prod,`:     10,
+		`env prod`:      5,
+		`env in a`:      8,
+		`env in (a b)`:  11,
+		`env=prod team`: 10,
+		`-env=prod`:     1,
+		`env=prod!`:     9,
+		`env=prod$`:     9,
+		`Bad_/env=prod`: 1,
+		`!env=prod`:     5,
+		`env in (a,-b)`: 11,
+		`env=` + long(): 5,
+		long() + `=foo`: 1,
+	} {
+		_, err := ParseSelector(selector)
+		if assert.IsType(t, &Error{}, err, selector) {
+			assert.Equal(t, pos, err.(*Error).Pos, selector)
+		}
+	}
+
+}
+
+func long() string {
+	b := make([]byte, 64)
+	for i := range b {
+		b[i] = 'a'
+	}
+	return string(b)
+}
+
+func TestSelectorMatches(t *testing.T) {
+
+	labels := map[string]string{"env": "prod", "tier": "b", "owner": ""}
+
+	for selector, match := range map[string]bool{
+		``:                       true,
+		`env=prod`:               true,
+		`env=dev`:                false,
+		`env!=dev`:               true,
+		`team!=infra`:            true,
+		`tier in (a,b)`:          true,
+		`tier notin (a,b)`:       false,
+		`team notin (a)`:         true,
+		`owner`:                  true,
+		`owner=`:                 true,
+		`!owner`:                 false,
+		`!team`:                  true,
+		`env=prod,tier in (c,d)`: false,
+	} {
+		s, err := ParseSelector(selector)
+		assert.Nil(t, err, selector)
+		assert.Equal(t, match, s.Matches(labels), selector)
+	}
+
+}
+
+func TestValidateLabels(t *testing.T) {
+
+	assert.Nil(t, ValidateLabels(map[string]string{"env": "prod", "example.com/team": "", "a.b-c_d": "A.1"}))
+	assert.NotNil(t, ValidateLabels(map[string]string{"": "prod"}))
+	assert.NotNil(t, ValidateLabels(map[string]string{"env": "-prod"}))
+	assert.NotNil(t, ValidateLabels(map[string]string{"Example.com/env": "prod"}))
+	assert.NotNil(t, ValidateLabels(map[string]string{"env": long()}))
+
+}
@@ -0,0 +1,107 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/snowzach/gogrpcapi/store"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+// batchGetChunk keeps the number of query arguments under the SQLite limit
+const batchGetChunk = 500
+
+// errBatchFailed is used to roll back a batch when an item fails and partial success is not allowed
+var errBatchFailed = errors.New("batch failed")
+
+// ThingBatchGet returns the things with the given ids that exist, in no particular order
+func (c *Client) ThingBatchGet(ctx context.Context, ids []string, showDeleted bool) ([]*thingrpc.Thing, error) {
+
+	bs := make([]*thingrpc.Thing, 0, len(ids))
+	for len(ids) > 0 {
+		chunk := ids
+		if len(chunk) > batchGetChunk {
+			chunk = chunk[:batchGetChunk]
+		}
+		ids = ids[len(chunk):]
+
+		qb := newQueryBuilder()
+		where := "tenant = " + qb.Arg(store.Tenant(ctx))
+		placeholders := make([]string, len(chunk))
+		for i, id := range chunk {
+			placeholders[i] = qb.Arg(id)
+		}
+		where += " AND id IN (" + strings.Join(placeholders, ", ") + ")"
+		if !showDeleted {
+			where += " AND delete_time IS NULL"
+		}
+
+		var rs []*thingRow
+		if err := c.db.SelectContext(ctx, &rs, `SELECT `+thingColumns+` FROM thing WHERE `+where, qb.Args...); err != nil {
+			return nil, err
+		}
+		for _, r := range rs {
+			bs = append(bs, r.thing())
+		}
+	}
+	return bs, nil
+
+}
+
+// ThingBatchSave saves all of the things in one transaction
+func (c *Client) ThingBatchSave(ctx context.Context, things []*thingrpc.Thing, allowPartial bool) ([]error, error) {
+
+	return c.batch(ctx, len(things), allowPartial, func(tx *sqlx.Tx, i int) error {
+		return c.thingSave(ctx, tx, things[i])
+	})
+
+}
+
+// ThingBatchDelete marks all of the things as deleted in one transaction
+func (c *Client) ThingBatchDelete(ctx context.Context, ids []string, allowPartial bool) ([]error, error) {
+
+	return c.batch(ctx, len(ids), allowPartial, func(tx *sqlx.Tx, i int) error {
+		return c.thingDelete(ctx, tx, ids[i], "")
+	})
+
+}
+
+// batch runs f for count items in one transaction returning the error for each item.
+// Each item runs in a savepoint so a failed item can be rolled back while keeping the others.
+// If any item fails and allowPartial is false, the entire transaction is rolled back.
+func (c *Client) batch(ctx context.Context, count int, allowPartial bool, f func(tx *sqlx.Tx, i int) error) ([]error, error) {
+
+	errs := make([]error, count)
+	err := c.withTx(ctx, func(tx *sqlx.Tx) error {
+		var failed bool
+		for i := 0; i < count; i++ {
+			if _, err := tx.ExecContext(ctx, `SAVEPOINT batch_item`); err != nil {
+				return err
+			}
+			if errs[i] = f(tx, i); errs[i] != nil {
+				failed = true
+				if _, err := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT batch_item`); err != nil {
+					return err
+				}
+				if !allowPartial {
+					break
+				}
+			}
+			if _, err := tx.ExecContext(ctx, `RELEASE SAVEPOINT batch_item`); err != nil {
+				return err
+			}
+		}
+		if failed && !allowPartial {
+			return errBatchFailed
+		}
+		return nil
+	})
+	if err == errBatchFailed {
+		return errs, nil
+	}
+	return errs, err
+
+}
@@ -0,0 +1,77 @@
+package sqlite
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/snowzach/gogrpcapi/store"
+	"github.com/snowzach/gogrpcapi/store/query"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+// ThingImport saves the things in one transaction replacing any existing things with the same ids
+// Deleted things are restored with the imported values and recorded as undeleted.
+func (c *Client) ThingImport(ctx context.Context, things []*thingrpc.Thing) error {
+
+	return c.withTx(ctx, func(tx *sqlx.Tx) error {
+		for _, t := range things {
+			t.Etag = "" // Replace regardless of the etag
+			if err := c.thingImport(ctx, tx, t); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+}
+
+// thingImport saves an imported thing as part of a transaction, restoring it if it is deleted
+func (c *Client) thingImport(ctx context.Context, tx *sqlx.Tx, t *thingrpc.Thing) error {
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE thing SET name = ?3, labels = ?4, attributes = ?5, version = version + 1, update_time = ?6, delete_time = NULL
+		WHERE tenant = ?1 AND id = ?2 AND delete_time IS NOT NULL
+	`, store.Tenant(ctx), t.Id, t.Name, labels(t.Labels), attributes{t.Attributes}, now())
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return c.thingSave(ctx, tx, t)
+	}
+	if err = c.thingLabels(ctx, tx, t.Id, t.Labels); err != nil {
+		return err
+	}
+	return c.thingRevision(ctx, tx, t.Id, thingActionUndelete)
+
+}
+
+// ThingExport calls send with every thing matching the query, in order, until it returns an error
+// The things are read from a single query as they are sent.
+func (c *Client) ThingExport(ctx context.Context, q *query.Query, send func(*thingrpc.Thing) error) error {
+
+	qb, err := thingQuery(ctx, q)
+	if err != nil {
+		return err
+	}
+
+	rows, err := c.db.QueryxContext(ctx, `SELECT `+thingColumns+` FROM thing`+qb.SQL(q), qb.Args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r thingRow
+		if err = rows.StructScan(&r); err != nil {
+			return err
+		}
+		if err = send(r.thing()); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS thing_revision;
+DROP TABLE IF EXISTS thing;
@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS thing (
+  id TEXT PRIMARY KEY NOT NULL,
+  name TEXT,
+  version INTEGER NOT NULL DEFAULT 1,
+  create_time TIMESTAMP NOT NULL,
+  update_time TIMESTAMP NOT NULL,
+  delete_time TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS thing_name_id_idx ON thing (name, id);
+CREATE INDEX IF NOT EXISTS thing_create_time_id_idx ON thing (create_time, id);
+CREATE INDEX IF NOT EXISTS thing_update_time_id_idx ON thing (update_time, id);
+CREATE INDEX IF NOT EXISTS thing_delete_time_idx ON thing (delete_time) WHERE delete_time IS NOT NULL;
+
+-- seq is the global order of changes, used as the ThingWatch resume token
+CREATE TABLE IF NOT EXISTS thing_revision (
+  seq INTEGER PRIMARY KEY AUTOINCREMENT,
+  thing_id TEXT NOT NULL,
+  revision INTEGER NOT NULL,
+  action TEXT NOT NULL,
+  actor TEXT NOT NULL DEFAULT '',
+  revision_time TIMESTAMP NOT NULL,
+  name TEXT,
+  create_time TIMESTAMP NOT NULL,
+  update_time TIMESTAMP NOT NULL,
+  delete_time TIMESTAMP,
+  UNIQUE (thing_id, revision)
+);
@@ -0,0 +1,17 @@
+ALTER TABLE thing ADD COLUMN labels TEXT NOT NULL DEFAULT '{}';
+ALTER TABLE thing_revision ADD COLUMN labels TEXT NOT NULL DEFAULT '{}';
+
+-- The labels of each thing, one row per label so selectors can use an index
+CREATE TABLE IF NOT EXISTS thing_label (
+  thing_id TEXT NOT NULL,
+  key TEXT NOT NULL,
+  value TEXT NOT NULL,
+  PRIMARY KEY (thing_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS thing_label_key_value_idx ON thing_label (key, value);
+
+CREATE TRIGGER IF NOT EXISTS thing_label_purge AFTER DELETE ON thing
+BEGIN
+  DELETE FROM thing_label WHERE thing_id = OLD.id;
+END;
@@ -0,0 +1,41 @@
+-- SQLite can not drop columns, rebuild the tables without attributes
+CREATE TABLE thing_old (
+  id TEXT PRIMARY KEY NOT NULL,
+  name TEXT,
+  version INTEGER NOT NULL DEFAULT 1,
+  create_time TIMESTAMP NOT NULL,
+  update_time TIMESTAMP NOT NULL,
+  delete_time TIMESTAMP,
+  labels TEXT NOT NULL DEFAULT '{}'
+);
+INSERT INTO thing_old SELECT id, name, version, create_time, update_time, delete_time, labels FROM thing;
+DROP TABLE thing;
+ALTER TABLE thing_old RENAME TO thing;
+
+CREATE INDEX IF NOT EXISTS thing_name_id_idx ON thing (name, id);
+CREATE INDEX IF NOT EXISTS thing_create_time_id_idx ON thing (create_time, id);
+CREATE INDEX IF NOT EXISTS thing_update_time_id_idx ON thing (update_time, id);
+CREATE INDEX IF NOT EXISTS thing_delete_time_idx ON thing (delete_time) WHERE delete_time IS NOT NULL;
+
+CREATE TRIGGER IF NOT EXISTS thing_label_purge AFTER DELETE ON thing
+BEGIN
+  DELETE FROM thing_label WHERE thing_id = OLD.id;
+END;
+
+CREATE TABLE thing_revision_old (
+  seq INTEGER PRIMARY KEY AUTOINCREMENT,
+  thing_id TEXT NOT NULL,
+  revision INTEGER NOT NULL,
+  action TEXT NOT NULL,
+  actor TEXT NOT NULL DEFAULT '',
+  revision_time TIMESTAMP NOT NULL,
+  name TEXT,
+  create_time TIMESTAMP NOT NULL,
+  update_time TIMESTAMP NOT NULL,
+  delete_time TIMESTAMP,
+  labels TEXT NOT NULL DEFAULT '{}',
+  UNIQUE (thing_id, revision)
+);
+INSERT INTO thing_revision_old SELECT seq, thing_id, revision, action, actor, revision_time, name, create_time, update_time, delete_time, labels FROM thing_revision;
+DROP TABLE thing_revision;
+ALTER TABLE thing_revision_old RENAME TO thing_revision;
@@ -0,0 +1,2 @@
+ALTER TABLE thing ADD COLUMN attributes TEXT NOT NULL DEFAULT '{}';
+ALTER TABLE thing_revision ADD COLUMN attributes TEXT NOT NULL DEFAULT '{}';
@@ -0,0 +1,61 @@
+-- SQLite can not change primary keys, rebuild the tables with ids unique within a tenant
+CREATE TABLE thing_new (
+  tenant TEXT NOT NULL DEFAULT '',
+  id TEXT NOT NULL,
+  name TEXT,
+  version INTEGER NOT NULL DEFAULT 1,
+  create_time TIMESTAMP NOT NULL,
+  update_time TIMESTAMP NOT NULL,
+  delete_time TIMESTAMP,
+  labels TEXT NOT NULL DEFAULT '{}',
+  attributes TEXT NOT NULL DEFAULT '{}',
+  PRIMARY KEY (tenant, id)
+);
+INSERT INTO thing_new SELECT '', id, name, version, create_time, update_time, delete_time, labels, attributes FROM thing;
+DROP TABLE thing;
+ALTER TABLE thing_new RENAME TO thing;
+
+CREATE INDEX IF NOT EXISTS thing_tenant_name_id_idx ON thing (tenant, name, id);
+CREATE INDEX IF NOT EXISTS thing_tenant_create_time_id_idx ON thing (tenant, create_time, id);
+CREATE INDEX IF NOT EXISTS thing_tenant_update_time_id_idx ON thing (tenant, update_time, id);
+CREATE INDEX IF NOT EXISTS thing_delete_time_idx ON thing (delete_time) WHERE delete_time IS NOT NULL;
+
+CREATE TABLE thing_revision_new (
+  seq INTEGER PRIMARY KEY AUTOINCREMENT,
+  tenant TEXT NOT NULL DEFAULT '',
+  thing_id TEXT NOT NULL,
+  revision INTEGER NOT NULL,
+  action TEXT NOT NULL,
+  actor TEXT NOT NULL DEFAULT '',
+  revision_time TIMESTAMP NOT NULL,
+  name TEXT,
+  create_time TIMESTAMP NOT NULL,
+  update_time TIMESTAMP NOT NULL,
+  delete_time TIMESTAMP,
+  labels TEXT NOT NULL DEFAULT '{}',
+  attributes TEXT NOT NULL DEFAULT '{}',
+  UNIQUE (tenant, thing_id, revision)
+);
+INSERT INTO thing_revision_new SELECT seq, '', thing_id, revision, action, actor, revision_time, name, create_time, update_time, delete_time, labels, attributes FROM thing_revision;
+DROP TABLE thing_revision;
+ALTER TABLE thing_revision_new RENAME TO thing_revision;
+
+CREATE INDEX IF NOT EXISTS thing_revision_tenant_seq_idx ON thing_revision (tenant, seq);
+
+CREATE TABLE thing_label_new (
+  tenant TEXT NOT NULL DEFAULT '',
+  thing_id TEXT NOT NULL,
+  key TEXT NOT NULL,
+  value TEXT NOT NULL,
+  PRIMARY KEY (tenant, thing_id, key)
+);
+INSERT INTO thing_label_new SELECT '', thing_id, key, value FROM thing_label;
+DROP TABLE thing_label;
+ALTER TABLE thing_label_new RENAME TO thing_label;
+
+CREATE INDEX IF NOT EXISTS thing_label_tenant_key_value_idx ON thing_label (tenant, key, value);
+
+CREATE TRIGGER IF NOT EXISTS thing_label_purge AFTER DELETE ON thing
+BEGIN
+  DELETE FROM thing_label WHERE tenant = OLD.tenant AND thing_id = OLD.id;
+END;
@@ -0,0 +1,3 @@
+package migrations
+
+// We need an empty file to not have issues with compilation
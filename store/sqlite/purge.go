@@ -0,0 +1,22 @@
+package sqlite
+
+import (
+	"context"
+	"time"
+
+	"github.com/snowzach/gogrpcapi/store"
+)
+
+// purger periodically purges things that were deleted longer than purgeAfter ago until the program stops
+func (c *Client) purger(purgeAfter time.Duration, interval time.Duration) {
+
+	store.Purger(interval, func() {
+		purged, err := c.ThingPurge(context.Background(), time.Now().Add(-purgeAfter))
+		if err != nil {
+			c.logger.Errorw("Could not purge deleted things", "error", err)
+		} else if purged > 0 {
+			c.logger.Infow("Purged deleted things", "count", purged)
+		}
+	})
+
+}
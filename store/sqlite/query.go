@@ -0,0 +1,53 @@
+package sqlite
+
+import (
+	"strings"
+	"time"
+
+	"github.com/snowzach/gogrpcapi/store/query"
+	"github.com/snowzach/gogrpcapi/store/sqlquery"
+)
+
+// dialect builds sqlite SQL, querying JSON requires the optional json1 extension so it cannot be filtered
+var dialect = &sqlquery.Dialect{
+	Placeholder: "?%d",
+	Arg: func(v interface{}) interface{} {
+		// Times are converted to UTC to compare correctly with the stored text
+		if t, ok := v.(time.Time); ok {
+			return t.UTC()
+		}
+		return v
+	},
+}
+
+// queryBuilder builds the clauses of a sqlite select from a query
+type queryBuilder struct {
+	*sqlquery.Builder
+}
+
+func newQueryBuilder() *queryBuilder {
+	return &queryBuilder{sqlquery.New(dialect)}
+}
+
+// labels adds the label selector requirements for things of the tenant to the where clause
+// Each requirement selects ids from the thing_label table using its tenant, key and value index.
+func (qb *queryBuilder) labels(tenant string, s query.Selector) {
+
+	for _, r := range s {
+		sql := "id IN (SELECT thing_id FROM thing_label WHERE tenant = " + qb.Arg(tenant) + " AND key = " + qb.Arg(r.Key)
+		if len(r.Values) > 0 {
+			values := make([]string, len(r.Values))
+			for i, v := range r.Values {
+				values[i] = qb.Arg(v)
+			}
+			sql += " AND value IN (" + strings.Join(values, ", ") + ")"
+		}
+		sql += ")"
+		switch r.Op {
+		case query.SelectorNotEquals, query.SelectorNotIn, query.SelectorDoesNotExist:
+			sql = "NOT " + sql
+		}
+		qb.Where = append(qb.Where, sql)
+	}
+
+}
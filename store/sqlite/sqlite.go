@@ -0,0 +1,150 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"github.com/golang-migrate/migrate/v4/source/go_bindata"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3" // Import SQLite Support
+	"github.com/rs/xid"
+	config "github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"github.com/snowzach/gogrpcapi/conf"
+	"github.com/snowzach/gogrpcapi/store/sqlite/migrations"
+)
+
+// Client is the database client
+type Client struct {
+	logger *zap.SugaredLogger
+	db     *sqlx.DB
+	newID  func() string
+
+	// ThingWatch calls waiting for changes
+	watchers     map[chan struct{}]struct{}
+	watchersLock sync.Mutex
+}
+
+// New returns a new database client using the database file at storage.path
+func New() (*Client, error) {
+
+	path := config.GetString("storage.path")
+	if path == "" {
+		return nil, fmt.Errorf("No path specified")
+	}
+
+	c, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Permanently remove deleted things after the retention period
+	if purgeAfter := config.GetDuration("storage.purge_after"); purgeAfter > 0 {
+		conf.Stop.Add(1)
+		go c.purger(purgeAfter, config.GetDuration("storage.purge_interval"))
+	}
+
+	return c, nil
+
+}
+
+// open opens the database file and migrates it to the current schema
+func open(path string) (*Client, error) {
+
+	logger := zap.S().With("package", "storage.sqlite")
+
+	// Wait for locks instead of failing and take the write lock when starting a transaction to prevent deadlocks between writers
+	db, err := sqlx.Connect("sqlite3", "file:"+path+"?_busy_timeout=5000&_txlock=immediate")
+	if err != nil {
+		return nil, fmt.Errorf("Could not open database: %s", err)
+	}
+
+	logger.Debugw("Opened database", "storage.path", path)
+
+	c := &Client{
+		logger: logger,
+		db:     db,
+		newID: func() string {
+			return xid.New().String()
+		},
+		watchers: make(map[chan struct{}]struct{}),
+	}
+
+	// wrap assets into Resource
+	s := bindata.Resource(migrations.AssetNames(),
+		func(name string) ([]byte, error) {
+			return migrations.Asset(name)
+		})
+	d, err := bindata.WithInstance(s)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create migrations reader: %v", err)
+	}
+	driver, err := sqlite3.WithInstance(db.DB, &sqlite3.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("Could not create migrations driver: %v", err)
+	}
+	m, err := migrate.NewWithInstance("go-bindata", d, "sqlite3", driver)
+	if err != nil {
+		logger.Errorw("Database migration error",
+			"error", err,
+		)
+		return nil, fmt.Errorf("Migrate Error:%s", err)
+	}
+
+	// Do we wipe the database
+	if config.GetBool("storage.wipe_confirm") {
+		err = m.Down()
+		if err == migrate.ErrNoChange {
+			// Okay
+		} else if err != nil {
+			logger.Errorw("Migrate Database Down Error",
+				"Error", err,
+			)
+			return nil, fmt.Errorf("Migrate Error:%s", err)
+		} else {
+			logger.Warn("Database wipe complete...")
+		}
+	}
+
+	// Perform the migration up
+	err = m.Up()
+	if err == migrate.ErrNoChange {
+		logger.Info("Database schmea current")
+	} else if err != nil {
+		logger.Errorw("Migrate Error",
+			"error", err,
+		)
+		return nil, fmt.Errorf("Migrate Error:%s", err)
+	} else {
+		logger.Info("Database migration completed")
+	}
+
+	return c, nil
+
+}
+
+// withTx runs f in a transaction, it is committed if f returns nil and rolled back otherwise
+// Watchers are woken up after the changes are committed.
+func (c *Client) withTx(ctx context.Context, f func(tx *sqlx.Tx) error) error {
+
+	tx, err := c.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	c.wakeWatchers()
+	return nil
+
+}
@@ -0,0 +1,423 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/snowzach/gogrpcapi/apperr"
+	"github.com/snowzach/gogrpcapi/store"
+	"github.com/snowzach/gogrpcapi/store/query"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+// testClient returns a client using a new database in a temporary directory
+func testClient(t *testing.T) (*Client, func()) {
+
+	dir, err := ioutil.TempDir("", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "test.db")
+	c, err := open(path)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return c, func() {
+		c.db.Close()
+		os.RemoveAll(dir)
+	}
+
+}
+
+func TestThingSaveGetDelete(t *testing.T) {
+
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	id, err := c.ThingSave(ctx, &thingrpc.Thing{Name: "name1"})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, id)
+
+	b, err := c.ThingGetById(ctx, id, false)
+	assert.Nil(t, err)
+	assert.Equal(t, "name1", b.Name)
+	assert.Equal(t, "1", b.Etag)
+	assert.NotNil(t, b.CreateTime)
+
+	// Stale etag
+	_, err = c.ThingSave(ctx, &thingrpc.Thing{Id: id, Name: "name2", Etag: "2"})
+	assert.Equal(t, store.ErrEtagMismatch, err)
+
+	// Any etag matches * but the thing must exist
+	_, err = c.ThingSave(ctx, &thingrpc.Thing{Id: "missing", Name: "name2", Etag: store.EtagAny})
+	assert.Equal(t, store.ErrNotFound, err)
+
+	_, err = c.ThingSave(ctx, &thingrpc.Thing{Id: id, Name: "name2", Etag: "1"})
+	assert.Nil(t, err)
+
+	b, err = c.ThingUpdate(ctx, &thingrpc.Thing{Id: id, Name: "name3"}, []string{"name"})
+	assert.Nil(t, err)
+	assert.Equal(t, "3", b.Etag)
+
+	assert.Nil(t, c.ThingDeleteById(ctx, id, ""))
+	_, err = c.ThingGetById(ctx, id, false)
+	assert.Equal(t, store.ErrNotFound, err)
+	b, err = c.ThingGetById(ctx, id, true)
+	assert.Nil(t, err)
+	assert.NotNil(t, b.DeleteTime)
+
+	// Deleted things are not saved over
+	_, err = c.ThingSave(ctx, &thingrpc.Thing{Id: id, Name: "name4"})
+	assert.Equal(t, store.ErrNotFound, err)
+
+	// Deleting a missing thing is an error
+	assert.Equal(t, store.ErrNotFound, c.ThingDeleteById(ctx, "missing", ""))
+	assert.Equal(t, store.ErrNotFound, c.ThingDeleteById(ctx, "missing", "1"))
+	assert.Equal(t, store.ErrNotFound, c.ThingDeleteById(ctx, id, store.EtagAny))
+
+	b, err = c.ThingUndeleteById(ctx, id, "")
+	assert.Nil(t, err)
+	assert.Nil(t, b.DeleteTime)
+
+	// Undeleting a thing that is not deleted leaves it unchanged
+	etag := b.Etag
+	b, err = c.ThingUndeleteById(ctx, id, "")
+	assert.Nil(t, err)
+	assert.Equal(t, etag, b.Etag)
+
+	revisions, err := c.ThingListRevisions(ctx, id, 0, 10)
+	assert.Nil(t, err)
+	if assert.Len(t, revisions, 5) {
+		assert.Equal(t, "undelete", revisions[0].Action)
+		assert.Equal(t, "create", revisions[4].Action)
+	}
+	revisions, err = c.ThingListRevisions(ctx, id, 3, 10)
+	assert.Nil(t, err)
+	if assert.Len(t, revisions, 2) {
+		assert.Equal(t, "2", revisions[0].Revision)
+	}
+
+	b, err = c.ThingGetAsOf(ctx, id, time.Now(), false)
+	assert.Nil(t, err)
+	assert.Equal(t, "5", b.Etag)
+	_, err = c.ThingGetAsOf(ctx, id, time.Now().Add(-time.Hour), false)
+	assert.Equal(t, store.ErrNotFound, err)
+
+	// Purge
+	assert.Nil(t, c.ThingDeleteById(ctx, id, ""))
+	purged, err := c.ThingPurge(ctx, time.Now().Add(time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), purged)
+	_, err = c.ThingGetById(ctx, id, true)
+	assert.Equal(t, store.ErrNotFound, err)
+	revisions, err = c.ThingListRevisions(ctx, id, 0, 10)
+	assert.Nil(t, err)
+	assert.Empty(t, revisions)
+
+}
+
+func TestThingCreate(t *testing.T) {
+
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	id, err := c.ThingCreate(ctx, &thingrpc.Thing{Name: "name1"})
+	assert.Nil(t, err)
+	assert.NotEmpty(t, id)
+
+	_, err = c.ThingCreate(ctx, &thingrpc.Thing{Id: id, Name: "name2"})
+	assert.Equal(t, &store.FieldError{Err: store.ErrAlreadyExists, Field: "id"}, err)
+
+	b, err := c.ThingGetById(ctx, id, false)
+	assert.Nil(t, err)
+	assert.Equal(t, "name1", b.Name)
+	assert.Equal(t, "1", b.Etag)
+
+}
+
+func TestThingSearch(t *testing.T) {
+
+	c, cleanup := testClient(t)
+	defer cleanup()
+
+	// Full-text search requires the postgres store
+	_, err := c.ThingSearch(context.Background(), &query.Query{OrderBy: thingrpc.ThingSearchOrderBy}, "red")
+	assert.True(t, errors.Is(err, store.ErrUnimplemented))
+
+}
+
+func TestThingTenants(t *testing.T) {
+
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx1 := store.WithTenant(context.Background(), "tenant1")
+	ctx2 := store.WithTenant(context.Background(), "tenant2")
+
+	// The same id in two tenants are different things
+	_, err := c.ThingSave(ctx1, &thingrpc.Thing{Id: "id1", Name: "name1"})
+	assert.Nil(t, err)
+	_, err = c.ThingSave(ctx2, &thingrpc.Thing{Id: "id1", Name: "name2"})
+	assert.Nil(t, err)
+
+	b, err := c.ThingGetById(ctx1, "id1", false)
+	assert.Nil(t, err)
+	assert.Equal(t, "name1", b.Name)
+	assert.Equal(t, "1", b.Etag)
+
+	// Other tenants can not see them
+	_, err = c.ThingGetById(context.Background(), "id1", true)
+	assert.Equal(t, store.ErrNotFound, err)
+	bs, err := c.ThingFind(context.Background(), &query.Query{OrderBy: []query.OrderBy{{Field: "id"}}})
+	assert.Nil(t, err)
+	assert.Len(t, bs, 0)
+	bs, err = c.ThingFind(ctx2, &query.Query{OrderBy: []query.OrderBy{{Field: "id"}}})
+	assert.Nil(t, err)
+	if assert.Len(t, bs, 1) {
+		assert.Equal(t, "name2", bs[0].Name)
+	}
+
+	// Purging includes every tenant
+	assert.Nil(t, c.ThingDeleteById(ctx1, "id1", ""))
+	assert.Nil(t, c.ThingDeleteById(ctx2, "id1", ""))
+	purged, err := c.ThingPurge(context.Background(), time.Now().Add(time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), purged)
+
+}
+
+func TestThingFind(t *testing.T) {
+
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	for i, name := range []string{"b", "a", "c", "a"} {
+		_, err := c.ThingSave(ctx, &thingrpc.Thing{Id: "id" + string('1'+rune(i)), Name: name})
+		assert.Nil(t, err)
+	}
+
+	// Ordered by name
+	q := &query.Query{Limit: 3}
+	var err error
+	q.OrderBy, err = thingrpc.ThingSchema.ParseOrderBy("name desc")
+	assert.Nil(t, err)
+	q.Filter, err = thingrpc.ThingSchema.ParseFilter(`name != "c"`)
+	assert.Nil(t, err)
+
+	bs, err := c.ThingFind(ctx, q)
+	assert.Nil(t, err)
+	if assert.Len(t, bs, 3) {
+		assert.Equal(t, []string{"id1", "id2", "id4"}, []string{bs[0].Id, bs[1].Id, bs[2].Id})
+	}
+
+	// Ordered by id using the cursor
+	for orderBy, pages := range map[string][][]string{
+		"id":      {{"id1", "id2"}, {"id3", "id4"}, {}},
+		"id desc": {{"id4", "id3"}, {"id2", "id1"}, {}},
+	} {
+		q = &query.Query{Limit: 2}
+		q.OrderBy, err = thingrpc.ThingSchema.ParseOrderBy(orderBy)
+		assert.Nil(t, err)
+		for _, page := range pages {
+			bs, err = c.ThingFind(ctx, q)
+			assert.Nil(t, err)
+			ids := make([]string, len(bs))
+			for i, b := range bs {
+				ids[i] = b.Id
+			}
+			assert.Equal(t, page, ids, orderBy)
+			if len(bs) > 0 {
+				q.After = []interface{}{bs[len(bs)-1].Id}
+			}
+		}
+	}
+
+}
+
+func TestThingFindLabels(t *testing.T) {
+
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	for id, labels := range map[string]map[string]string{
+		"id1": {"env": "prod", "tier": "a"},
+		"id2": {"env": "dev", "tier": "b"},
+		"id3": {"env": "prod"},
+		"id4": nil,
+	} {
+		_, err := c.ThingSave(ctx, &thingrpc.Thing{Id: id, Labels: labels})
+		assert.Nil(t, err)
+	}
+
+	for selector, ids := range map[string][]string{
+		"env=prod":               {"id1", "id3"},
+		"env!=prod":              {"id2", "id4"},
+		"tier in (a,b)":          {"id1", "id2"},
+		"tier notin (a),!nope":   {"id2", "id3", "id4"},
+		"env,!tier":              {"id3"},
+		"env=prod,tier in (b,c)": {},
+	} {
+		q := &query.Query{}
+		var err error
+		q.OrderBy, err = thingrpc.ThingSchema.ParseOrderBy("")
+		assert.Nil(t, err)
+		q.Labels, err = query.ParseSelector(selector)
+		assert.Nil(t, err)
+
+		bs, err := c.ThingFind(ctx, q)
+		assert.Nil(t, err)
+		found := make([]string, len(bs))
+		for i, b := range bs {
+			found[i] = b.Id
+		}
+		assert.Equal(t, ids, found, selector)
+	}
+
+	// Querying JSON requires the optional json1 extension
+	q := &query.Query{}
+	var err error
+	q.Filter, err = thingrpc.ThingSchema.ParseFilter(`attributes.color = "red"`)
+	assert.Nil(t, err)
+	_, err = c.ThingFind(ctx, q)
+	assert.True(t, errors.Is(err, apperr.ErrInvalidArgument))
+
+}
+
+func TestThingAggregate(t *testing.T) {
+
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	for i, thing := range []*thingrpc.Thing{
+		{Name: "a", Labels: map[string]string{"env": "prod"}},
+		{Name: "b", Labels: map[string]string{"env": "prod"}, Attributes: thingrpc.ProtoValue(map[string]interface{}{"size": 2.0}).GetStructValue()},
+		{Name: "a", Labels: map[string]string{"env": "dev"}, Attributes: thingrpc.ProtoValue(map[string]interface{}{"size": 2.0}).GetStructValue()},
+		{Name: "c"},
+	} {
+		thing.Id = "id" + string('1'+rune(i))
+		_, err := c.ThingSave(ctx, thing)
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, c.ThingDeleteById(ctx, "id4", ""))
+
+	ag, err := c.ThingAggregate(ctx, &query.Query{}, "")
+	assert.Nil(t, err)
+	assert.Equal(t, &query.Aggregation{Total: 3, Groups: []query.Group{}}, ag)
+
+	// Largest groups first, missing values are nil
+	ag, err = c.ThingAggregate(ctx, &query.Query{ShowDeleted: true}, "labels.env")
+	assert.Nil(t, err)
+	assert.Equal(t, &query.Aggregation{Total: 4, Groups: []query.Group{{Value: "prod", Count: 2}, {Value: "dev", Count: 1}, {Value: nil, Count: 1}}}, ag)
+
+	ag, err = c.ThingAggregate(ctx, &query.Query{Limit: 1}, "labels.env")
+	assert.Nil(t, err)
+	assert.Equal(t, &query.Aggregation{Total: 3, Groups: []query.Group{{Value: "prod", Count: 2}}}, ag)
+
+	// Querying JSON requires the optional json1 extension
+	_, err = c.ThingAggregate(ctx, &query.Query{}, "attributes.size")
+	assert.True(t, errors.Is(err, apperr.ErrInvalidArgument))
+
+	q := &query.Query{}
+	q.Filter, err = thingrpc.ThingSchema.ParseFilter(`name != "b"`)
+	assert.Nil(t, err)
+	ag, err = c.ThingAggregate(ctx, q, "name")
+	assert.Nil(t, err)
+	assert.Equal(t, &query.Aggregation{Total: 2, Groups: []query.Group{{Value: "a", Count: 2}}}, ag)
+
+}
+
+func TestThingBatch(t *testing.T) {
+
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	id, err := c.ThingSave(ctx, &thingrpc.Thing{Name: "name1"})
+	assert.Nil(t, err)
+
+	// All or nothing
+	errs, err := c.ThingBatchSave(ctx, []*thingrpc.Thing{{Id: "new", Name: "new"}, {Id: id, Name: "name2", Etag: "5"}}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []error{nil, store.ErrEtagMismatch}, errs)
+	_, err = c.ThingGetById(ctx, "new", false)
+	assert.Equal(t, store.ErrNotFound, err)
+
+	// Partial
+	errs, err = c.ThingBatchSave(ctx, []*thingrpc.Thing{{Id: "new", Name: "new"}, {Id: id, Name: "name2", Etag: "5"}}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []error{nil, store.ErrEtagMismatch}, errs)
+	_, err = c.ThingGetById(ctx, "new", false)
+	assert.Nil(t, err)
+
+	bs, err := c.ThingBatchGet(ctx, []string{"new", id, "missing"}, false)
+	assert.Nil(t, err)
+	assert.Len(t, bs, 2)
+
+	errs, err = c.ThingBatchDelete(ctx, []string{"new", "missing"}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []error{nil, store.ErrNotFound}, errs)
+
+}
+
+func TestThingImport(t *testing.T) {
+
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	id, err := c.ThingSave(ctx, &thingrpc.Thing{Name: "name1"})
+	assert.Nil(t, err)
+
+	// Replaces regardless of the etag and generates missing ids
+	assert.Nil(t, c.ThingImport(ctx, []*thingrpc.Thing{{Id: id, Name: "name2", Etag: "5"}, {Name: "name3"}}))
+	b, err := c.ThingGetById(ctx, id, false)
+	assert.Nil(t, err)
+	assert.Equal(t, "name2", b.Name)
+
+	// Restores deleted things
+	assert.Nil(t, c.ThingDeleteById(ctx, id, ""))
+	assert.Nil(t, c.ThingImport(ctx, []*thingrpc.Thing{{Id: id, Name: "name4"}}))
+	b, err = c.ThingGetById(ctx, id, false)
+	assert.Nil(t, err)
+	assert.Equal(t, "name4", b.Name)
+	revisions, err := c.ThingListRevisions(ctx, id, 0, 1)
+	assert.Nil(t, err)
+	if assert.Len(t, revisions, 1) {
+		assert.Equal(t, "undelete", revisions[0].Action)
+	}
+
+}
+
+func TestThingWatch(t *testing.T) {
+
+	c, cleanup := testClient(t)
+	defer cleanup()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := c.ThingSave(ctx, &thingrpc.Thing{Id: "id1", Name: "name1"})
+	assert.Nil(t, err)
+	assert.Nil(t, c.ThingDeleteById(ctx, "id1", ""))
+
+	// Resume after the first change
+	var types []thingrpc.ThingEvent_Type
+	err = c.ThingWatch(ctx, 1, func(seq int64, event *thingrpc.ThingEvent) error {
+		types = append(types, event.Type)
+		return context.Canceled
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, []thingrpc.ThingEvent_Type{thingrpc.ThingEvent_DELETED}, types)
+
+}
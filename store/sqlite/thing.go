@@ -0,0 +1,513 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/ptypes"
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	"github.com/jmoiron/sqlx"
+
+	"github.com/snowzach/gogrpcapi/apperr"
+	"github.com/snowzach/gogrpcapi/store"
+	"github.com/snowzach/gogrpcapi/store/query"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+// thingColumns are the columns selected for a thing, the etag is the row version
+const thingColumns = `id, name, labels, attributes, CAST(version AS TEXT) AS etag, create_time, update_time, delete_time`
+
+// thingRow is a thing as stored in the database
+type thingRow struct {
+	ID         string     `db:"id"`
+	Name       string     `db:"name"`
+	Labels     labels     `db:"labels"`
+	Attributes attributes `db:"attributes"`
+	Etag       string     `db:"etag"`
+	CreateTime time.Time  `db:"create_time"`
+	UpdateTime time.Time  `db:"update_time"`
+	DeleteTime *time.Time `db:"delete_time"`
+}
+
+// thing converts the row to a thing
+func (r *thingRow) thing() *thingrpc.Thing {
+	createTime, _ := ptypes.TimestampProto(r.CreateTime)
+	updateTime, _ := ptypes.TimestampProto(r.UpdateTime)
+	t := &thingrpc.Thing{
+		Id:         r.ID,
+		Name:       r.Name,
+		Etag:       r.Etag,
+		CreateTime: createTime,
+		UpdateTime: updateTime,
+	}
+	if r.DeleteTime != nil {
+		t.DeleteTime, _ = ptypes.TimestampProto(*r.DeleteTime)
+	}
+	if len(r.Labels) > 0 {
+		t.Labels = r.Labels
+	}
+	if len(r.Attributes.GetFields()) > 0 {
+		t.Attributes = r.Attributes.Struct
+	}
+	return t
+}
+
+// labels are the labels of a thing stored as a JSON object
+type labels map[string]string
+
+// Value implements driver.Valuer
+func (l labels) Value() (driver.Value, error) {
+	if l == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(l)
+	return string(b), err
+}
+
+// Scan implements sql.Scanner
+func (l *labels) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case []byte:
+		return json.Unmarshal(src, l)
+	case string:
+		return json.Unmarshal([]byte(src), l)
+	case nil:
+		*l = nil
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into labels", src)
+}
+
+// attributes are the attributes of a thing stored as a JSON object
+type attributes struct {
+	*structpb.Struct
+}
+
+// Value implements driver.Valuer
+func (a attributes) Value() (driver.Value, error) {
+	if a.Struct == nil {
+		return "{}", nil
+	}
+	return new(jsonpb.Marshaler).MarshalToString(a.Struct)
+}
+
+// Scan implements sql.Scanner
+func (a *attributes) Scan(src interface{}) error {
+	a.Struct = new(structpb.Struct)
+	switch src := src.(type) {
+	case []byte:
+		return jsonpb.UnmarshalString(string(src), a.Struct)
+	case string:
+		return jsonpb.UnmarshalString(src, a.Struct)
+	case nil:
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into attributes", src)
+}
+
+// now returns the current time as stored in the database, times are stored as UTC text so they sort correctly
+func now() time.Time {
+	return time.Now().UTC()
+}
+
+// ThingGetByID returns the the thing by ID
+// Deleted things are only returned if showDeleted is true
+func (c *Client) ThingGetById(ctx context.Context, id string, showDeleted bool) (*thingrpc.Thing, error) {
+
+	return c.thingGet(ctx, c.db, id, showDeleted)
+
+}
+
+// thingGet returns the thing by ID using the database or a transaction
+func (c *Client) thingGet(ctx context.Context, q sqlx.QueryerContext, id string, showDeleted bool) (*thingrpc.Thing, error) {
+
+	var r thingRow
+	err := sqlx.GetContext(ctx, q, &r, `SELECT `+thingColumns+` FROM thing WHERE tenant = ?1 AND id = ?2 AND (?3 OR delete_time IS NULL)`, store.Tenant(ctx), id, showDeleted)
+	if err == sql.ErrNoRows {
+		return nil, store.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return r.thing(), nil
+
+}
+
+// ThingSave saves the thing
+// If the thing has an etag it must match the stored thing or ErrEtagMismatch is returned
+func (c *Client) ThingSave(ctx context.Context, i *thingrpc.Thing) (string, error) {
+
+	err := c.withTx(ctx, func(tx *sqlx.Tx) error {
+		return c.thingSave(ctx, tx, i)
+	})
+	return i.Id, err
+
+}
+
+// ThingCreate saves a new thing
+// ErrAlreadyExists is returned if a thing with the id exists, including deleted things that have not been purged
+func (c *Client) ThingCreate(ctx context.Context, i *thingrpc.Thing) (string, error) {
+
+	// Generate an ID if needed
+	if i.Id == "" {
+		i.Id = c.newID()
+	}
+
+	err := c.withTx(ctx, func(tx *sqlx.Tx) error {
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO thing (tenant, id, name, labels, attributes, create_time, update_time)
+			VALUES(?1, ?2, ?3, ?4, ?5, ?6, ?6)
+			ON CONFLICT (tenant, id) DO NOTHING
+		`, store.Tenant(ctx), i.Id, i.Name, labels(i.Labels), attributes{i.Attributes}, now())
+		if err != nil {
+			return err
+		}
+		if rows, err := result.RowsAffected(); err != nil {
+			return err
+		} else if rows == 0 {
+			return &store.FieldError{Err: store.ErrAlreadyExists, Field: "id"}
+		}
+		if err = c.thingLabels(ctx, tx, i.Id, i.Labels); err != nil {
+			return err
+		}
+		return c.thingRevision(ctx, tx, i.Id, thingActionCreate)
+	})
+	return i.Id, err
+
+}
+
+// thingSave saves the thing as part of a transaction
+func (c *Client) thingSave(ctx context.Context, tx *sqlx.Tx, i *thingrpc.Thing) error {
+
+	// Generate an ID if needed
+	if i.Id == "" {
+		i.Id = c.newID()
+	}
+
+	if i.Etag != "" {
+		// Only update the existing thing if the etag matches, any etag matches store.EtagAny
+		result, err := tx.ExecContext(ctx, `
+			UPDATE thing SET name = ?3, labels = ?4, attributes = ?5, version = version + 1, update_time = ?6
+			WHERE tenant = ?1 AND id = ?2 AND (?7 = '*' OR CAST(version AS TEXT) = ?7) AND delete_time IS NULL
+		`, store.Tenant(ctx), i.Id, i.Name, labels(i.Labels), attributes{i.Attributes}, now(), i.Etag)
+		if err != nil {
+			return err
+		}
+		if rows, err := result.RowsAffected(); err != nil {
+			return err
+		} else if rows == 0 {
+			return c.thingMissingError(ctx, tx, i.Id, i.Etag)
+		}
+	} else {
+		// Deleted things are not changed
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO thing (tenant, id, name, labels, attributes, create_time, update_time)
+			VALUES(?1, ?2, ?3, ?4, ?5, ?6, ?6)
+			ON CONFLICT (tenant, id) DO UPDATE
+			SET name = ?3, labels = ?4, attributes = ?5, version = version + 1, update_time = ?6
+			WHERE delete_time IS NULL
+		`, store.Tenant(ctx), i.Id, i.Name, labels(i.Labels), attributes{i.Attributes}, now())
+		if err != nil {
+			return err
+		}
+		if rows, err := result.RowsAffected(); err != nil {
+			return err
+		} else if rows == 0 {
+			return store.ErrNotFound
+		}
+	}
+	if err := c.thingLabels(ctx, tx, i.Id, i.Labels); err != nil {
+		return err
+	}
+
+	var version int64
+	if err := tx.GetContext(ctx, &version, `SELECT version FROM thing WHERE tenant = ?1 AND id = ?2`, store.Tenant(ctx), i.Id); err != nil {
+		return err
+	}
+	if version == 1 {
+		return c.thingRevision(ctx, tx, i.Id, thingActionCreate)
+	}
+	return c.thingRevision(ctx, tx, i.Id, thingActionUpdate)
+
+}
+
+// thingLabels replaces the rows used to select the thing by label as part of a transaction
+func (c *Client) thingLabels(ctx context.Context, tx *sqlx.Tx, id string, labels map[string]string) error {
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM thing_label WHERE tenant = ?1 AND thing_id = ?2`, store.Tenant(ctx), id); err != nil {
+		return err
+	}
+	for key, value := range labels {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO thing_label (tenant, thing_id, key, value) VALUES (?1, ?2, ?3, ?4)`, store.Tenant(ctx), id, key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+
+}
+
+// ThingUpdate updates only the given fields of the thing and returns the result
+// If the thing has an etag it must match the stored thing or ErrEtagMismatch is returned
+func (c *Client) ThingUpdate(ctx context.Context, i *thingrpc.Thing, paths []string) (*thingrpc.Thing, error) {
+
+	qb := newQueryBuilder()
+	where := "tenant = " + qb.Arg(store.Tenant(ctx)) + " AND id = " + qb.Arg(i.Id) + " AND delete_time IS NULL"
+	if i.Etag != "" && i.Etag != store.EtagAny {
+		where += " AND CAST(version AS TEXT) = " + qb.Arg(i.Etag)
+	}
+
+	set := []string{"version = version + 1", "update_time = " + qb.Arg(now())}
+	for _, path := range paths {
+		switch path {
+		case "name":
+			set = append(set, "name = "+qb.Arg(i.Name))
+		case "labels":
+			set = append(set, "labels = "+qb.Arg(labels(i.Labels)))
+		case "attributes":
+			set = append(set, "attributes = "+qb.Arg(attributes{i.Attributes}))
+		default:
+			return nil, fmt.Errorf("unknown update path: %s", path)
+		}
+	}
+
+	var b *thingrpc.Thing
+	err := c.withTx(ctx, func(tx *sqlx.Tx) error {
+		result, err := tx.ExecContext(ctx, `UPDATE thing SET `+strings.Join(set, ", ")+` WHERE `+where, qb.Args...)
+		if err != nil {
+			return err
+		}
+		if rows, err := result.RowsAffected(); err != nil {
+			return err
+		} else if rows == 0 {
+			return c.thingMissingError(ctx, tx, i.Id, i.Etag)
+		}
+		for _, path := range paths {
+			if path == "labels" {
+				if err = c.thingLabels(ctx, tx, i.Id, i.Labels); err != nil {
+					return err
+				}
+			}
+		}
+		if b, err = c.thingGet(ctx, tx, i.Id, false); err != nil {
+			return err
+		}
+		return c.thingRevision(ctx, tx, i.Id, thingActionUpdate)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+
+}
+
+// ThingDeleteById marks a thing as deleted, it is removed by ThingPurge after the retention period
+// ErrNotFound is returned if the thing does not exist or is already deleted.
+// If etag is not empty it must match the stored thing or ErrEtagMismatch is returned
+func (c *Client) ThingDeleteById(ctx context.Context, id string, etag string) error {
+
+	return c.withTx(ctx, func(tx *sqlx.Tx) error {
+		return c.thingDelete(ctx, tx, id, etag)
+	})
+
+}
+
+// thingDelete marks a thing as deleted as part of a transaction
+func (c *Client) thingDelete(ctx context.Context, tx *sqlx.Tx, id string, etag string) error {
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE thing SET delete_time = ?4, version = version + 1
+		WHERE tenant = ?1 AND id = ?2 AND (?3 IN ('', '*') OR CAST(version AS TEXT) = ?3) AND delete_time IS NULL
+	`, store.Tenant(ctx), id, etag, now())
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return c.thingMissingError(ctx, tx, id, etag)
+	}
+	return c.thingRevision(ctx, tx, id, thingActionDelete)
+
+}
+
+// ThingUndeleteById restores a deleted thing, things that are not deleted are returned unchanged
+// If etag is not empty it must match the stored thing or ErrEtagMismatch is returned
+func (c *Client) ThingUndeleteById(ctx context.Context, id string, etag string) (*thingrpc.Thing, error) {
+
+	var b *thingrpc.Thing
+	err := c.withTx(ctx, func(tx *sqlx.Tx) error {
+		result, err := tx.ExecContext(ctx, `
+			UPDATE thing SET delete_time = NULL, version = version + 1
+			WHERE tenant = ?1 AND id = ?2 AND (?3 IN ('', '*') OR CAST(version AS TEXT) = ?3) AND delete_time IS NOT NULL
+		`, store.Tenant(ctx), id, etag)
+		if err != nil {
+			return err
+		}
+		if rows, err := result.RowsAffected(); err != nil {
+			return err
+		} else if rows == 0 {
+			// The thing is missing, does not match the etag or is not deleted
+			if b, err = c.thingGet(ctx, tx, id, true); err != nil {
+				return err
+			} else if !store.EtagMatches(etag, b.Etag) {
+				return store.ErrEtagMismatch
+			}
+			return nil
+		}
+		if b, err = c.thingGet(ctx, tx, id, false); err != nil {
+			return err
+		}
+		return c.thingRevision(ctx, tx, id, thingActionUndelete)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+
+}
+
+// thingMissingError determines why a change to a thing did not affect any rows
+func (c *Client) thingMissingError(ctx context.Context, tx *sqlx.Tx, id string, etag string) error {
+
+	if etag == "" || etag == store.EtagAny {
+		return store.ErrNotFound
+	}
+
+	if _, err := c.thingGet(ctx, tx, id, false); err != nil {
+		return err
+	}
+	return store.ErrEtagMismatch
+
+}
+
+// ThingPurge permanently removes things of every tenant deleted before the given time with their labels and revisions
+func (c *Client) ThingPurge(ctx context.Context, before time.Time) (int64, error) {
+
+	var purged int64
+	err := c.withTx(ctx, func(tx *sqlx.Tx) error {
+		for _, table := range []string{"thing_label", "thing_revision"} {
+			if _, err := tx.ExecContext(ctx, `
+				DELETE FROM `+table+` WHERE (tenant, thing_id) IN (SELECT tenant, id FROM thing WHERE delete_time < ?1)
+			`, before.UTC()); err != nil {
+				return err
+			}
+		}
+		result, err := tx.ExecContext(ctx, `DELETE FROM thing WHERE delete_time < ?1`, before.UTC())
+		if err != nil {
+			return err
+		}
+		purged, err = result.RowsAffected()
+		return err
+	})
+	return purged, err
+
+}
+
+// ThingFind gets things
+func (c *Client) ThingFind(ctx context.Context, q *query.Query) ([]*thingrpc.Thing, error) {
+
+	qb, err := thingQuery(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	var rs []*thingRow
+	err = c.db.SelectContext(ctx, &rs, `SELECT `+thingColumns+` FROM thing`+qb.SQL(q), qb.Args...)
+	if err == sql.ErrNoRows {
+		// No Error
+	} else if err != nil {
+		return make([]*thingrpc.Thing, 0), err
+	}
+
+	var bs = make([]*thingrpc.Thing, len(rs))
+	for i, r := range rs {
+		bs[i] = r.thing()
+	}
+	return bs, nil
+
+}
+
+// thingGroupRow is the number of things with a value of the group by field
+type thingGroupRow struct {
+	Value sql.NullString `db:"value"`
+	Count int64          `db:"count"`
+}
+
+// ThingAggregate counts things with SQL aggregation, grouping by attributes is not supported
+func (c *Client) ThingAggregate(ctx context.Context, q *query.Query, groupBy string) (*query.Aggregation, error) {
+
+	// The order and cursor of the query do not apply
+	qb, err := thingQuery(ctx, &query.Query{
+		Filter:      q.Filter,
+		Labels:      q.Labels,
+		ShowDeleted: q.ShowDeleted,
+	})
+	if err != nil {
+		return nil, err
+	}
+	where := qb.SQL(&query.Query{})
+	whereArgs := append([]interface{}(nil), qb.Args...)
+
+	ag := &query.Aggregation{
+		Groups: make([]query.Group, 0),
+	}
+	if err = c.db.GetContext(ctx, &ag.Total, `SELECT COUNT(*) FROM thing`+where, whereArgs...); err != nil || groupBy == "" {
+		return ag, err
+	}
+
+	var value string
+	if path := strings.SplitN(groupBy, ".", 2); len(path) == 1 {
+		value = groupBy
+	} else if path[0] == thingrpc.ThingSchema.Labels {
+		value = "(SELECT value FROM thing_label WHERE thing_label.tenant = thing.tenant AND thing_id = thing.id AND key = " + qb.Arg(path[1]) + ")"
+	} else {
+		// Querying JSON requires the optional json1 extension
+		return nil, apperr.ErrInvalidArgument.Errorf("Grouping by %s is not supported by the sqlite store", groupBy)
+	}
+
+	// Ties are ordered by value with missing values last so the groups returned are stable and match the other stores
+	groupSQL := `SELECT ` + value + ` AS value, COUNT(*) AS count FROM thing` + where + ` GROUP BY 1 ORDER BY 2 DESC, value IS NULL, 1`
+	if q.Limit > 0 {
+		groupSQL += ` LIMIT ` + qb.Arg(q.Limit)
+	}
+	var rs []*thingGroupRow
+	if err = c.db.SelectContext(ctx, &rs, groupSQL, qb.Args...); err != nil {
+		return nil, err
+	}
+	for _, r := range rs {
+		g := query.Group{Count: r.Count}
+		if r.Value.Valid {
+			g.Value = r.Value.String
+		}
+		ag.Groups = append(ag.Groups, g)
+	}
+	return ag, nil
+
+}
+
+// ThingSearch is not supported, full-text search requires the postgres store
+func (c *Client) ThingSearch(ctx context.Context, q *query.Query, text string) ([]*thingrpc.ThingSearchResult, error) {
+	return nil, store.ErrUnimplemented.Errorf("Search requires the postgres store")
+}
+
+// thingQuery builds the where clause selecting the things of the tenant of the context matching the query
+func thingQuery(ctx context.Context, q *query.Query) (*queryBuilder, error) {
+
+	qb := newQueryBuilder()
+	qb.Where = append(qb.Where, "tenant = "+qb.Arg(store.Tenant(ctx)))
+	if !q.ShowDeleted {
+		qb.Where = append(qb.Where, "delete_time IS NULL")
+	}
+	if err := qb.Filter(q.Filter); err != nil {
+		return nil, err
+	}
+	qb.labels(store.Tenant(ctx), q.Labels)
+	qb.After(q.OrderBy, q.After)
+	return qb, nil
+
+}
@@ -0,0 +1,111 @@
+package sqlite
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+
+	"github.com/snowzach/gogrpcapi/store"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+const (
+	thingEventBatch = 100 // The maximum number of events fetched at once
+	thingEventPoll  = 5 * time.Second
+)
+
+// thingEventRow is a thing revision with its position in the change feed
+type thingEventRow struct {
+	thingRevisionRow
+	Seq int64 `db:"seq"`
+}
+
+// thingEvent converts the row to a thing event
+func (r *thingEventRow) thingEvent() *thingrpc.ThingEvent {
+	eventTime, _ := ptypes.TimestampProto(r.RevisionTime)
+	e := &thingrpc.ThingEvent{
+		Thing:     r.thing(),
+		Actor:     r.Actor,
+		EventTime: eventTime,
+	}
+	switch r.Action {
+	case thingActionCreate:
+		e.Type = thingrpc.ThingEvent_CREATED
+	case thingActionUpdate, thingActionUndelete:
+		e.Type = thingrpc.ThingEvent_UPDATED
+	case thingActionDelete:
+		e.Type = thingrpc.ThingEvent_DELETED
+	}
+	return e
+}
+
+// ThingWatch calls send with each change to things after the after sequence number (0 = now) in order
+// It returns when the context is canceled or send returns an error
+func (c *Client) ThingWatch(ctx context.Context, after int64, send func(int64, *thingrpc.ThingEvent) error) error {
+
+	wake := make(chan struct{}, 1)
+	c.watchersLock.Lock()
+	c.watchers[wake] = struct{}{}
+	c.watchersLock.Unlock()
+	defer func() {
+		c.watchersLock.Lock()
+		delete(c.watchers, wake)
+		c.watchersLock.Unlock()
+	}()
+
+	// Start with changes made from now on
+	if after <= 0 {
+		if err := c.db.GetContext(ctx, &after, `SELECT COALESCE(MAX(seq), 0) FROM thing_revision WHERE tenant = ?1`, store.Tenant(ctx)); err != nil {
+			return err
+		}
+	}
+
+	// Check periodically for changes made by other processes using the database
+	poll := time.NewTicker(thingEventPoll)
+	defer poll.Stop()
+
+	for {
+		var rs []*thingEventRow
+		err := c.db.SelectContext(ctx, &rs, `
+			SELECT seq, `+thingRevisionColumns+` FROM thing_revision
+			WHERE tenant = ?1 AND seq > ?2 ORDER BY seq LIMIT ?3
+		`, store.Tenant(ctx), after, thingEventBatch)
+		if err != nil {
+			return err
+		}
+		for _, r := range rs {
+			if err = send(r.Seq, r.thingEvent()); err != nil {
+				return err
+			}
+			after = r.Seq
+		}
+
+		// There may be more waiting
+		if len(rs) == thingEventBatch {
+			continue
+		}
+
+		select {
+		case <-wake:
+		case <-poll.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+}
+
+// wakeWatchers tells the ThingWatch calls there are changes
+func (c *Client) wakeWatchers() {
+
+	c.watchersLock.Lock()
+	for wake := range c.watchers {
+		select {
+		case wake <- struct{}{}:
+		default: // Already awake
+		}
+	}
+	c.watchersLock.Unlock()
+
+}
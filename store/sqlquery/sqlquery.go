@@ -0,0 +1,161 @@
+// Package sqlquery builds the clauses of SQL selects from store queries for the SQL stores
+package sqlquery
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/snowzach/gogrpcapi/apperr"
+	"github.com/snowzach/gogrpcapi/store/query"
+)
+
+// Dialect is what differs between databases when building SQL
+type Dialect struct {
+	Placeholder string                          // Format of the placeholder of the nth argument (ex: $%d)
+	Arg         func(v interface{}) interface{} // Converts argument values before they are added, nil adds them as is
+	// JSONCompare compiles a comparison of the value at a path below a JSON column (ex: attributes.a.b), nil if the
+	// database cannot filter JSON
+	JSONCompare func(b *Builder, path []string, op query.Op, value interface{}) (string, error)
+}
+
+// Builder builds the clauses of a SQL select from a query.
+// Field names are validated by the query schema and map directly to column names.
+type Builder struct {
+	Where   []string
+	Args    []interface{}
+	dialect *Dialect
+}
+
+// New returns a builder for the dialect
+func New(d *Dialect) *Builder {
+	return &Builder{dialect: d}
+}
+
+// Arg adds an argument and returns its placeholder
+func (b *Builder) Arg(v interface{}) string {
+	if b.dialect.Arg != nil {
+		v = b.dialect.Arg(v)
+	}
+	b.Args = append(b.Args, v)
+	return fmt.Sprintf(b.dialect.Placeholder, len(b.Args))
+}
+
+// After adds the keyset pagination condition to return rows after the cursor values.
+// For order by a, b desc, c this is: a > $1 OR (a = $1 AND b < $2) OR (a = $1 AND b = $2 AND c > $3)
+func (b *Builder) After(orderBy []query.OrderBy, after []interface{}) {
+
+	if len(after) == 0 {
+		return
+	}
+
+	placeholders := make([]string, len(after))
+	for i := range after {
+		placeholders[i] = b.Arg(after[i])
+	}
+
+	ors := make([]string, len(orderBy))
+	for i, o := range orderBy {
+		ands := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			ands = append(ands, orderBy[j].Field+" = "+placeholders[j])
+		}
+		op := " > "
+		if o.Desc {
+			op = " < "
+		}
+		ands = append(ands, o.Field+op+placeholders[i])
+		ors[i] = "(" + strings.Join(ands, " AND ") + ")"
+	}
+	b.Where = append(b.Where, "("+strings.Join(ors, " OR ")+")")
+
+}
+
+// Filter adds a filter expression to the where clause
+func (b *Builder) Filter(e query.Expr) error {
+
+	if e == nil {
+		return nil
+	}
+
+	sql, err := b.expr(e)
+	if err != nil {
+		return err
+	}
+	b.Where = append(b.Where, sql)
+	return nil
+
+}
+
+// expr compiles a filter expression into SQL with all values as arguments
+func (b *Builder) expr(e query.Expr) (string, error) {
+
+	switch e := e.(type) {
+	case query.And:
+		return b.exprList(e, " AND ")
+	case query.Or:
+		return b.exprList(e, " OR ")
+	case query.Not:
+		sql, err := b.expr(e.Expr)
+		if err != nil {
+			return "", err
+		}
+		return "NOT " + sql, nil
+	case *query.Compare:
+		if path := strings.Split(e.Field, "."); len(path) > 1 {
+			if b.dialect.JSONCompare == nil {
+				return "", apperr.ErrInvalidArgument.Errorf("Filtering by %s is not supported by this store", e.Field)
+			}
+			return b.dialect.JSONCompare(b, path, e.Op, e.Value)
+		}
+		switch e.Op {
+		case query.OpEq, query.OpNe, query.OpLt, query.OpLe, query.OpGt, query.OpGe:
+			// SQL uses the same operators, the field has been validated by the schema
+			return "(" + e.Field + " " + string(e.Op) + " " + b.Arg(e.Value) + ")", nil
+		}
+		return "", fmt.Errorf("unsupported operator %s", e.Op)
+	}
+
+	return "", fmt.Errorf("unsupported expression %T", e)
+
+}
+
+func (b *Builder) exprList(es []query.Expr, sep string) (string, error) {
+
+	parts := make([]string, len(es))
+	for i, e := range es {
+		sql, err := b.expr(e)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = sql
+	}
+	return "(" + strings.Join(parts, sep) + ")", nil
+
+}
+
+// SQL returns the WHERE, ORDER BY and LIMIT clauses for the query
+func (b *Builder) SQL(q *query.Query) string {
+
+	var sql string
+	if len(b.Where) > 0 {
+		sql += " WHERE " + strings.Join(b.Where, " AND ")
+	}
+
+	if len(q.OrderBy) > 0 {
+		orderBy := make([]string, len(q.OrderBy))
+		for i, o := range q.OrderBy {
+			orderBy[i] = o.Field
+			if o.Desc {
+				orderBy[i] += " DESC"
+			}
+		}
+		sql += " ORDER BY " + strings.Join(orderBy, ", ")
+	}
+
+	if q.Limit > 0 {
+		sql += " LIMIT " + b.Arg(q.Limit)
+	}
+
+	return sql
+
+}
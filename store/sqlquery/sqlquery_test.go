@@ -0,0 +1,60 @@
+package sqlquery
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/snowzach/gogrpcapi/apperr"
+	"github.com/snowzach/gogrpcapi/store/query"
+)
+
+var testSchema = &query.Schema{
+	Key: "id",
+	Fields: map[string]query.FieldType{
+		"id":   query.TypeString,
+		"name": query.TypeString,
+	},
+}
+
+func TestBuilder(t *testing.T) {
+
+	b := New(&Dialect{Placeholder: "$%d"})
+	b.Where = append(b.Where, "tenant = "+b.Arg("tenant1"))
+
+	e, err := testSchema.ParseFilter(`name = "a" OR NOT name >= "c"`)
+	assert.Nil(t, err)
+	assert.Nil(t, b.Filter(e))
+	b.After([]query.OrderBy{{Field: "name", Desc: true}, {Field: "id"}}, []interface{}{"b", "id1"})
+
+	sql := b.SQL(&query.Query{OrderBy: []query.OrderBy{{Field: "name", Desc: true}, {Field: "id"}}, Limit: 10})
+	assert.Equal(t, ` WHERE tenant = $1 AND ((name = $2) OR NOT (name >= $3)) AND ((name < $4) OR (name = $4 AND id > $5)) ORDER BY name DESC, id LIMIT $6`, sql)
+	assert.Equal(t, []interface{}{"tenant1", "a", "c", "b", "id1", 10}, b.Args)
+
+}
+
+func TestBuilderJSON(t *testing.T) {
+
+	e := &query.Compare{Field: "attributes.color", Op: query.OpEq, Value: "red"}
+
+	// Not supported without JSONCompare
+	b := New(&Dialect{Placeholder: "?%d"})
+	err := b.Filter(e)
+	assert.True(t, errors.Is(err, apperr.ErrInvalidArgument))
+	assert.Equal(t, "Filtering by attributes.color is not supported by this store", err.Error())
+
+	b = New(&Dialect{
+		Placeholder: "?%d",
+		Arg: func(v interface{}) interface{} {
+			return v.(string) + "!"
+		},
+		JSONCompare: func(b *Builder, path []string, op query.Op, value interface{}) (string, error) {
+			return path[0] + "->" + path[1] + " " + string(op) + " " + b.Arg(value), nil
+		},
+	})
+	assert.Nil(t, b.Filter(e))
+	assert.Equal(t, ` WHERE attributes->color = ?1`, b.SQL(&query.Query{}))
+	assert.Equal(t, []interface{}{"red!"}, b.Args)
+
+}
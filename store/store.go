@@ -1,8 +1,32 @@
 package store
 
 import (
-	"errors"
+	"github.com/snowzach/gogrpcapi/apperr"
 )
 
 // ErrNotFound is a standard no found error
-var ErrNotFound = errors.New("Not Found")
+var ErrNotFound = apperr.ErrNotFound
+
+// ErrAlreadyExists is returned when a change uses a unique value (ex: an id) of another record
+var ErrAlreadyExists = apperr.ErrAlreadyExists
+
+// ErrEtagMismatch is returned when the etag of a change does not match the stored record
+var ErrEtagMismatch = apperr.ErrEtagMismatch
+
+// ErrIdempotencyKeyReused is returned when an idempotency key is repeated with a different change
+var ErrIdempotencyKeyReused = apperr.ErrIdempotencyKeyReused
+
+// ErrUnimplemented is returned when a store does not support an operation
+var ErrUnimplemented = apperr.ErrUnimplemented
+
+// FieldError is a store error caused by the value of a field (ex: ErrAlreadyExists for a duplicate name)
+// Use errors.Is to check for the store error and errors.As to get the field.
+type FieldError = apperr.FieldError
+
+// EtagAny is the etag of If-Match: *, a change with it only requires the record to exist
+const EtagAny = "*"
+
+// EtagMatches returns if the etag of a change matches the etag of the stored record, empty and EtagAny match any etag
+func EtagMatches(etag string, stored string) bool {
+	return etag == "" || etag == EtagAny || etag == stored
+}
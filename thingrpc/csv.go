@@ -0,0 +1,162 @@
+package thingrpc
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/ptypes"
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	"github.com/golang/protobuf/ptypes/timestamp"
+)
+
+// ThingCSVColumns are the columns of things in CSV, labels and attributes are JSON objects
+var ThingCSVColumns = []string{"id", "name", "labels", "attributes", "etag", "create_time", "update_time", "delete_time"}
+
+// ThingCSVWriter writes things as CSV rows after a header row
+type ThingCSVWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+// NewThingCSVWriter returns a writer of things as CSV to w
+func NewThingCSVWriter(w io.Writer) *ThingCSVWriter {
+	return &ThingCSVWriter{w: csv.NewWriter(w)}
+}
+
+// Write writes a thing, the header is written before the first thing
+func (w *ThingCSVWriter) Write(t *Thing) error {
+
+	if !w.header {
+		if err := w.w.Write(ThingCSVColumns); err != nil {
+			return err
+		}
+		w.header = true
+	}
+
+	labels := "{}"
+	if len(t.Labels) > 0 {
+		b, err := json.Marshal(t.Labels)
+		if err != nil {
+			return err
+		}
+		labels = string(b)
+	}
+	attributes := "{}"
+	if t.Attributes != nil {
+		var err error
+		if attributes, err = new(jsonpb.Marshaler).MarshalToString(t.Attributes); err != nil {
+			return err
+		}
+	}
+
+	return w.w.Write([]string{
+		t.Id,
+		t.Name,
+		labels,
+		attributes,
+		t.Etag,
+		timestampString(t.CreateTime),
+		timestampString(t.UpdateTime),
+		timestampString(t.DeleteTime),
+	})
+
+}
+
+// Flush writes any buffered rows, the header is written if no things were written
+func (w *ThingCSVWriter) Flush() error {
+
+	if !w.header {
+		if err := w.w.Write(ThingCSVColumns); err != nil {
+			return err
+		}
+		w.header = true
+	}
+	w.w.Flush()
+	return w.w.Error()
+
+}
+
+// ThingCSVReader reads things from CSV rows after a header row naming the columns
+// Only the id, name, labels and attributes columns are read, the other ThingCSVColumns are ignored
+// so exported things can be imported.
+type ThingCSVReader struct {
+	r       *csv.Reader
+	columns []string
+	row     int
+}
+
+// NewThingCSVReader returns a reader of things as CSV from r
+func NewThingCSVReader(r io.Reader) *ThingCSVReader {
+	return &ThingCSVReader{r: csv.NewReader(r)}
+}
+
+// Read returns the next thing, io.EOF if there are no more
+func (r *ThingCSVReader) Read() (*Thing, error) {
+
+	if r.columns == nil {
+		header, err := r.r.Read()
+		if err != nil {
+			return nil, err
+		}
+		for _, column := range header {
+			if !isThingCSVColumn(column) {
+				return nil, fmt.Errorf("unknown column: %s", column)
+			}
+		}
+		r.columns = header
+	}
+
+	row, err := r.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	r.row++
+
+	t := new(Thing)
+	for i, value := range row {
+		switch r.columns[i] {
+		case "id":
+			t.Id = value
+		case "name":
+			t.Name = value
+		case "labels":
+			if value != "" {
+				if err = json.Unmarshal([]byte(value), &t.Labels); err != nil {
+					return nil, fmt.Errorf("invalid labels in row %d: %s", r.row, err)
+				}
+				if len(t.Labels) == 0 {
+					t.Labels = nil
+				}
+			}
+		case "attributes":
+			if value != "" && value != "{}" {
+				t.Attributes = new(structpb.Struct)
+				if err = jsonpb.UnmarshalString(value, t.Attributes); err != nil {
+					return nil, fmt.Errorf("invalid attributes in row %d: %s", r.row, err)
+				}
+			}
+		}
+	}
+	return t, nil
+
+}
+
+func isThingCSVColumn(column string) bool {
+	for _, c := range ThingCSVColumns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+// timestampString formats a timestamp as RFC3339, nil is empty
+func timestampString(ts *timestamp.Timestamp) string {
+	if ts == nil {
+		return ""
+	}
+	return ptypes.TimestampString(ts)
+}
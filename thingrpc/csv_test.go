@@ -0,0 +1,63 @@
+package thingrpc
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes"
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThingCSV(t *testing.T) {
+
+	var buf bytes.Buffer
+	w := NewThingCSVWriter(&buf)
+	assert.Nil(t, w.Write(&Thing{
+		Id:     "id1",
+		Name:   "name, with comma",
+		Labels: map[string]string{"env": "prod"},
+		Attributes: &structpb.Struct{Fields: map[string]*structpb.Value{
+			"color": {Kind: &structpb.Value_StringValue{StringValue: "red"}},
+		}},
+		Etag:       "2",
+		CreateTime: ptypes.TimestampNow(),
+	}))
+	assert.Nil(t, w.Write(&Thing{Id: "id2", Name: "name2"}))
+	assert.Nil(t, w.Flush())
+	assert.True(t, strings.HasPrefix(buf.String(), strings.Join(ThingCSVColumns, ",")+"\n"))
+
+	r := NewThingCSVReader(&buf)
+	thing, err := r.Read()
+	assert.Nil(t, err)
+	assert.Equal(t, "id1", thing.Id)
+	assert.Equal(t, "name, with comma", thing.Name)
+	assert.Equal(t, map[string]string{"env": "prod"}, thing.Labels)
+	assert.Equal(t, "red", thing.Attributes.Fields["color"].GetStringValue())
+	assert.Empty(t, thing.Etag)
+
+	thing, err = r.Read()
+	assert.Nil(t, err)
+	assert.Equal(t, "id2", thing.Id)
+	assert.Nil(t, thing.Labels)
+
+	_, err = r.Read()
+	assert.Equal(t, io.EOF, err)
+
+}
+
+func TestThingCSVReaderErrors(t *testing.T) {
+
+	_, err := NewThingCSVReader(strings.NewReader("id,nope\nid1,x\n")).Read()
+	assert.NotNil(t, err)
+
+	_, err = NewThingCSVReader(strings.NewReader("id,labels\nid1,[]\n")).Read()
+	assert.NotNil(t, err)
+
+	// Only a header
+	_, err = NewThingCSVReader(strings.NewReader("name\n")).Read()
+	assert.Equal(t, io.EOF, err)
+
+}
@@ -0,0 +1,175 @@
+package thingrpc
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/grpc-ecosystem/grpc-gateway/utilities"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+
+	"github.com/snowzach/gogrpcapi/apperr"
+)
+
+const (
+	mimeCSV    = "text/csv"
+	mimeNDJSON = "application/x-ndjson"
+
+	// exportErrorTrailer is set if an export fails after the response has started
+	exportErrorTrailer = "X-Export-Error"
+)
+
+var (
+	patternImportThings = runtime.MustPattern(runtime.NewPattern(1, []int{2, 0}, []string{"things"}, "import", runtime.AssumeColonVerbOpt(true)))
+	patternExportThings = runtime.MustPattern(runtime.NewPattern(1, []int{2, 0}, []string{"things"}, "export", runtime.AssumeColonVerbOpt(true)))
+)
+
+// RegisterThingRPCBulkHandlerFromEndpoint registers the bulk import and export HTTP handlers on the gateway mux
+// POST /things:import streams the things in the body to ImportThings and GET /things:export streams
+// ExportThings. Things are newline delimited JSON or CSV (ThingCSVColumns) with a text/csv Content-Type
+// or Accept header.
+func RegisterThingRPCBulkHandlerFromEndpoint(ctx context.Context, mux *runtime.ServeMux, endpoint string, opts []grpc.DialOption) error {
+
+	conn, err := grpc.Dial(endpoint, opts...)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	client := NewThingRPCClient(conn)
+	mux.Handle("POST", patternImportThings, func(w http.ResponseWriter, req *http.Request, pathParams map[string]string) {
+		importThingsHTTP(mux, client, w, req)
+	})
+	mux.Handle("GET", patternExportThings, func(w http.ResponseWriter, req *http.Request, pathParams map[string]string) {
+		exportThingsHTTP(mux, client, w, req)
+	})
+	return nil
+
+}
+
+// importThingsHTTP streams the things in the request body to ImportThings
+func importThingsHTTP(mux *runtime.ServeMux, client ThingRPCClient, w http.ResponseWriter, req *http.Request) {
+
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	inboundMarshaler, outboundMarshaler := runtime.MarshalerForRequest(mux, req)
+	rctx, err := runtime.AnnotateContext(ctx, mux, req)
+	if err != nil {
+		runtime.HTTPError(ctx, mux, outboundMarshaler, w, req, err)
+		return
+	}
+
+	var next func() (*Thing, error)
+	if strings.Contains(req.Header.Get("Content-Type"), mimeCSV) {
+		next = NewThingCSVReader(req.Body).Read
+	} else {
+		decoder := inboundMarshaler.NewDecoder(req.Body)
+		next = func() (*Thing, error) {
+			t := new(Thing)
+			return t, decoder.Decode(t)
+		}
+	}
+
+	stream, err := client.ImportThings(rctx)
+	if err != nil {
+		runtime.HTTPError(ctx, mux, outboundMarshaler, w, req, err)
+		return
+	}
+	for {
+		t, err := next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			cancel() // Abort the import
+			runtime.HTTPError(ctx, mux, outboundMarshaler, w, req, apperr.ErrInvalidArgument.Errorf("%v", err))
+			return
+		}
+		if err = stream.Send(t); err == io.EOF {
+			break // The server failed, the error is returned by CloseAndRecv
+		} else if err != nil {
+			runtime.HTTPError(ctx, mux, outboundMarshaler, w, req, err)
+			return
+		}
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		runtime.HTTPError(ctx, mux, outboundMarshaler, w, req, err)
+		return
+	}
+	runtime.ForwardResponseMessage(ctx, mux, outboundMarshaler, w, req, resp, mux.GetForwardResponseOptions()...)
+
+}
+
+// exportThingsHTTP streams the things from ExportThings to the response
+// Errors before the first thing is received are returned as usual, later errors are set in the X-Export-Error trailer.
+func exportThingsHTTP(mux *runtime.ServeMux, client ThingRPCClient, w http.ResponseWriter, req *http.Request) {
+
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	_, outboundMarshaler := runtime.MarshalerForRequest(mux, req)
+	rctx, err := runtime.AnnotateContext(ctx, mux, req)
+	if err != nil {
+		runtime.HTTPError(ctx, mux, outboundMarshaler, w, req, err)
+		return
+	}
+
+	var request ExportThingsRequest
+	if err = req.ParseForm(); err == nil {
+		err = runtime.PopulateQueryParameters(&request, req.Form, &utilities.DoubleArray{})
+	}
+	if err != nil {
+		runtime.HTTPError(ctx, mux, outboundMarshaler, w, req, apperr.ErrInvalidArgument.Errorf("%v", err))
+		return
+	}
+
+	stream, err := client.ExportThings(rctx, &request)
+	if err != nil {
+		runtime.HTTPError(ctx, mux, outboundMarshaler, w, req, err)
+		return
+	}
+	t, err := stream.Recv()
+	if err != nil && err != io.EOF {
+		runtime.HTTPError(ctx, mux, outboundMarshaler, w, req, err)
+		return
+	}
+
+	var write func(*Thing) error
+	var flush func() error
+	if strings.Contains(req.Header.Get("Accept"), mimeCSV) {
+		w.Header().Set("Content-Type", mimeCSV)
+		csvWriter := NewThingCSVWriter(w)
+		write = csvWriter.Write
+		flush = csvWriter.Flush
+	} else {
+		w.Header().Set("Content-Type", mimeNDJSON)
+		write = func(t *Thing) error {
+			b, err := outboundMarshaler.Marshal(t)
+			if err == nil {
+				_, err = w.Write(append(b, '\n'))
+			}
+			return err
+		}
+		flush = func() error { return nil }
+	}
+	w.Header().Set("Trailer", exportErrorTrailer)
+
+	for ; err == nil; t, err = stream.Recv() {
+		if err = write(t); err != nil {
+			break
+		}
+	}
+	if ferr := flush(); err == io.EOF {
+		err = ferr
+	}
+	if err != nil {
+		w.Header().Set(exportErrorTrailer, status.Convert(err).Message())
+	}
+
+}
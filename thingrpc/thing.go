@@ -2,12 +2,213 @@ package thingrpc
 
 import (
 	"context"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	"github.com/golang/protobuf/ptypes/timestamp"
+
+	"github.com/snowzach/gogrpcapi/store/query"
 )
 
 // ThingStore is the persistent store of things
 type ThingStore interface {
-	ThingGetById(context.Context, string) (*Thing, error)
+	// ThingGetById returns a thing, stores may leave out fields the caller does not need (see store.WithFields)
+	ThingGetById(context.Context, string, bool) (*Thing, error)
+	ThingGetAsOf(context.Context, string, time.Time, bool) (*Thing, error)
+	ThingListRevisions(context.Context, string, int64, int) ([]*ThingRevision, error)
+	// Changes with an etag return ErrEtagMismatch unless it matches the stored thing. The etag * matches any thing
+	// that exists and, except for ThingUndeleteById, is not deleted (ErrNotFound otherwise).
+	// ThingSave creates or replaces a thing, ErrNotFound is returned if the id is used by a deleted thing
 	ThingSave(context.Context, *Thing) (string, error)
-	ThingDeleteById(context.Context, string) error
-	ThingFind(context.Context) ([]*Thing, error)
+	// ThingCreate saves a new thing, ErrAlreadyExists is returned if the id is used (even by a deleted thing)
+	ThingCreate(context.Context, *Thing) (string, error)
+	ThingUpdate(context.Context, *Thing, []string) (*Thing, error)
+	ThingDeleteById(context.Context, string, string) error
+	// ThingUndeleteById restores a deleted thing, a thing that is not deleted is returned unchanged
+	ThingUndeleteById(context.Context, string, string) (*Thing, error)
+	ThingFind(context.Context, *query.Query) ([]*Thing, error)
+
+	// Batch operations run in a single transaction. They return an error per item and, unless
+	// allowPartial is set, nothing is changed if any item fails.
+	ThingBatchGet(context.Context, []string, bool) ([]*Thing, error)
+	ThingBatchSave(context.Context, []*Thing, bool) ([]error, error)
+	ThingBatchDelete(context.Context, []string, bool) ([]error, error)
+
+	// ThingWatch calls the function with each change made after the given sequence number (0 = now)
+	// until the context is canceled or the function returns an error
+	ThingWatch(context.Context, int64, func(int64, *ThingEvent) error) error
+
+	// ThingImport saves the things in one transaction replacing any existing things with the same ids
+	// regardless of their etags, deleted things are restored. Missing ids are generated.
+	ThingImport(context.Context, []*Thing) error
+	// ThingExport calls the function with every thing matching the query, in order, until it returns an error
+	ThingExport(context.Context, *query.Query, func(*Thing) error) error
+
+	// ThingAggregate counts the things matching the query (ignoring its order and cursor) and, if a group by field
+	// is given, the things with each value of the field. The query limit is the maximum number of groups.
+	ThingAggregate(context.Context, *query.Query, string) (*query.Aggregation, error)
+
+	// ThingSearch returns the things matching the query whose name matches the search text, ordered by
+	// ThingSearchOrderBy. Stores without full-text search return ErrUnimplemented.
+	ThingSearch(context.Context, *query.Query, string) ([]*ThingSearchResult, error)
+}
+
+// ThingSchema describes the fields of a thing that can be queried
+var ThingSchema = &query.Schema{
+	Key: "id",
+	Fields: map[string]query.FieldType{
+		"id":          query.TypeString,
+		"name":        query.TypeString,
+		"create_time": query.TypeTimestamp,
+		"update_time": query.TypeTimestamp,
+		"attributes":  query.TypeJSON,
+	},
+	Labels: "labels",
+}
+
+// ThingSearchOrderBy is the order of search results, the most relevant first
+var ThingSearchOrderBy = []query.OrderBy{{Field: "rank", Desc: true}, {Field: "id"}}
+
+// ThingUpdatePaths are the fields of a thing that can be used in an update mask
+var ThingUpdatePaths = map[string]struct{}{
+	"name":       struct{}{},
+	"labels":     struct{}{},
+	"attributes": struct{}{},
+}
+
+// ThingReadPaths are the fields of a thing that can be used in a read mask
+var ThingReadPaths = map[string]struct{}{
+	"id":          struct{}{},
+	"name":        struct{}{},
+	"etag":        struct{}{},
+	"create_time": struct{}{},
+	"update_time": struct{}{},
+	"delete_time": struct{}{},
+	"labels":      struct{}{},
+	"attributes":  struct{}{},
+}
+
+// ThingMask returns a copy of the thing with only the fields in the read paths set
+func ThingMask(t *Thing, paths []string) *Thing {
+	masked := new(Thing)
+	for _, path := range paths {
+		switch path {
+		case "id":
+			masked.Id = t.Id
+		case "name":
+			masked.Name = t.Name
+		case "etag":
+			masked.Etag = t.Etag
+		case "create_time":
+			masked.CreateTime = t.CreateTime
+		case "update_time":
+			masked.UpdateTime = t.UpdateTime
+		case "delete_time":
+			masked.DeleteTime = t.DeleteTime
+		case "labels":
+			masked.Labels = t.Labels
+		case "attributes":
+			masked.Attributes = t.Attributes
+		}
+	}
+	return masked
+}
+
+// ThingFieldValue returns the value of a queryable field, or a label (ex: labels.env), of a thing
+func ThingFieldValue(t *Thing, field string) interface{} {
+	switch field {
+	case "id":
+		return t.Id
+	case "name":
+		return t.Name
+	case "create_time":
+		return timestampValue(t.CreateTime)
+	case "update_time":
+		return timestampValue(t.UpdateTime)
+	case "attributes":
+		return structValue(t.Attributes)
+	}
+	// A label, nil if the thing does not have it
+	if strings.HasPrefix(field, "labels.") {
+		if v, ok := t.Labels[field[len("labels."):]]; ok {
+			return v
+		}
+		return nil
+	}
+	// A path below the attributes
+	if strings.HasPrefix(field, "attributes.") {
+		var v interface{} = structValue(t.Attributes)
+		for _, key := range strings.Split(field[len("attributes."):], ".") {
+			m, _ := v.(map[string]interface{})
+			v = m[key]
+		}
+		return v
+	}
+	return nil
+}
+
+// structValue converts a struct to a map of values decoded like encoding/json, nil is an empty map
+func structValue(s *structpb.Struct) map[string]interface{} {
+	m := make(map[string]interface{}, len(s.GetFields()))
+	for k, v := range s.GetFields() {
+		m[k] = jsonValue(v)
+	}
+	return m
+}
+
+// jsonValue converts a struct value to a string, float64, bool, nil, map[string]interface{} or []interface{}
+func jsonValue(v *structpb.Value) interface{} {
+	switch k := v.GetKind().(type) {
+	case *structpb.Value_StringValue:
+		return k.StringValue
+	case *structpb.Value_NumberValue:
+		return k.NumberValue
+	case *structpb.Value_BoolValue:
+		return k.BoolValue
+	case *structpb.Value_StructValue:
+		return structValue(k.StructValue)
+	case *structpb.Value_ListValue:
+		list := make([]interface{}, len(k.ListValue.GetValues()))
+		for i, item := range k.ListValue.GetValues() {
+			list[i] = jsonValue(item)
+		}
+		return list
+	}
+	return nil
+}
+
+// ProtoValue converts a value decoded like encoding/json (ex: from ThingFieldValue) to a struct value
+func ProtoValue(v interface{}) *structpb.Value {
+	switch v := v.(type) {
+	case string:
+		return &structpb.Value{Kind: &structpb.Value_StringValue{StringValue: v}}
+	case float64:
+		return &structpb.Value{Kind: &structpb.Value_NumberValue{NumberValue: v}}
+	case bool:
+		return &structpb.Value{Kind: &structpb.Value_BoolValue{BoolValue: v}}
+	case map[string]interface{}:
+		s := &structpb.Struct{Fields: make(map[string]*structpb.Value, len(v))}
+		for k, item := range v {
+			s.Fields[k] = ProtoValue(item)
+		}
+		return &structpb.Value{Kind: &structpb.Value_StructValue{StructValue: s}}
+	case []interface{}:
+		list := &structpb.ListValue{Values: make([]*structpb.Value, len(v))}
+		for i, item := range v {
+			list.Values[i] = ProtoValue(item)
+		}
+		return &structpb.Value{Kind: &structpb.Value_ListValue{ListValue: list}}
+	}
+	return &structpb.Value{Kind: &structpb.Value_NullValue{}}
+}
+
+// timestampValue converts a timestamp to a time, nil or invalid timestamps are the zero time
+func timestampValue(ts *timestamp.Timestamp) time.Time {
+	t, err := ptypes.Timestamp(ts)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
 }
@@ -3,6 +3,7 @@ package thingrpc
 import (
 	"testing"
 
+	structpb "github.com/golang/protobuf/ptypes/struct"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -14,3 +15,37 @@ func TestThingString(t *testing.T) {
 
 	assert.Equal(t, `id:"id1" name:"name1" `, thing.String())
 }
+
+func TestThingFieldValue(t *testing.T) {
+	thing := &Thing{
+		Id:   "id1",
+		Name: "name1",
+	}
+
+	for field := range ThingSchema.Fields {
+		assert.NotNil(t, ThingFieldValue(thing, field), field)
+	}
+	assert.Equal(t, "name1", ThingFieldValue(thing, "name"))
+	assert.Nil(t, ThingFieldValue(thing, "nope"))
+}
+
+func TestThingFieldValueAttributes(t *testing.T) {
+	thing := &Thing{
+		Attributes: &structpb.Struct{Fields: map[string]*structpb.Value{
+			"color": {Kind: &structpb.Value_StringValue{StringValue: "red"}},
+			"size": {Kind: &structpb.Value_StructValue{StructValue: &structpb.Struct{Fields: map[string]*structpb.Value{
+				"max": {Kind: &structpb.Value_NumberValue{NumberValue: 5}},
+			}}}},
+			"tags": {Kind: &structpb.Value_ListValue{ListValue: &structpb.ListValue{Values: []*structpb.Value{
+				{Kind: &structpb.Value_BoolValue{BoolValue: true}},
+				{Kind: &structpb.Value_NullValue{}},
+			}}}},
+		}},
+	}
+
+	assert.Equal(t, "red", ThingFieldValue(thing, "attributes.color"))
+	assert.Equal(t, 5.0, ThingFieldValue(thing, "attributes.size.max"))
+	assert.Equal(t, []interface{}{true, nil}, ThingFieldValue(thing, "attributes.tags"))
+	assert.Nil(t, ThingFieldValue(thing, "attributes.color.nope"))
+	assert.Nil(t, ThingFieldValue(thing, "attributes.nope"))
+}
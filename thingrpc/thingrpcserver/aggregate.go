@@ -0,0 +1,82 @@
+package thingrpcserver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/snowzach/gogrpcapi/apperr"
+	"github.com/snowzach/gogrpcapi/store/query"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+const (
+	defaultMaxGroups = 100
+	maxMaxGroups     = 1000
+)
+
+// ThingAggregate counts the things matching the request, optionally grouped by the value of a field
+func (s *thingRPCServer) ThingAggregate(ctx context.Context, request *thingrpc.ThingAggregateRequest) (*thingrpc.ThingAggregateResponse, error) {
+
+	q, err := thingAggregateQuery(request)
+	if err != nil {
+		return nil, apperr.ErrInvalidArgument.Errorf("%s", err)
+	}
+
+	ag, err := s.thingStore.ThingAggregate(ctx, q, request.GetGroupBy())
+	if err != nil {
+		return nil, err
+	}
+
+	response := &thingrpc.ThingAggregateResponse{
+		TotalCount: ag.Total,
+		Groups:     make([]*thingrpc.ThingGroupCount, len(ag.Groups)),
+	}
+	for i, g := range ag.Groups {
+		response.Groups[i] = &thingrpc.ThingGroupCount{
+			Value: thingrpc.ProtoValue(g.Value),
+			Count: g.Count,
+		}
+	}
+
+	return response, nil
+
+}
+
+// thingAggregateQuery validates an aggregate request and converts it to a store query, the limit is the number of groups
+func thingAggregateQuery(request *thingrpc.ThingAggregateRequest) (*query.Query, error) {
+
+	var err error
+	q := &query.Query{
+		ShowDeleted: request.GetShowDeleted(),
+	}
+
+	switch maxGroups := request.GetMaxGroups(); {
+	case maxGroups < 0:
+		return nil, fmt.Errorf("max_groups must not be negative")
+	case maxGroups == 0:
+		q.Limit = defaultMaxGroups
+	case maxGroups > maxMaxGroups:
+		q.Limit = maxMaxGroups
+	default:
+		q.Limit = int(maxGroups)
+	}
+
+	q.Filter, err = thingrpc.ThingSchema.ParseFilter(request.GetFilter())
+	if err != nil {
+		return nil, err
+	}
+
+	q.Labels, err = query.ParseSelector(request.GetLabelSelector())
+	if err != nil {
+		return nil, err
+	}
+
+	if request.GetGroupBy() != "" {
+		if err = thingrpc.ThingSchema.ParseGroupBy(request.GetGroupBy()); err != nil {
+			return nil, err
+		}
+	}
+
+	return q, nil
+
+}
@@ -0,0 +1,138 @@
+package thingrpcserver
+
+import (
+	"context"
+
+	"google.golang.org/grpc/status"
+
+	"github.com/snowzach/gogrpcapi/apperr"
+
+	"github.com/snowzach/gogrpcapi/store"
+	"github.com/snowzach/gogrpcapi/store/query"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+const maxBatchSize = 1000
+
+// BatchGetThings fetches things by ID
+// If allow_partial is set, missing things are reported per item instead of failing the request
+func (s *thingRPCServer) BatchGetThings(ctx context.Context, request *thingrpc.BatchGetThingsRequest) (*thingrpc.BatchThingsResponse, error) {
+
+	if err := batchSize(len(request.Ids)); err != nil {
+		return nil, err
+	}
+	for _, id := range request.Ids {
+		if id == "" {
+			return nil, apperr.ErrInvalidArgument.Errorf("Invalid ID")
+		}
+	}
+
+	bs, err := s.thingStore.ThingBatchGet(ctx, request.Ids, request.ShowDeleted)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]*thingrpc.Thing, len(bs))
+	for _, b := range bs {
+		found[b.Id] = b
+	}
+
+	response := &thingrpc.BatchThingsResponse{
+		Results: make([]*thingrpc.BatchThingResult, len(request.Ids)),
+	}
+	for i, id := range request.Ids {
+		result := &thingrpc.BatchThingResult{Id: id}
+		if b, ok := found[id]; ok {
+			result.Thing = b
+		} else if request.AllowPartial {
+			result.Error = status.Convert(store.ErrNotFound).Proto()
+		} else {
+			return nil, store.ErrNotFound.Errorf("%s: %s", store.ErrNotFound.Message, id)
+		}
+		response.Results[i] = result
+	}
+
+	return response, nil
+
+}
+
+// BatchSaveThings creates or updates things in one transaction
+// If allow_partial is set, the things that could be saved are committed and failures are reported per item
+func (s *thingRPCServer) BatchSaveThings(ctx context.Context, request *thingrpc.BatchSaveThingsRequest) (*thingrpc.BatchThingsResponse, error) {
+
+	if err := batchSize(len(request.Things)); err != nil {
+		return nil, err
+	}
+	for _, b := range request.Things {
+		if b == nil {
+			return nil, apperr.ErrInvalidArgument.Errorf("Invalid thing")
+		}
+		if err := query.ValidateLabels(b.Labels); err != nil {
+			return nil, apperr.ErrInvalidArgument.Errorf("%s: %s", err, b.Id)
+		}
+	}
+
+	errs, err := s.thingStore.ThingBatchSave(ctx, request.Things, request.AllowPartial)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(request.Things))
+	for i, b := range request.Things {
+		ids[i] = b.Id // The store assigns missing IDs
+	}
+	return s.batchResponse(ids, errs, request.AllowPartial)
+
+}
+
+// BatchDeleteThings deletes things in one transaction
+// If allow_partial is set, the deletes that succeeded are committed and failures are reported per item
+func (s *thingRPCServer) BatchDeleteThings(ctx context.Context, request *thingrpc.BatchDeleteThingsRequest) (*thingrpc.BatchThingsResponse, error) {
+
+	if err := batchSize(len(request.Ids)); err != nil {
+		return nil, err
+	}
+	for _, id := range request.Ids {
+		if id == "" {
+			return nil, apperr.ErrInvalidArgument.Errorf("Invalid ID")
+		}
+	}
+
+	errs, err := s.thingStore.ThingBatchDelete(ctx, request.Ids, request.AllowPartial)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.batchResponse(request.Ids, errs, request.AllowPartial)
+
+}
+
+// batchSize checks the number of items in a batch request
+func batchSize(size int) error {
+	if size > maxBatchSize {
+		return apperr.ErrInvalidArgument.Errorf("A batch may contain at most %d items", maxBatchSize)
+	}
+	return nil
+}
+
+// batchResponse builds the per item results of a batch change
+// If partial success is not allowed, the error of the first failed item is returned
+func (s *thingRPCServer) batchResponse(ids []string, errs []error, allowPartial bool) (*thingrpc.BatchThingsResponse, error) {
+
+	response := &thingrpc.BatchThingsResponse{
+		Results: make([]*thingrpc.BatchThingResult, len(ids)),
+	}
+	for i, id := range ids {
+		result := &thingrpc.BatchThingResult{Id: id}
+		if i < len(errs) && errs[i] != nil {
+			st := s.publicStatus(errs[i])
+			if !allowPartial {
+				return nil, statusWithMessage(st, "%s: %s", st.Message(), id)
+			}
+			result.Error = st.Proto()
+		}
+		response.Results[i] = result
+	}
+	return response, nil
+
+}
@@ -0,0 +1,87 @@
+package thingrpcserver
+
+import (
+	"io"
+
+	"github.com/snowzach/gogrpcapi/apperr"
+	"github.com/snowzach/gogrpcapi/store/query"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+// importBatchSize is the number of streamed things saved per store transaction
+const importBatchSize = 1000
+
+// ImportThings saves the streamed things, replacing existing things with the same ids
+// Things are saved in batches as they arrive, if a batch fails the earlier batches remain saved.
+func (s *thingRPCServer) ImportThings(stream thingrpc.ThingRPC_ImportThingsServer) error {
+
+	ctx := stream.Context()
+
+	var imported int64
+	batch := make([]*thingrpc.Thing, 0, importBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := s.thingStore.ThingImport(ctx, batch); err != nil {
+			st := s.publicStatus(err)
+			return statusWithMessage(st, "%s (imported %d things)", st.Message(), imported)
+		}
+		imported += int64(len(batch))
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		b, err := stream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if err = query.ValidateLabels(b.Labels); err != nil {
+			return apperr.ErrInvalidArgument.Errorf("%s (imported %d things)", err, imported)
+		}
+		if batch = append(batch, b); len(batch) == importBatchSize {
+			if err = flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	return stream.SendAndClose(&thingrpc.ImportThingsResponse{
+		Imported: imported,
+	})
+
+}
+
+// ExportThings streams every thing matching the request in id order
+func (s *thingRPCServer) ExportThings(request *thingrpc.ExportThingsRequest, stream thingrpc.ThingRPC_ExportThingsServer) error {
+
+	var err error
+	q := &query.Query{
+		ShowDeleted: request.GetShowDeleted(),
+	}
+	if q.Filter, err = thingrpc.ThingSchema.ParseFilter(request.GetFilter()); err != nil {
+		return apperr.ErrInvalidArgument.Errorf("%s", err)
+	}
+	if q.Labels, err = query.ParseSelector(request.GetLabelSelector()); err != nil {
+		return apperr.ErrInvalidArgument.Errorf("%s", err)
+	}
+	if q.OrderBy, err = thingrpc.ThingSchema.ParseOrderBy(""); err != nil {
+		return err
+	}
+
+	err = s.thingStore.ThingExport(stream.Context(), q, stream.Send)
+	if stream.Context().Err() != nil {
+		return nil // The client went away
+	} else if err != nil {
+		return err
+	}
+
+	return nil
+
+}
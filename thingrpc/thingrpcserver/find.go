@@ -0,0 +1,88 @@
+package thingrpcserver
+
+import (
+	"fmt"
+
+	"github.com/snowzach/gogrpcapi/store/query"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// pageSize returns the number of results to return for a requested page size
+func pageSize(requested int32) (int, error) {
+	switch {
+	case requested < 0:
+		return 0, fmt.Errorf("page_size must not be negative")
+	case requested == 0:
+		return defaultPageSize, nil
+	case requested > maxPageSize:
+		return maxPageSize, nil
+	}
+	return int(requested), nil
+}
+
+// thingFindQuery validates a find request and converts it to a store query
+func thingFindQuery(request *thingrpc.ThingFindRequest) (*query.Query, error) {
+
+	var err error
+	q := &query.Query{
+		ShowDeleted: request.GetShowDeleted(),
+	}
+
+	q.Limit, err = pageSize(request.GetPageSize())
+	if err != nil {
+		return nil, err
+	}
+
+	q.Filter, err = thingrpc.ThingSchema.ParseFilter(request.GetFilter())
+	if err != nil {
+		return nil, err
+	}
+
+	q.Labels, err = query.ParseSelector(request.GetLabelSelector())
+	if err != nil {
+		return nil, err
+	}
+
+	q.OrderBy, err = thingrpc.ThingSchema.ParseOrderBy(request.GetOrderBy())
+	if err != nil {
+		return nil, err
+	}
+
+	q.Fields, err = readMask(request.GetReadMask().GetPaths())
+	if err != nil {
+		return nil, err
+	}
+
+	if request.GetPageToken() != "" {
+		q.After, err = thingrpc.ThingSchema.DecodePageToken(request.GetPageToken(), q.OrderBy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return q, nil
+
+}
+
+// readMask validates the paths of a read mask and removes duplicates, nil means all fields
+func readMask(paths []string) ([]string, error) {
+
+	var ret []string
+	seen := make(map[string]struct{})
+	for _, path := range paths {
+		if _, ok := thingrpc.ThingReadPaths[path]; !ok {
+			return nil, fmt.Errorf("Invalid read_mask path: %s", path)
+		}
+		if _, ok := seen[path]; !ok {
+			seen[path] = struct{}{}
+			ret = append(ret, path)
+		}
+	}
+	return ret, nil
+
+}
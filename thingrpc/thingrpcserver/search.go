@@ -0,0 +1,87 @@
+package thingrpcserver
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/snowzach/gogrpcapi/apperr"
+	"github.com/snowzach/gogrpcapi/store/query"
+	"github.com/snowzach/gogrpcapi/thingrpc"
+)
+
+// ThingSearch finds things by the words in their name, the most relevant first
+func (s *thingRPCServer) ThingSearch(ctx context.Context, request *thingrpc.ThingSearchRequest) (*thingrpc.ThingSearchResponse, error) {
+
+	q, err := thingSearchQuery(request)
+	if err != nil {
+		return nil, apperr.ErrInvalidArgument.Errorf("%s", err)
+	}
+	pageSize := q.Limit
+	q.Limit++ // Fetch one extra to see if there is another page
+
+	results, err := s.thingStore.ThingSearch(ctx, q, request.GetQ())
+	if err != nil {
+		return nil, err
+	}
+
+	response := &thingrpc.ThingSearchResponse{
+		Results: results,
+	}
+
+	// There are more results, return a token pointing at the last result
+	if len(results) > pageSize {
+		response.Results = results[:pageSize]
+		last := response.Results[pageSize-1]
+		response.NextPageToken = query.EncodePageToken(q.OrderBy, []interface{}{
+			strconv.FormatFloat(float64(last.GetRank()), 'g', -1, 32),
+			last.GetThing().GetId(),
+		})
+	}
+
+	return response, nil
+
+}
+
+// thingSearchQuery validates a search request and converts it to a store query ordered by relevance
+func thingSearchQuery(request *thingrpc.ThingSearchRequest) (*query.Query, error) {
+
+	var err error
+	q := &query.Query{
+		OrderBy:     thingrpc.ThingSearchOrderBy,
+		ShowDeleted: request.GetShowDeleted(),
+	}
+
+	if request.GetQ() == "" {
+		return nil, fmt.Errorf("q is required")
+	}
+
+	q.Limit, err = pageSize(request.GetPageSize())
+	if err != nil {
+		return nil, err
+	}
+
+	q.Filter, err = thingrpc.ThingSchema.ParseFilter(request.GetFilter())
+	if err != nil {
+		return nil, err
+	}
+
+	q.Labels, err = query.ParseSelector(request.GetLabelSelector())
+	if err != nil {
+		return nil, err
+	}
+
+	if request.GetPageToken() != "" {
+		q.After, err = thingrpc.ThingSchema.DecodePageToken(request.GetPageToken(), q.OrderBy)
+		if err != nil {
+			return nil, err
+		}
+		// The rank is not a field of the schema so check it here
+		if _, err = strconv.ParseFloat(q.After[0].(string), 32); err != nil {
+			return nil, fmt.Errorf("invalid page_token")
+		}
+	}
+
+	return q, nil
+
+}
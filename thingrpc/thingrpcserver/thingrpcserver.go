@@ -2,16 +2,29 @@ package thingrpcserver
 
 import (
 	"context"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 
+	"github.com/golang/protobuf/ptypes"
 	emptypb "github.com/golang/protobuf/ptypes/empty"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
+	"github.com/snowzach/gogrpcapi/apperr"
 	"github.com/snowzach/gogrpcapi/store"
+	"github.com/snowzach/gogrpcapi/store/query"
 	"github.com/snowzach/gogrpcapi/thingrpc"
 )
 
+// thingRPCServer implements thingrpc.ThingRPCServer
+// Store errors are catalog errors (see apperr) and are returned as is, the server logs any other error and
+// returns apperr.ErrInternal in its place.
 type thingRPCServer struct {
+	logger     *zap.SugaredLogger
 	thingStore thingrpc.ThingStore
 }
 
@@ -25,54 +38,208 @@ func New(ts thingrpc.ThingStore) (thingrpc.ThingRPCServer, error) {
 func newServer(ts thingrpc.ThingStore) (*thingRPCServer, error) {
 
 	return &thingRPCServer{
+		logger:     zap.S().With("package", "thingrpcserver"),
 		thingStore: ts,
 	}, nil
 
 }
 
-// AuthFuncOverride is used if you want to override default authentication for any endpoint
-// This disables all authentication for any thingRPC calls
-func (s *thingRPCServer) AuthFuncOverride(ctx context.Context, fullMethodName string) (context.Context, error) {
-	return ctx, nil
-}
+// ThingFind returns a page of things
+func (s *thingRPCServer) ThingFind(ctx context.Context, request *thingrpc.ThingFindRequest) (*thingrpc.ThingFindResponse, error) {
 
-// ThingFind returns all things
-func (s *thingRPCServer) ThingFind(ctx context.Context, _ *emptypb.Empty) (*thingrpc.ThingFindResponse, error) {
+	q, err := thingFindQuery(request)
+	if err != nil {
+		return nil, apperr.ErrInvalidArgument.Errorf("%s", err)
+	}
+	pageSize := q.Limit
+	q.Limit++ // Fetch one extra to see if there is another page
 
-	bs, err := s.thingStore.ThingFind(ctx)
+	bs, err := s.thingStore.ThingFind(ctx, q)
 	if err != nil {
-		return nil, grpc.Errorf(codes.Internal, "%s", err)
+		return nil, err
 	}
 
-	return &thingrpc.ThingFindResponse{
+	response := &thingrpc.ThingFindResponse{
 		Data: bs,
-	}, nil
+	}
+
+	// There are more results, return a token pointing at the last thing
+	if len(bs) > pageSize {
+		response.Data = bs[:pageSize]
+		last := response.Data[pageSize-1]
+		after := make([]interface{}, len(q.OrderBy))
+		for i, o := range q.OrderBy {
+			after[i] = thingrpc.ThingFieldValue(last, o.Field)
+		}
+		response.NextPageToken = query.EncodePageToken(q.OrderBy, after)
+	}
+
+	if request.GetIncludeTotalSize() {
+		ag, err := s.thingStore.ThingAggregate(ctx, &query.Query{
+			Filter:      q.Filter,
+			Labels:      q.Labels,
+			ShowDeleted: q.ShowDeleted,
+		}, "")
+		if err != nil {
+			return nil, err
+		}
+		response.TotalSize = ag.Total
+	}
+
+	// Trim after the page token is built from the order by fields
+	if q.Fields != nil {
+		for i, b := range response.Data {
+			response.Data[i] = thingrpc.ThingMask(b, q.Fields)
+		}
+	}
+
+	return response, nil
 
 }
 
 // ThingGet fetches a thing by ID
-func (s *thingRPCServer) ThingGet(ctx context.Context, request *thingrpc.ThingId) (*thingrpc.Thing, error) {
+func (s *thingRPCServer) ThingGet(ctx context.Context, request *thingrpc.ThingGetRequest) (*thingrpc.Thing, error) {
 
 	if request.Id == "" {
-		return nil, grpc.Errorf(codes.Internal, "Invalid ID")
+		return nil, apperr.ErrInvalidArgument.Errorf("Invalid ID")
 	}
-	b, err := s.thingStore.ThingGetById(ctx, request.Id)
-	if err == store.ErrNotFound {
-		return nil, grpc.Errorf(codes.NotFound, "Not Found")
-	} else if err != nil {
-		return nil, grpc.Errorf(codes.Internal, "%s", err)
+	paths, err := readMask(request.GetReadMask().GetPaths())
+	if err != nil {
+		return nil, apperr.ErrInvalidArgument.Errorf("%s", err)
+	}
+	var b *thingrpc.Thing
+	if request.AsOf != nil {
+		asOf, terr := ptypes.Timestamp(request.AsOf)
+		if terr != nil {
+			return nil, apperr.ErrInvalidArgument.Errorf("Invalid as_of: %s", terr)
+		}
+		b, err = s.thingStore.ThingGetAsOf(ctx, request.Id, asOf, request.ShowDeleted)
+	} else {
+		if paths != nil {
+			ctx = store.WithFields(ctx, paths)
+		}
+		b, err = s.thingStore.ThingGetById(ctx, request.Id, request.ShowDeleted)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if paths != nil {
+		b = thingrpc.ThingMask(b, paths)
 	}
 
 	return b, nil
 
 }
 
+// ThingListRevisions returns the revision history of a thing, newest first
+func (s *thingRPCServer) ThingListRevisions(ctx context.Context, request *thingrpc.ThingListRevisionsRequest) (*thingrpc.ThingListRevisionsResponse, error) {
+
+	if request.Id == "" {
+		return nil, apperr.ErrInvalidArgument.Errorf("Invalid ID")
+	}
+
+	limit, err := pageSize(request.PageSize)
+	if err != nil {
+		return nil, apperr.ErrInvalidArgument.Errorf("%s", err)
+	}
+
+	var before int64
+	if request.PageToken != "" {
+		token, err := base64.RawURLEncoding.DecodeString(request.PageToken)
+		if err == nil {
+			before, err = strconv.ParseInt(string(token), 10, 64)
+		}
+		if err != nil || before <= 0 {
+			return nil, apperr.ErrInvalidArgument.Errorf("invalid page_token")
+		}
+	}
+
+	revisions, err := s.thingStore.ThingListRevisions(ctx, request.Id, before, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &thingrpc.ThingListRevisionsResponse{
+		Data: revisions,
+	}
+
+	// There are more results, return a token pointing at the last revision
+	if len(revisions) > limit {
+		response.Data = revisions[:limit]
+		response.NextPageToken = base64.RawURLEncoding.EncodeToString([]byte(response.Data[limit-1].Revision))
+	}
+
+	return response, nil
+
+}
+
+// ThingWatch streams changes to things until the client disconnects
+func (s *thingRPCServer) ThingWatch(request *thingrpc.ThingWatchRequest, stream thingrpc.ThingRPC_ThingWatchServer) error {
+
+	var after int64
+	if request.ResumeToken != "" {
+		token, err := base64.RawURLEncoding.DecodeString(request.ResumeToken)
+		if err == nil {
+			after, err = strconv.ParseInt(string(token), 10, 64)
+		}
+		if err != nil || after <= 0 {
+			return apperr.ErrInvalidArgument.Errorf("invalid resume_token")
+		}
+	}
+
+	err := s.thingStore.ThingWatch(stream.Context(), after, func(seq int64, event *thingrpc.ThingEvent) error {
+		event.ResumeToken = base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(seq, 10)))
+		return stream.Send(event)
+	})
+	if stream.Context().Err() != nil {
+		return nil // The client went away
+	} else if err != nil {
+		return err
+	}
+
+	return nil
+
+}
+
 // ThingSave creates or updates a thing
 func (s *thingRPCServer) ThingSave(ctx context.Context, b *thingrpc.Thing) (*thingrpc.ThingId, error) {
 
+	if err := query.ValidateLabels(b.Labels); err != nil {
+		return nil, apperr.ErrInvalidArgument.Errorf("%s", err)
+	}
+	ctx, err := idempotencyContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.Etag, err = requestEtag(ctx, b.Etag); err != nil {
+		return nil, err
+	}
 	thingID, err := s.thingStore.ThingSave(ctx, b)
 	if err != nil {
-		return nil, grpc.Errorf(codes.Internal, "%s", err)
+		return nil, err
+	}
+
+	return &thingrpc.ThingId{
+		Id: thingID,
+	}, nil
+
+}
+
+// ThingCreate creates a new thing
+func (s *thingRPCServer) ThingCreate(ctx context.Context, b *thingrpc.Thing) (*thingrpc.ThingId, error) {
+
+	if err := query.ValidateLabels(b.Labels); err != nil {
+		return nil, apperr.ErrInvalidArgument.Errorf("%s", err)
+	}
+	ctx, err := idempotencyContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	thingID, err := s.thingStore.ThingCreate(ctx, b)
+	if err != nil {
+		return nil, err
 	}
 
 	return &thingrpc.ThingId{
@@ -81,17 +248,181 @@ func (s *thingRPCServer) ThingSave(ctx context.Context, b *thingrpc.Thing) (*thi
 
 }
 
+// ThingReplace replaces every field of an existing thing
+func (s *thingRPCServer) ThingReplace(ctx context.Context, b *thingrpc.Thing) (*thingrpc.Thing, error) {
+
+	if b.Id == "" {
+		return nil, apperr.ErrInvalidArgument.Errorf("Invalid ID")
+	}
+	if err := query.ValidateLabels(b.Labels); err != nil {
+		return nil, apperr.ErrInvalidArgument.Errorf("%s", err)
+	}
+
+	var err error
+	if b.Etag, err = requestEtag(ctx, b.Etag); err != nil {
+		return nil, err
+	}
+	t, err := s.thingStore.ThingUpdate(ctx, b, allThingUpdatePaths())
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+
+}
+
+// ThingUpdate updates the fields of a thing in the update mask
+func (s *thingRPCServer) ThingUpdate(ctx context.Context, request *thingrpc.ThingUpdateRequest) (*thingrpc.Thing, error) {
+
+	if request.Thing == nil || request.Thing.Id == "" {
+		return nil, apperr.ErrInvalidArgument.Errorf("Invalid ID")
+	}
+	if err := query.ValidateLabels(request.Thing.Labels); err != nil {
+		return nil, apperr.ErrInvalidArgument.Errorf("%s", err)
+	}
+
+	var paths []string
+	if request.UpdateMask != nil && len(request.UpdateMask.Paths) > 0 {
+		seen := make(map[string]struct{})
+		for _, path := range request.UpdateMask.Paths {
+			if _, ok := thingrpc.ThingUpdatePaths[path]; !ok {
+				return nil, apperr.ErrInvalidArgument.Errorf("Invalid update_mask path: %s", path)
+			}
+			if _, ok := seen[path]; !ok {
+				seen[path] = struct{}{}
+				paths = append(paths, path)
+			}
+		}
+	} else {
+		// No mask, update everything
+		paths = allThingUpdatePaths()
+	}
+
+	var err error
+	if request.Thing.Etag, err = requestEtag(ctx, request.Thing.Etag); err != nil {
+		return nil, err
+	}
+	b, err := s.thingStore.ThingUpdate(ctx, request.Thing, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+
+}
+
 // ThingDelete deletes a thing
-func (s *thingRPCServer) ThingDelete(ctx context.Context, request *thingrpc.ThingId) (*emptypb.Empty, error) {
+func (s *thingRPCServer) ThingDelete(ctx context.Context, request *thingrpc.ThingDeleteRequest) (*emptypb.Empty, error) {
 
 	if request.Id == "" {
-		return nil, grpc.Errorf(codes.Internal, "Invalid ID")
+		return nil, apperr.ErrInvalidArgument.Errorf("Invalid ID")
+	}
+	ctx, err := idempotencyContext(ctx)
+	if err != nil {
+		return nil, err
 	}
-	err := s.thingStore.ThingDeleteById(ctx, request.Id)
+	etag, err := requestEtag(ctx, request.Etag)
 	if err != nil {
-		return nil, grpc.Errorf(codes.Internal, "%s", err)
+		return nil, err
+	}
+	err = s.thingStore.ThingDeleteById(ctx, request.Id, etag)
+	if err != nil {
+		return nil, err
 	}
 
 	return &emptypb.Empty{}, nil
 
 }
+
+// ThingUndelete restores a deleted thing
+func (s *thingRPCServer) ThingUndelete(ctx context.Context, request *thingrpc.ThingUndeleteRequest) (*thingrpc.Thing, error) {
+
+	if request.Id == "" {
+		return nil, apperr.ErrInvalidArgument.Errorf("Invalid ID")
+	}
+	etag, err := requestEtag(ctx, request.Etag)
+	if err != nil {
+		return nil, err
+	}
+	b, err := s.thingStore.ThingUndeleteById(ctx, request.Id, etag)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+
+}
+
+// allThingUpdatePaths returns every update path in order
+func allThingUpdatePaths() []string {
+
+	paths := make([]string, 0, len(thingrpc.ThingUpdatePaths))
+	for path := range thingrpc.ThingUpdatePaths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+
+}
+
+// publicStatus returns the status sent to clients for a store error that is not returned as the request error
+// (ex: a batch item), errors that could leak internal details are logged and replaced by apperr.ErrInternal
+func (s *thingRPCServer) publicStatus(err error) *status.Status {
+
+	public, internal := apperr.Public(err)
+	if internal {
+		s.logger.Errorw("Store error", "error", err)
+	}
+	return status.Convert(public)
+
+}
+
+// statusWithMessage returns the status as an error with another message, keeping its code and details
+func statusWithMessage(st *status.Status, format string, args ...interface{}) error {
+	p := st.Proto()
+	p.Message = fmt.Sprintf(format, args...)
+	return status.ErrorProto(p)
+}
+
+// maxIdempotencyKeyLength is the longest idempotency key accepted
+const maxIdempotencyKeyLength = 255
+
+// idempotencyContext adds the idempotency-key metadata, if any, to the context so the store replays retried changes
+func idempotencyContext(ctx context.Context) (context.Context, error) {
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("idempotency-key"); len(values) > 0 && values[0] != "" {
+			if len(values[0]) > maxIdempotencyKeyLength {
+				return nil, apperr.ErrInvalidArgument.Errorf("Invalid idempotency-key")
+			}
+			return store.WithIdempotencyKey(ctx, values[0]), nil
+		}
+	}
+	return ctx, nil
+
+}
+
+// requestEtag returns the etag of the request or falls back to an If-Match header passed as metadata
+// If-Match: * returns store.EtagAny so the change fails if the thing does not exist. Weak etags never match If-Match.
+func requestEtag(ctx context.Context, etag string) (string, error) {
+
+	if etag != "" {
+		return etag, nil
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("if-match"); len(values) > 0 {
+			etag = strings.TrimSpace(values[0])
+			if etag == "*" {
+				return store.EtagAny, nil
+			}
+			if strings.HasPrefix(etag, "W/") {
+				return "", apperr.ErrEtagMismatch.Errorf("Weak etags can not be used with If-Match")
+			}
+			return strings.Trim(etag, `"`), nil
+		}
+	}
+
+	return "", nil
+
+}
@@ -2,13 +2,27 @@ package thingrpcserver
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/golang/protobuf/ptypes"
+	structpb "github.com/golang/protobuf/ptypes/struct"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/genproto/protobuf/field_mask"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
-	"github.com/snowzach/gogrpcapi/thingrpc"
 	"github.com/snowzach/gogrpcapi/mocks"
+	"github.com/snowzach/gogrpcapi/store"
+	"github.com/snowzach/gogrpcapi/store/query"
+	"github.com/snowzach/gogrpcapi/thingrpc"
 )
 
 func TestServerThingPost(t *testing.T) {
@@ -56,11 +70,233 @@ func TestServerThingGetAll(t *testing.T) {
 	}
 
 	// Mock call to item store
-	ts.On("ThingFind", mock.AnythingOfType("*context.emptyCtx")).Once().Return(i, nil)
+	ts.On("ThingFind", mock.AnythingOfType("*context.emptyCtx"), &query.Query{
+		OrderBy: []query.OrderBy{{Field: "id"}},
+		Limit:   defaultPageSize + 1,
+	}).Once().Return(i, nil)
 
 	response, err := s.ThingFind(context.Background(), nil)
 	assert.Nil(t, err)
 	assert.Equal(t, i, response.Data)
+	assert.Equal(t, "", response.NextPageToken)
+
+	// Check remaining expectations
+	ts.AssertExpectations(t)
+
+}
+
+func TestServerThingFindPage(t *testing.T) {
+
+	// Mock Store and server
+	ts := new(mocks.ThingStore)
+	s, err := New(ts)
+	assert.Nil(t, err)
+
+	// Create Item
+	i := []*thingrpc.Thing{
+		&thingrpc.Thing{
+			Id:   "id1",
+			Name: "name1",
+		},
+		&thingrpc.Thing{
+			Id:   "id2",
+			Name: "name2",
+		},
+	}
+
+	orderBy := []query.OrderBy{{Field: "name", Desc: true}, {Field: "id"}}
+
+	// Mock call to item store, one extra is requested to detect the next page
+	ts.On("ThingFind", mock.AnythingOfType("*context.emptyCtx"), &query.Query{
+		OrderBy: orderBy,
+		Limit:   2,
+	}).Once().Return(i, nil)
+
+	response, err := s.ThingFind(context.Background(), &thingrpc.ThingFindRequest{PageSize: 1, OrderBy: "name desc"})
+	assert.Nil(t, err)
+	assert.Equal(t, i[:1], response.Data)
+	assert.Equal(t, query.EncodePageToken(orderBy, []interface{}{"name1", "id1"}), response.NextPageToken)
+
+	// Fetch the next page using the token
+	token := response.NextPageToken
+	ts.On("ThingFind", mock.AnythingOfType("*context.emptyCtx"), &query.Query{
+		OrderBy: orderBy,
+		After:   []interface{}{"name1", "id1"},
+		Limit:   2,
+	}).Once().Return(i[1:], nil)
+
+	response, err = s.ThingFind(context.Background(), &thingrpc.ThingFindRequest{PageSize: 1, OrderBy: "name desc", PageToken: token})
+	assert.Nil(t, err)
+	assert.Equal(t, i[1:], response.Data)
+	assert.Equal(t, "", response.NextPageToken)
+
+	// A token is only valid for the same order
+	_, err = s.ThingFind(context.Background(), &thingrpc.ThingFindRequest{PageSize: 1, OrderBy: "name", PageToken: token})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Check remaining expectations
+	ts.AssertExpectations(t)
+
+}
+
+func TestServerThingFindFilter(t *testing.T) {
+
+	// Mock Store and server
+	ts := new(mocks.ThingStore)
+	s, err := New(ts)
+	assert.Nil(t, err)
+
+	// Mock call to item store
+	ts.On("ThingFind", mock.AnythingOfType("*context.emptyCtx"), &query.Query{
+		Filter:      &query.Compare{Field: "name", Op: query.OpEq, Value: "name1"},
+		OrderBy:     []query.OrderBy{{Field: "id"}},
+		Limit:       defaultPageSize + 1,
+		ShowDeleted: true,
+	}).Once().Return([]*thingrpc.Thing{}, nil)
+
+	_, err = s.ThingFind(context.Background(), &thingrpc.ThingFindRequest{Filter: `name = "name1"`, ShowDeleted: true})
+	assert.Nil(t, err)
+
+	// Invalid filters are rejected before calling the store
+	_, err = s.ThingFind(context.Background(), &thingrpc.ThingFindRequest{Filter: `name = `})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Check remaining expectations
+	ts.AssertExpectations(t)
+
+}
+
+func TestServerThingFindLabels(t *testing.T) {
+
+	// Mock Store and server
+	ts := new(mocks.ThingStore)
+	s, err := New(ts)
+	assert.Nil(t, err)
+
+	// Mock call to item store
+	ts.On("ThingFind", mock.AnythingOfType("*context.emptyCtx"), &query.Query{
+		Labels: query.Selector{
+			{Key: "env", Op: query.SelectorEquals, Values: []string{"prod"}},
+			{Key: "tier", Op: query.SelectorIn, Values: []string{"a", "b"}},
+		},
+		OrderBy: []query.OrderBy{{Field: "id"}},
+		Limit:   defaultPageSize + 1,
+	}).Once().Return([]*thingrpc.Thing{}, nil)
+
+	_, err = s.ThingFind(context.Background(), &thingrpc.ThingFindRequest{LabelSelector: "env=prod,tier in (a,b)"})
+	assert.Nil(t, err)
+
+	// Invalid selectors and labels are rejected before calling the store
+	_, err = s.ThingFind(context.Background(), &thingrpc.ThingFindRequest{LabelSelector: "env in prod"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = s.ThingSave(context.Background(), &thingrpc.Thing{Id: "id1", Labels: map[string]string{"env": "not valid"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Check remaining expectations
+	ts.AssertExpectations(t)
+
+}
+
+func TestServerThingAggregate(t *testing.T) {
+
+	// Mock Store and server
+	ts := new(mocks.ThingStore)
+	s, err := New(ts)
+	assert.Nil(t, err)
+
+	ts.On("ThingAggregate", mock.AnythingOfType("*context.emptyCtx"), &query.Query{
+		Filter: &query.Compare{Field: "name", Op: query.OpNe, Value: "b"},
+		Limit:  5,
+	}, "labels.env").Once().Return(&query.Aggregation{Total: 3, Groups: []query.Group{{Value: "prod", Count: 2}, {Value: nil, Count: 1}}}, nil)
+
+	response, err := s.ThingAggregate(context.Background(), &thingrpc.ThingAggregateRequest{Filter: `name != "b"`, GroupBy: "labels.env", MaxGroups: 5})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), response.TotalCount)
+	if assert.Len(t, response.Groups, 2) {
+		assert.Equal(t, "prod", response.Groups[0].Value.GetStringValue())
+		assert.Equal(t, int64(2), response.Groups[0].Count)
+		assert.IsType(t, &structpb.Value_NullValue{}, response.Groups[1].Value.Kind)
+	}
+
+	// Invalid requests are rejected before calling the store
+	for _, request := range []*thingrpc.ThingAggregateRequest{{GroupBy: "create_time"}, {GroupBy: "nope"}, {MaxGroups: -1}, {Filter: "name ="}} {
+		_, err = s.ThingAggregate(context.Background(), request)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), request.String())
+	}
+
+	// The total size of a find counts every page
+	ts.On("ThingFind", mock.AnythingOfType("*context.emptyCtx"), &query.Query{
+		OrderBy:     []query.OrderBy{{Field: "id"}},
+		Limit:       2,
+		ShowDeleted: true,
+	}).Once().Return([]*thingrpc.Thing{{Id: "id1"}, {Id: "id2"}}, nil)
+	ts.On("ThingAggregate", mock.AnythingOfType("*context.emptyCtx"), &query.Query{ShowDeleted: true}, "").Once().Return(&query.Aggregation{Total: 7}, nil)
+
+	found, err := s.ThingFind(context.Background(), &thingrpc.ThingFindRequest{PageSize: 1, ShowDeleted: true, IncludeTotalSize: true})
+	assert.Nil(t, err)
+	assert.Len(t, found.Data, 1)
+	assert.Equal(t, int64(7), found.TotalSize)
+
+	// Check remaining expectations
+	ts.AssertExpectations(t)
+
+}
+
+func TestServerThingSearch(t *testing.T) {
+
+	// Mock Store and server
+	ts := new(mocks.ThingStore)
+	s, err := New(ts)
+	assert.Nil(t, err)
+
+	ts.On("ThingSearch", mock.AnythingOfType("*context.emptyCtx"), &query.Query{
+		OrderBy: thingrpc.ThingSearchOrderBy,
+		Limit:   2,
+	}, "red box").Once().Return([]*thingrpc.ThingSearchResult{
+		{Thing: &thingrpc.Thing{Id: "id1", Name: "red box"}, Rank: 0.1, Snippet: "<b>red</b> <b>box</b>"},
+		{Thing: &thingrpc.Thing{Id: "id2", Name: "big red box"}, Rank: 0.05, Snippet: "big <b>red</b> <b>box</b>"},
+	}, nil)
+
+	response, err := s.ThingSearch(context.Background(), &thingrpc.ThingSearchRequest{Q: "red box", PageSize: 1})
+	assert.Nil(t, err)
+	if assert.Len(t, response.Results, 1) {
+		assert.Equal(t, "id1", response.Results[0].Thing.Id)
+		assert.Equal(t, "<b>red</b> <b>box</b>", response.Results[0].Snippet)
+	}
+	assert.NotEmpty(t, response.NextPageToken)
+
+	// The next page resumes after the rank and id of the last result
+	ts.On("ThingSearch", mock.AnythingOfType("*context.emptyCtx"), &query.Query{
+		OrderBy: thingrpc.ThingSearchOrderBy,
+		After:   []interface{}{"0.1", "id1"},
+		Limit:   2,
+	}, "red box").Once().Return([]*thingrpc.ThingSearchResult{
+		{Thing: &thingrpc.Thing{Id: "id2", Name: "big red box"}, Rank: 0.05},
+	}, nil)
+
+	response, err = s.ThingSearch(context.Background(), &thingrpc.ThingSearchRequest{Q: "red box", PageSize: 1, PageToken: response.NextPageToken})
+	assert.Nil(t, err)
+	assert.Len(t, response.Results, 1)
+	assert.Empty(t, response.NextPageToken)
+
+	// Invalid requests are rejected before calling the store
+	for _, request := range []*thingrpc.ThingSearchRequest{
+		{},
+		{Q: "red", PageSize: -1},
+		{Q: "red", Filter: "name ="},
+		{Q: "red", PageToken: "nope"},
+		{Q: "red", PageToken: query.EncodePageToken(thingrpc.ThingSearchOrderBy, []interface{}{"high", "id1"})},
+		{Q: "red", PageToken: query.EncodePageToken([]query.OrderBy{{Field: "id"}}, []interface{}{"id1"})},
+	} {
+		_, err = s.ThingSearch(context.Background(), request)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), request.String())
+	}
+
+	// Stores without full-text search
+	ts.On("ThingSearch", mock.AnythingOfType("*context.emptyCtx"), mock.Anything, "red").Once().Return(nil, store.ErrUnimplemented)
+	_, err = s.ThingSearch(context.Background(), &thingrpc.ThingSearchRequest{Q: "red"})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
 
 	// Check remaining expectations
 	ts.AssertExpectations(t)
@@ -81,9 +317,9 @@ func TestServerThingGet(t *testing.T) {
 	}
 
 	// Mock call to item store
-	ts.On("ThingGetById", mock.AnythingOfType("*context.emptyCtx"), "1234").Once().Return(i, nil)
+	ts.On("ThingGetById", mock.AnythingOfType("*context.emptyCtx"), "1234", false).Once().Return(i, nil)
 
-	response, err := s.ThingGet(context.Background(), &thingrpc.ThingId{Id: "1234"})
+	response, err := s.ThingGet(context.Background(), &thingrpc.ThingGetRequest{Id: "1234"})
 	assert.Nil(t, err)
 	assert.Equal(t, i, response)
 
@@ -92,6 +328,88 @@ func TestServerThingGet(t *testing.T) {
 
 }
 
+func TestServerThingReadMask(t *testing.T) {
+
+	// Mock Store and server
+	ts := new(mocks.ThingStore)
+	s, err := New(ts)
+	assert.Nil(t, err)
+
+	i := []*thingrpc.Thing{
+		{Id: "id1", Name: "name1", Labels: map[string]string{"env": "prod"}, Etag: "1"},
+		{Id: "id2", Name: "name2", Labels: map[string]string{"env": "dev"}, Etag: "1"},
+	}
+	withFields := mock.MatchedBy(func(ctx context.Context) bool {
+		return assert.ObjectsAreEqual([]string{"name", "labels"}, store.Fields(ctx))
+	})
+	ts.On("ThingGetById", withFields, "id1", false).Once().Return(i[0], nil)
+
+	response, err := s.ThingGet(context.Background(), &thingrpc.ThingGetRequest{Id: "id1", ReadMask: &field_mask.FieldMask{Paths: []string{"name", "labels"}}})
+	assert.Nil(t, err)
+	assert.Equal(t, &thingrpc.Thing{Name: "name1", Labels: map[string]string{"env": "prod"}}, response)
+	assert.Equal(t, "id1", i[0].Id, "the stored thing is not changed")
+
+	// The store is told which fields are needed, the page token still uses the order by fields
+	orderBy := []query.OrderBy{{Field: "name"}, {Field: "id"}}
+	ts.On("ThingFind", mock.AnythingOfType("*context.emptyCtx"), &query.Query{
+		OrderBy: orderBy,
+		Limit:   2,
+		Fields:  []string{"labels"},
+	}).Once().Return(i, nil)
+
+	found, err := s.ThingFind(context.Background(), &thingrpc.ThingFindRequest{PageSize: 1, OrderBy: "name", ReadMask: &field_mask.FieldMask{Paths: []string{"labels", "labels"}}})
+	assert.Nil(t, err)
+	assert.Equal(t, []*thingrpc.Thing{{Labels: map[string]string{"env": "prod"}}}, found.Data)
+	assert.Equal(t, query.EncodePageToken(orderBy, []interface{}{"name1", "id1"}), found.NextPageToken)
+
+	// Unknown fields are rejected
+	_, err = s.ThingGet(context.Background(), &thingrpc.ThingGetRequest{Id: "id1", ReadMask: &field_mask.FieldMask{Paths: []string{"nope"}}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = s.ThingFind(context.Background(), &thingrpc.ThingFindRequest{ReadMask: &field_mask.FieldMask{Paths: []string{"attributes.color"}}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Check remaining expectations
+	ts.AssertExpectations(t)
+
+}
+
+func TestServerThingUpdate(t *testing.T) {
+
+	// Mock Store and server
+	ts := new(mocks.ThingStore)
+	s, err := New(ts)
+	assert.Nil(t, err)
+
+	// Create Item
+	i := &thingrpc.Thing{
+		Id:   "id",
+		Name: "name",
+	}
+
+	// Mock call to item store
+	ts.On("ThingUpdate", mock.AnythingOfType("*context.emptyCtx"), i, []string{"name"}).Once().Return(i, nil)
+	ts.On("ThingUpdate", mock.AnythingOfType("*context.emptyCtx"), i, []string{"attributes", "labels", "name"}).Once().Return(i, nil)
+
+	response, err := s.ThingUpdate(context.Background(), &thingrpc.ThingUpdateRequest{Thing: i, UpdateMask: &field_mask.FieldMask{Paths: []string{"name", "name"}}})
+	assert.Nil(t, err)
+	assert.Equal(t, i, response)
+
+	// No mask updates all fields
+	response, err = s.ThingUpdate(context.Background(), &thingrpc.ThingUpdateRequest{Thing: i})
+	assert.Nil(t, err)
+	assert.Equal(t, i, response)
+
+	// Unknown and immutable fields
+	_, err = s.ThingUpdate(context.Background(), &thingrpc.ThingUpdateRequest{Thing: i, UpdateMask: &field_mask.FieldMask{Paths: []string{"id"}}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = s.ThingUpdate(context.Background(), &thingrpc.ThingUpdateRequest{Thing: i, UpdateMask: &field_mask.FieldMask{Paths: []string{"nope"}}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Check remaining expectations
+	ts.AssertExpectations(t)
+
+}
+
 func TestServerThingDelete(t *testing.T) {
 
 	// Mock Store and server
@@ -100,10 +418,506 @@ func TestServerThingDelete(t *testing.T) {
 	assert.Nil(t, err)
 
 	// Mock call to item store
-	ts.On("ThingDeleteById", mock.AnythingOfType("*context.emptyCtx"), "1234").Once().Return(nil)
+	ts.On("ThingDeleteById", mock.AnythingOfType("*context.emptyCtx"), "1234", "").Once().Return(nil)
+
+	_, err = s.ThingDelete(context.Background(), &thingrpc.ThingDeleteRequest{Id: "1234"})
+	assert.Nil(t, err)
+
+	// Missing things are not found
+	ts.On("ThingDeleteById", mock.AnythingOfType("*context.emptyCtx"), "missing", "").Once().Return(store.ErrNotFound)
+
+	_, err = s.ThingDelete(context.Background(), &thingrpc.ThingDeleteRequest{Id: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// Check remaining expectations
+	ts.AssertExpectations(t)
+
+}
+
+func TestServerThingCreate(t *testing.T) {
+
+	// Mock Store and server
+	ts := new(mocks.ThingStore)
+	s, err := New(ts)
+	assert.Nil(t, err)
+
+	// Mock call to item store
+	ts.On("ThingCreate", mock.AnythingOfType("*context.emptyCtx"), &thingrpc.Thing{Name: "name"}).Once().Return("id", nil)
+	ts.On("ThingCreate", mock.AnythingOfType("*context.emptyCtx"), &thingrpc.Thing{Id: "id", Name: "name"}).Once().Return("id", store.ErrAlreadyExists)
+
+	response, err := s.ThingCreate(context.Background(), &thingrpc.Thing{Name: "name"})
+	assert.Nil(t, err)
+	assert.Equal(t, "id", response.Id)
+
+	_, err = s.ThingCreate(context.Background(), &thingrpc.Thing{Id: "id", Name: "name"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	// The catalog error and the conflicting field are in the details
+	ts.On("ThingSave", mock.AnythingOfType("*context.emptyCtx"), &thingrpc.Thing{Name: "taken"}).Once().Return("", &store.FieldError{Err: store.ErrAlreadyExists, Field: "name"})
+
+	_, err = s.ThingSave(context.Background(), &thingrpc.Thing{Name: "taken"})
+	st := status.Convert(err)
+	assert.Equal(t, codes.AlreadyExists, st.Code())
+	assert.Equal(t, "Already Exists: name", st.Message())
+	if assert.Len(t, st.Details(), 2) {
+		assert.Equal(t, "ALREADY_EXISTS", st.Details()[0].(*errdetails.ErrorInfo).Reason)
+		assert.Equal(t, "name", st.Details()[1].(*errdetails.BadRequest).FieldViolations[0].Field)
+	}
+
+	// Check remaining expectations
+	ts.AssertExpectations(t)
+
+}
+
+func TestServerThingReplace(t *testing.T) {
+
+	// Mock Store and server
+	ts := new(mocks.ThingStore)
+	s, err := New(ts)
+	assert.Nil(t, err)
+
+	// Every field is replaced
+	i := &thingrpc.Thing{Id: "id", Name: "name"}
+	ts.On("ThingUpdate", mock.AnythingOfType("*context.emptyCtx"), i, []string{"attributes", "labels", "name"}).Once().Return(i, nil)
+	ts.On("ThingUpdate", mock.AnythingOfType("*context.emptyCtx"), &thingrpc.Thing{Id: "missing"}, mock.Anything).Once().Return(nil, store.ErrNotFound)
+
+	response, err := s.ThingReplace(context.Background(), i)
+	assert.Nil(t, err)
+	assert.Equal(t, i, response)
+
+	_, err = s.ThingReplace(context.Background(), &thingrpc.Thing{Id: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = s.ThingReplace(context.Background(), &thingrpc.Thing{Name: "name"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Check remaining expectations
+	ts.AssertExpectations(t)
+
+}
+
+func TestServerThingGetAsOf(t *testing.T) {
+
+	// Mock Store and server
+	ts := new(mocks.ThingStore)
+	s, err := New(ts)
+	assert.Nil(t, err)
+
+	// Create Item
+	i := &thingrpc.Thing{
+		Id:   "id",
+		Name: "name",
+	}
+	asOf := time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)
+	asOfProto, _ := ptypes.TimestampProto(asOf)
+
+	// Mock call to item store
+	ts.On("ThingGetAsOf", mock.AnythingOfType("*context.emptyCtx"), "id", asOf, false).Once().Return(i, nil)
+
+	response, err := s.ThingGet(context.Background(), &thingrpc.ThingGetRequest{Id: "id", AsOf: asOfProto})
+	assert.Nil(t, err)
+	assert.Equal(t, i, response)
+
+	// Check remaining expectations
+	ts.AssertExpectations(t)
+
+}
+
+func TestServerThingListRevisions(t *testing.T) {
+
+	// Mock Store and server
+	ts := new(mocks.ThingStore)
+	s, err := New(ts)
+	assert.Nil(t, err)
+
+	// Create Item
+	i := []*thingrpc.ThingRevision{
+		&thingrpc.ThingRevision{Revision: "3", Action: "update"},
+		&thingrpc.ThingRevision{Revision: "2", Action: "update"},
+		&thingrpc.ThingRevision{Revision: "1", Action: "create"},
+	}
+
+	// Mock call to item store, one extra is requested to detect the next page
+	ts.On("ThingListRevisions", mock.AnythingOfType("*context.emptyCtx"), "id", int64(0), 3).Once().Return(i, nil)
+
+	response, err := s.ThingListRevisions(context.Background(), &thingrpc.ThingListRevisionsRequest{Id: "id", PageSize: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, i[:2], response.Data)
+
+	// Fetch the next page using the token
+	ts.On("ThingListRevisions", mock.AnythingOfType("*context.emptyCtx"), "id", int64(2), 3).Once().Return(i[2:], nil)
+
+	response, err = s.ThingListRevisions(context.Background(), &thingrpc.ThingListRevisionsRequest{Id: "id", PageSize: 2, PageToken: response.NextPageToken})
+	assert.Nil(t, err)
+	assert.Equal(t, i[2:], response.Data)
+	assert.Equal(t, "", response.NextPageToken)
+
+	// Check remaining expectations
+	ts.AssertExpectations(t)
+
+}
+
+func TestServerThingUndelete(t *testing.T) {
+
+	// Mock Store and server
+	ts := new(mocks.ThingStore)
+	s, err := New(ts)
+	assert.Nil(t, err)
+
+	// Create Item
+	i := &thingrpc.Thing{
+		Id:   "id",
+		Name: "name",
+	}
+
+	// Mock call to item store
+	ts.On("ThingUndeleteById", mock.AnythingOfType("*context.emptyCtx"), "id", "").Once().Return(i, nil)
+	ts.On("ThingUndeleteById", mock.AnythingOfType("*context.emptyCtx"), "nope", "").Once().Return(nil, store.ErrNotFound)
 
-	_, err = s.ThingDelete(context.Background(), &thingrpc.ThingId{Id: "1234"})
+	response, err := s.ThingUndelete(context.Background(), &thingrpc.ThingUndeleteRequest{Id: "id"})
 	assert.Nil(t, err)
+	assert.Equal(t, i, response)
+
+	_, err = s.ThingUndelete(context.Background(), &thingrpc.ThingUndeleteRequest{Id: "nope"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// Check remaining expectations
+	ts.AssertExpectations(t)
+
+}
+
+func TestServerThingEtag(t *testing.T) {
+
+	// Mock Store and server
+	ts := new(mocks.ThingStore)
+	s, err := New(ts)
+	assert.Nil(t, err)
+
+	// Create Item
+	i := &thingrpc.Thing{
+		Id:   "id",
+		Name: "name",
+		Etag: "2",
+	}
+
+	// Mock call to item store
+	ts.On("ThingSave", mock.AnythingOfType("*context.emptyCtx"), i).Once().Return(i.Id, store.ErrEtagMismatch)
+
+	_, err = s.ThingSave(context.Background(), i)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// The etag is taken from the If-Match header if not in the request
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("if-match", `"3"`))
+	ts.On("ThingDeleteById", ctx, "id", "3").Once().Return(store.ErrEtagMismatch)
+
+	_, err = s.ThingDelete(ctx, &thingrpc.ThingDeleteRequest{Id: "id"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// Weak etags never match
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("if-match", `W/"3"`))
+	_, err = s.ThingDelete(ctx, &thingrpc.ThingDeleteRequest{Id: "id"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// If-Match * requires the thing to exist
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("if-match", "*"))
+	ts.On("ThingDeleteById", ctx, "id", store.EtagAny).Once().Return(nil)
+
+	_, err = s.ThingDelete(ctx, &thingrpc.ThingDeleteRequest{Id: "id"})
+	assert.Nil(t, err)
+
+	ts.On("ThingUpdate", ctx, &thingrpc.Thing{Id: "missing", Name: "name", Etag: store.EtagAny}, []string{"name"}).Once().Return(nil, store.ErrNotFound)
+	_, err = s.ThingUpdate(ctx, &thingrpc.ThingUpdateRequest{Thing: &thingrpc.Thing{Id: "missing", Name: "name"}, UpdateMask: &field_mask.FieldMask{Paths: []string{"name"}}})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// Check remaining expectations
+	ts.AssertExpectations(t)
+
+}
+
+func TestServerThingIdempotencyKey(t *testing.T) {
+
+	// Mock Store and server
+	ts := new(mocks.ThingStore)
+	s, err := New(ts)
+	assert.Nil(t, err)
+
+	withKey := mock.MatchedBy(func(ctx context.Context) bool {
+		return store.IdempotencyKey(ctx) == "key1"
+	})
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("idempotency-key", "key1"))
+
+	// The key is passed to the store
+	ts.On("ThingSave", withKey, &thingrpc.Thing{Name: "name1"}).Once().Return("id1", nil)
+	response, err := s.ThingSave(ctx, &thingrpc.Thing{Name: "name1"})
+	assert.Nil(t, err)
+	assert.Equal(t, "id1", response.Id)
+
+	// Reused for a different change
+	ts.On("ThingDeleteById", withKey, "id2", "").Once().Return(store.ErrIdempotencyKeyReused)
+	_, err = s.ThingDelete(ctx, &thingrpc.ThingDeleteRequest{Id: "id2"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Too long
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("idempotency-key", strings.Repeat("k", maxIdempotencyKeyLength+1)))
+	_, err = s.ThingSave(ctx, &thingrpc.Thing{Name: "name1"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Check remaining expectations
+	ts.AssertExpectations(t)
+
+}
+
+func TestServerBatchGetThings(t *testing.T) {
+
+	// Mock Store and server
+	ts := new(mocks.ThingStore)
+	s, err := New(ts)
+	assert.Nil(t, err)
+
+	found := []*thingrpc.Thing{{Id: "id2", Name: "name2"}}
+	ts.On("ThingBatchGet", mock.AnythingOfType("*context.emptyCtx"), []string{"id1", "id2"}, false).Twice().Return(found, nil)
+
+	// Missing things fail the request
+	_, err = s.BatchGetThings(context.Background(), &thingrpc.BatchGetThingsRequest{Ids: []string{"id1", "id2"}})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// Unless partial results are allowed
+	response, err := s.BatchGetThings(context.Background(), &thingrpc.BatchGetThingsRequest{Ids: []string{"id1", "id2"}, AllowPartial: true})
+	assert.Nil(t, err)
+	if assert.Len(t, response.Results, 2) {
+		assert.Equal(t, "id1", response.Results[0].Id)
+		assert.Nil(t, response.Results[0].Thing)
+		assert.Equal(t, int32(codes.NotFound), response.Results[0].Error.Code)
+		assert.Equal(t, found[0], response.Results[1].Thing)
+		assert.Nil(t, response.Results[1].Error)
+	}
+
+	// Too many
+	_, err = s.BatchGetThings(context.Background(), &thingrpc.BatchGetThingsRequest{Ids: make([]string, maxBatchSize+1)})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Check remaining expectations
+	ts.AssertExpectations(t)
+
+}
+
+func TestServerBatchSaveThings(t *testing.T) {
+
+	// Mock Store and server
+	ts := new(mocks.ThingStore)
+	s, err := New(ts)
+	assert.Nil(t, err)
+
+	things := []*thingrpc.Thing{{Id: "id1", Name: "name1"}, {Id: "id2", Name: "name2", Etag: "1"}}
+	ts.On("ThingBatchSave", mock.AnythingOfType("*context.emptyCtx"), things, false).Once().Return([]error{nil, store.ErrEtagMismatch}, nil)
+	ts.On("ThingBatchSave", mock.AnythingOfType("*context.emptyCtx"), things, true).Once().Return([]error{nil, store.ErrEtagMismatch}, nil)
+
+	// All or nothing
+	_, err = s.BatchSaveThings(context.Background(), &thingrpc.BatchSaveThingsRequest{Things: things})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// Partial
+	response, err := s.BatchSaveThings(context.Background(), &thingrpc.BatchSaveThingsRequest{Things: things, AllowPartial: true})
+	assert.Nil(t, err)
+	if assert.Len(t, response.Results, 2) {
+		assert.Equal(t, "id1", response.Results[0].Id)
+		assert.Nil(t, response.Results[0].Error)
+		assert.Equal(t, "id2", response.Results[1].Id)
+		assert.Equal(t, int32(codes.FailedPrecondition), response.Results[1].Error.Code)
+	}
+
+	// Check remaining expectations
+	ts.AssertExpectations(t)
+
+}
+
+func TestServerBatchDeleteThings(t *testing.T) {
+
+	// Mock Store and server
+	ts := new(mocks.ThingStore)
+	s, err := New(ts)
+	assert.Nil(t, err)
+
+	ts.On("ThingBatchDelete", mock.AnythingOfType("*context.emptyCtx"), []string{"id1", "id2"}, true).Once().Return([]error{nil, nil}, nil)
+
+	response, err := s.BatchDeleteThings(context.Background(), &thingrpc.BatchDeleteThingsRequest{Ids: []string{"id1", "id2"}, AllowPartial: true})
+	assert.Nil(t, err)
+	assert.Len(t, response.Results, 2)
+
+	// Internal store errors are not returned to the client
+	ts.On("ThingBatchDelete", mock.AnythingOfType("*context.emptyCtx"), []string{"id3"}, true).Once().Return([]error{fmt.Errorf("pq: connection refused")}, nil)
+
+	response, err = s.BatchDeleteThings(context.Background(), &thingrpc.BatchDeleteThingsRequest{Ids: []string{"id3"}, AllowPartial: true})
+	assert.Nil(t, err)
+	if assert.Len(t, response.Results, 1) {
+		assert.Equal(t, int32(codes.Internal), response.Results[0].Error.Code)
+		assert.Equal(t, "Internal Error", response.Results[0].Error.Message)
+	}
+
+	// Empty IDs are invalid
+	_, err = s.BatchDeleteThings(context.Background(), &thingrpc.BatchDeleteThingsRequest{Ids: []string{""}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Check remaining expectations
+	ts.AssertExpectations(t)
+
+}
+
+// thingWatchStream collects the events sent by ThingWatch
+type thingWatchStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	events []*thingrpc.ThingEvent
+}
+
+func (s *thingWatchStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *thingWatchStream) Send(event *thingrpc.ThingEvent) error {
+	s.events = append(s.events, event)
+	return nil
+}
+
+func TestServerThingWatch(t *testing.T) {
+
+	// Mock Store and server
+	ts := new(mocks.ThingStore)
+	s, err := New(ts)
+	assert.Nil(t, err)
+
+	event := &thingrpc.ThingEvent{
+		Type:  thingrpc.ThingEvent_CREATED,
+		Thing: &thingrpc.Thing{Id: "id1", Name: "name1"},
+	}
+
+	// Mock call to item store, send one event from now
+	ts.On("ThingWatch", mock.AnythingOfType("*context.emptyCtx"), int64(0), mock.Anything).Once().Run(func(args mock.Arguments) {
+		send := args.Get(2).(func(int64, *thingrpc.ThingEvent) error)
+		assert.Nil(t, send(42, event))
+	}).Return(nil)
+
+	stream := &thingWatchStream{ctx: context.Background()}
+	err = s.ThingWatch(&thingrpc.ThingWatchRequest{}, stream)
+	assert.Nil(t, err)
+	if assert.Len(t, stream.events, 1) {
+		assert.Equal(t, "id1", stream.events[0].Thing.Id)
+		assert.NotEmpty(t, stream.events[0].ResumeToken)
+	}
+
+	// Resume after the event
+	ts.On("ThingWatch", mock.AnythingOfType("*context.emptyCtx"), int64(42), mock.Anything).Once().Return(nil)
+	err = s.ThingWatch(&thingrpc.ThingWatchRequest{ResumeToken: stream.events[0].ResumeToken}, stream)
+	assert.Nil(t, err)
+
+	// Bad token
+	err = s.ThingWatch(&thingrpc.ThingWatchRequest{ResumeToken: "garbage!"}, stream)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Check remaining expectations
+	ts.AssertExpectations(t)
+
+}
+
+// thingImportStream sends things to ImportThings and collects the response
+type thingImportStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	things   []*thingrpc.Thing
+	response *thingrpc.ImportThingsResponse
+}
+
+func (s *thingImportStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *thingImportStream) Recv() (*thingrpc.Thing, error) {
+	if len(s.things) == 0 {
+		return nil, io.EOF
+	}
+	t := s.things[0]
+	s.things = s.things[1:]
+	return t, nil
+}
+
+func (s *thingImportStream) SendAndClose(response *thingrpc.ImportThingsResponse) error {
+	s.response = response
+	return nil
+}
+
+func TestServerImportThings(t *testing.T) {
+
+	// Mock Store and server
+	ts := new(mocks.ThingStore)
+	s, err := New(ts)
+	assert.Nil(t, err)
+
+	things := make([]*thingrpc.Thing, importBatchSize+1)
+	for i := range things {
+		things[i] = &thingrpc.Thing{Id: fmt.Sprintf("id%d", i), Name: "name"}
+	}
+
+	// Mock call to item store, one full batch and the remainder
+	ts.On("ThingImport", mock.Anything, mock.AnythingOfType("[]*thingrpc.Thing")).Twice().Return(nil)
+
+	stream := &thingImportStream{ctx: context.Background(), things: things}
+	err = s.ImportThings(stream)
+	assert.Nil(t, err)
+	if assert.NotNil(t, stream.response) {
+		assert.Equal(t, int64(importBatchSize+1), stream.response.Imported)
+	}
+
+	// Invalid labels
+	stream = &thingImportStream{ctx: context.Background(), things: []*thingrpc.Thing{{Name: "name", Labels: map[string]string{"bad key": "x"}}}}
+	err = s.ImportThings(stream)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Check remaining expectations
+	ts.AssertExpectations(t)
+
+}
+
+// thingExportStream collects the things sent by ExportThings
+type thingExportStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	things []*thingrpc.Thing
+}
+
+func (s *thingExportStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *thingExportStream) Send(t *thingrpc.Thing) error {
+	s.things = append(s.things, t)
+	return nil
+}
+
+func TestServerExportThings(t *testing.T) {
+
+	// Mock Store and server
+	ts := new(mocks.ThingStore)
+	s, err := New(ts)
+	assert.Nil(t, err)
+
+	// Mock call to item store, send two things
+	ts.On("ThingExport", mock.AnythingOfType("*context.emptyCtx"), mock.AnythingOfType("*query.Query"), mock.Anything).Once().Run(func(args mock.Arguments) {
+		q := args.Get(1).(*query.Query)
+		assert.NotNil(t, q.Filter)
+		assert.Len(t, q.Labels, 1)
+		send := args.Get(2).(func(*thingrpc.Thing) error)
+		assert.Nil(t, send(&thingrpc.Thing{Id: "id1"}))
+		assert.Nil(t, send(&thingrpc.Thing{Id: "id2"}))
+	}).Return(nil)
+
+	stream := &thingExportStream{ctx: context.Background()}
+	err = s.ExportThings(&thingrpc.ExportThingsRequest{Filter: `name = "foo"`, LabelSelector: "env=prod"}, stream)
+	assert.Nil(t, err)
+	assert.Len(t, stream.things, 2)
+
+	// Bad filter
+	err = s.ExportThings(&thingrpc.ExportThingsRequest{Filter: "nope"}, stream)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
 
 	// Check remaining expectations
 	ts.AssertExpectations(t)